@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rivo/tview"
+)
+
+// How long an incoming call rings before it is refused
+const answerTimeout = 30 * time.Second
+
+var modeNames = map[ConnectionMode]string{
+	TextConnection:         "chat",
+	VoiceConnectionSimplex: "call",
+	VoiceConnectionDuplex:  "call",
+	VideoConnectionSimplex: "video call",
+}
+
+// askToAnswer holds the offer of an incoming call until the user accepts or
+// rejects it, nothing is sent to the remote before then. Calls left ringing
+// for answerTimeout are refused, or go to voicemail after VoicemailAfter if
+// it is on
+func (conn *Connection) askToAnswer(signal SignalSDP) {
+	conn.offer = &signal
+	log.Printf("%s from %s, /accept %s or /reject %s\n",
+		modeNames[conn.mode], conn.local.displayName(conn.remoteAddr),
+		conn, conn)
+	// Before the dialog takes the focus from the input
+	notify(eventCall, "Incoming "+modeNames[conn.mode],
+		conn.local.displayName(conn.remoteAddr))
+	if callPrompts != nil {
+		// The dialog renders style tags, and the caller picks its Origin
+		// and name
+		callPrompts.show(conn.remoteAddr, fmt.Sprintf("%s from\n%s",
+			modeNames[conn.mode],
+			tview.Escape(conn.local.callerIdentity(signal))))
+	}
+	timeout := answerTimeout
+	voicemail := conn.local.takesVoicemail(conn)
+	if voicemail {
+		timeout = conn.local.VoicemailAfter
+	}
+	time.AfterFunc(timeout, func() {
+		if conn.offer != &signal {
+			return
+		}
+		if voicemail {
+			conn.takeVoicemail(signal)
+			return
+		}
+		log.Println("missed", modeNames[conn.mode], "from", conn)
+		conn.refuse(RefuseDeclined)
+	})
+	if conn.local.previews(conn) {
+		conn.answerPreview(signal)
+	}
+}
+
+// incomingCall returns the incoming call from remote waiting for an answer
+func (peer *RTCPeer) incomingCall(remote string) (*Connection, bool) {
+	conn, ok := peer.Connections[remote]
+	if !ok || conn.offer == nil || conn.state != Answering {
+		log.Println("no incoming call from", remote)
+		return nil, false
+	}
+	return conn, true
+}
+
+// Accept answers the incoming call from remote
+func (peer *RTCPeer) Accept(remote string) {
+	conn, ok := peer.incomingCall(remote)
+	if !ok {
+		return
+	}
+	signal := *conn.offer
+	conn.offer = nil
+	if callPrompts != nil {
+		callPrompts.dismiss(remote)
+	}
+	log.Println("answering", conn)
+	if conn.previewing {
+		conn.acceptPreview()
+		return
+	}
+	conn.completeSignal(signal)
+}
+
+// Reject refuses the incoming call from remote
+func (peer *RTCPeer) Reject(remote string) {
+	conn, ok := peer.incomingCall(remote)
+	if !ok {
+		return
+	}
+	log.Println("rejected", modeNames[conn.mode], "from", conn)
+	conn.outcome = OutcomeRejected
+	conn.refuse(RefuseDeclined)
+}
+
+// ringUntil gives up calling after timeout if the remote hasn't answered,
+// withdrawing the offer so that the remote stops ringing too
+func (conn *Connection) ringUntil(timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	time.AfterFunc(timeout, func() {
+		if conn.state != Ringing {
+			return
+		}
+		log.Println(conn, "didn't answer")
+		cancel := SignalSDP{
+			Action:      Cancel,
+			Origin:      conn.local.listenAddr,
+			SignalStamp: newSignalStamp(),
+		}
+		// The remote checks that it has the certificate of the offer
+		if offer := conn.peer.LocalDescription(); offer != nil {
+			cancel.SDP = *offer
+		}
+		err := postSignal(conn.remoteAddr, cancel)
+		if err != nil {
+			logError("couldn't withdraw the call to", conn, ":", err)
+		}
+		conn.Close()
+	})
+}
+
+// handleCancel stops ringing for a call the caller gave up on. The cancel
+// has to carry the certificate of the offer, or anybody could hang up the
+// calls we get by claiming to be the caller
+func (peer *RTCPeer) handleCancel(signal SignalSDP) {
+	conn, ok := peer.Connections[signal.Origin]
+	if !ok || conn.state != Answering || conn.offer == nil {
+		return
+	}
+	fp := offerFingerprint(*conn.offer)
+	if fp == "" || offerFingerprint(signal) != fp {
+		logWarn("ignored a cancel claiming to come from", conn,
+			"with another certificate")
+		return
+	}
+	log.Println("missed", modeNames[conn.mode], "from", conn)
+	conn.offer = nil
+	conn.Close()
+}
+
+// refuse tells the remote we won't answer, and why, and drops the connection
+func (conn *Connection) refuse(reason RefuseReason) {
+	conn.offer = nil
+	err := postSignal(conn.remoteAddr, SignalSDP{
+		Action:      Refuse,
+		Reason:      reason,
+		Origin:      conn.local.listenAddr,
+		Fingerprint: conn.local.ownFingerprint(),
+		SignalStamp: newSignalStamp(),
+	})
+	if err != nil {
+		logError("couldn't refuse the call from", conn, ":", err)
+	}
+	conn.Close()
+}
+
+// busy tells a remote calling us again while we are already connected to it
+// that we can't answer, without touching the connection we have
+func (conn *Connection) busy(remote string) {
+	err := postSignal(remote, SignalSDP{
+		Action:      Refuse,
+		Reason:      RefuseBusy,
+		Origin:      conn.local.listenAddr,
+		Fingerprint: conn.local.ownFingerprint(),
+		SignalStamp: newSignalStamp(),
+	})
+	if err != nil {
+		logError("couldn't tell", remote, "we are busy:", err)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// ConnectionInfo is a connection as listed by /connections
+type ConnectionInfo struct {
+	Remote string
+	// Name is the name of the contact, or else ~ and the one the peer told
+	Name     string
+	State    string
+	Mode     string
+	Outgoing bool
+	Muted    bool
+	// Duration is zero until the call is connected
+	Duration time.Duration
+}
+
+// ConnectionStats is the body of the responses of /stats
+type ConnectionStats struct {
+	Remote string
+	// Quality is that of the audio, VideoQuality that of the video if any
+	Quality      CallStats
+	VideoQuality *CallStats `json:",omitempty"`
+	Report       webrtc.StatsReport
+}
+
+// CallRequest is the body of the requests to /call. Mode is call, video or
+// chat, call by default
+type CallRequest struct {
+	Remote string
+	Mode   string
+}
+
+// PeerRequest is the body of the requests to /accept, /reject and /hangup
+type PeerRequest struct {
+	Remote string
+}
+
+// MessageRequest is the body of the requests to /msg
+type MessageRequest struct {
+	Remote string
+	Text   string
+}
+
+var callModes = map[string]ConnectionMode{
+	"":      VoiceConnectionSimplex,
+	"call":  VoiceConnectionSimplex,
+	"video": VideoConnectionSimplex,
+	"chat":  TextConnection,
+}
+
+var (
+	errNotConnected = errors.New("not connected to remote")
+	errNoCall       = errors.New("no incoming call from remote")
+	errNotInCall    = errors.New("call not connected yet")
+	errCallFailed   = errors.New("unable to call remote")
+)
+
+// apiError is an error with the HTTP status it is answered with
+type apiError struct {
+	status int
+	err    error
+}
+
+func (e apiError) Error() string {
+	return e.err.Error()
+}
+
+func connectionInfo(peer *RTCPeer, conn *Connection) ConnectionInfo {
+	var name string
+	if conn.remoteName != "" {
+		name = selfDeclared(conn.remoteName)
+	}
+	if c, ok := peer.contact(conn.remoteAddr); ok && c.Name != "" {
+		name = c.Name
+	}
+	return ConnectionInfo{
+		Remote:   conn.remoteAddr,
+		Name:     name,
+		State:    stateNames[conn.state],
+		Mode:     modeNames[conn.mode],
+		Outgoing: conn.isInitiator,
+		Muted:    conn.muted,
+		Duration: conn.duration(),
+	}
+}
+
+// act decodes the JSON body of a POST to r into req and runs do with it, one
+// at a time like the commands. The result of do, if not nil, is the body of
+// the response. Bodies have to be sent as JSON, which browsers don't do for
+// other sites without asking us first with a preflight
+func (ctl *controlServer) act(
+	w http.ResponseWriter,
+	r *http.Request,
+	req interface{},
+	do func() (interface{}, error),
+) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ctl.authorized(r) {
+		http.Error(w, errUnauthorized.Error(), http.StatusUnauthorized)
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		http.Error(w, "body must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctl.mu.Lock()
+	res, err := do()
+	ctl.mu.Unlock()
+	if e, ok := err.(apiError); ok {
+		http.Error(w, e.Error(), e.status)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if res == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// connection returns the connection to remote, which can be a contact name
+func (ctl *controlServer) connection(remote string) (*Connection, error) {
+	conn, ok := ctl.rtcpeer.Connections[ctl.rtcpeer.contactAddress(remote)]
+	if !ok {
+		return nil, apiError{http.StatusNotFound, errNotConnected}
+	}
+	return conn, nil
+}
+
+// incomingCall returns the call from remote waiting to be answered
+func (ctl *controlServer) incomingCall(remote string) (*Connection, error) {
+	conn, err := ctl.connection(remote)
+	if err != nil {
+		return nil, err
+	}
+	if conn.offer == nil || conn.state != Answering {
+		return nil, apiError{http.StatusConflict, errNoCall}
+	}
+	return conn, nil
+}
+
+// connections lists the connections, sorted by address
+func (ctl *controlServer) connections() []ConnectionInfo {
+	var conns []ConnectionInfo
+	for _, entry := range ctl.rtcpeer.sidebarEntries() {
+		ctl.rtcpeer.connsMu.RLock()
+		conn, ok := ctl.rtcpeer.Connections[entry.remote]
+		ctl.rtcpeer.connsMu.RUnlock()
+		// Closed since the entries were listed
+		if !ok {
+			continue
+		}
+		conns = append(conns, connectionInfo(ctl.rtcpeer, conn))
+	}
+	return conns
+}
+
+// The actions below are shared by the JSON endpoints and the gRPC service,
+// and are run with the lock of ctl held
+
+func (ctl *controlServer) call(remote string, mode ConnectionMode) (*Connection, error) {
+	remote = ctl.rtcpeer.contactAddress(remote)
+	conn := ctl.rtcpeer.RingWith(remote, mode, ctl.rtcpeer.DefaultSettings())
+	if conn == nil {
+		return nil, apiError{http.StatusBadGateway, errCallFailed}
+	}
+	return conn, nil
+}
+
+func (ctl *controlServer) accept(remote string) error {
+	conn, err := ctl.incomingCall(remote)
+	if err != nil {
+		return err
+	}
+	ctl.rtcpeer.Accept(conn.remoteAddr)
+	return nil
+}
+
+func (ctl *controlServer) reject(remote string) error {
+	conn, err := ctl.incomingCall(remote)
+	if err != nil {
+		return err
+	}
+	ctl.rtcpeer.Reject(conn.remoteAddr)
+	return nil
+}
+
+func (ctl *controlServer) hangUp(remote string) error {
+	conn, err := ctl.connection(remote)
+	if err != nil {
+		return err
+	}
+	ctl.rtcpeer.HangUp(conn.remoteAddr)
+	return nil
+}
+
+func (ctl *controlServer) sendMessage(remote, text string) error {
+	if text == "" {
+		return apiError{http.StatusBadRequest, errBadParam("Text")}
+	}
+	conn, err := ctl.connection(remote)
+	if err != nil {
+		return err
+	}
+	if conn.state != InCall {
+		return apiError{http.StatusConflict, errNotInCall}
+	}
+	conn.SendMsg(ChatText, text)
+	return nil
+}
+
+// httpHandleConnections lists the connections, sorted by address
+func (ctl *controlServer) httpHandleConnections(w http.ResponseWriter, r *http.Request) {
+	ctl.query(w, r, func() (interface{}, int, error) {
+		ctl.mu.Lock()
+		conns := ctl.connections()
+		ctl.mu.Unlock()
+		start, end, err := paginate(r, len(conns))
+		if err != nil {
+			return nil, 0, err
+		}
+		return conns[start:end], len(conns), nil
+	})
+}
+
+// httpHandleStats serves the quality figures and the WebRTC stats of the
+// connection to the remote parameter
+func (ctl *controlServer) httpHandleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ctl.authorized(r) {
+		http.Error(w, errUnauthorized.Error(), http.StatusUnauthorized)
+		return
+	}
+	ctl.mu.Lock()
+	conn, err := ctl.connection(r.URL.Query().Get("remote"))
+	ctl.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	stats := ConnectionStats{
+		Remote:  conn.remoteAddr,
+		Quality: conn.Stats(),
+		Report:  conn.peer.GetStats(),
+	}
+	if conn.videoSndr != nil {
+		video := conn.VideoStats()
+		stats.VideoQuality = &video
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// httpHandleCall places a call and answers with the new connection
+func (ctl *controlServer) httpHandleCall(w http.ResponseWriter, r *http.Request) {
+	var req CallRequest
+	ctl.act(w, r, &req, func() (interface{}, error) {
+		mode, ok := callModes[req.Mode]
+		if !ok {
+			return nil, apiError{http.StatusBadRequest, errBadParam("Mode")}
+		}
+		conn, err := ctl.call(req.Remote, mode)
+		if err != nil {
+			return nil, err
+		}
+		return connectionInfo(ctl.rtcpeer, conn), nil
+	})
+}
+
+func (ctl *controlServer) httpHandleAccept(w http.ResponseWriter, r *http.Request) {
+	var req PeerRequest
+	ctl.act(w, r, &req, func() (interface{}, error) {
+		return nil, ctl.accept(req.Remote)
+	})
+}
+
+func (ctl *controlServer) httpHandleReject(w http.ResponseWriter, r *http.Request) {
+	var req PeerRequest
+	ctl.act(w, r, &req, func() (interface{}, error) {
+		return nil, ctl.reject(req.Remote)
+	})
+}
+
+func (ctl *controlServer) httpHandleHangUp(w http.ResponseWriter, r *http.Request) {
+	var req PeerRequest
+	ctl.act(w, r, &req, func() (interface{}, error) {
+		return nil, ctl.hangUp(req.Remote)
+	})
+}
+
+func (ctl *controlServer) httpHandleMsg(w http.ResponseWriter, r *http.Request) {
+	var req MessageRequest
+	ctl.act(w, r, &req, func() (interface{}, error) {
+		return nil, ctl.sendMessage(req.Remote, req.Text)
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// autoAnswers tells whether the call offered by signal is answered without
+// asking: every call with AutoAnswer, or those of the peers picked with
+// /autoanswer or -auto-answer-from. Their certificate has to be the one we
+// know for them, since anybody can claim an address
+func (peer *RTCPeer) autoAnswers(signal SignalSDP) bool {
+	if peer.AutoAnswer {
+		return true
+	}
+	contact, isContact := peer.contact(signal.Origin)
+	if !peer.AutoAnswerFrom[signal.Origin] &&
+		!(isContact && contact.AutoAnswer) {
+		return false
+	}
+	known, ok := peer.trusted[signal.Origin]
+	if !ok && isContact && contact.Fingerprint != "" {
+		known, ok = contact.Fingerprint, true
+	}
+	if !ok {
+		log.Println(signal.Origin,
+			"has no trusted certificate, so it isn't answered automatically")
+		return false
+	}
+	if offerFingerprint(signal) != known {
+		log.Println("the certificate of", signal.Origin,
+			"isn't the trusted one, so it isn't answered automatically")
+		return false
+	}
+	return true
+}
+
+// contact returns the contact at address
+func (peer *RTCPeer) contact(address string) (Contact, bool) {
+	for _, c := range peer.contacts {
+		if c.Address == address {
+			return c, true
+		}
+	}
+	return Contact{}, false
+}
+
+// SetAutoAnswer answers the calls of the contact at address without asking,
+// or stops doing so
+func (peer *RTCPeer) SetAutoAnswer(address string, on bool) {
+	for i, c := range peer.contacts {
+		if c.Address != address {
+			continue
+		}
+		peer.contacts[i].AutoAnswer = on
+		if err := saveContacts(peer.contacts); err != nil {
+			logError("couldn't save contacts:", err)
+			return
+		}
+		if on {
+			log.Println("calls from", peer.displayName(address),
+				"are answered automatically")
+		} else {
+			log.Println("calls from", peer.displayName(address),
+				"ring as usual")
+		}
+		return
+	}
+	log.Println(address, "isn't a contact, /add it first")
+}
+
+// parseAutoAnswerFrom reads the addresses or contact names of
+// -auto-answer-from
+func (peer *RTCPeer) parseAutoAnswerFrom(list string) {
+	if peer.AutoAnswerFrom == nil {
+		peer.AutoAnswerFrom = make(map[string]bool)
+	}
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			peer.AutoAnswerFrom[peer.contactAddress(name)] = true
+		}
+	}
+}
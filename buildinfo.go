@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/Yaroslav-95/wrtcion/gst"
+)
+
+// commit is set when building, with -ldflags "-X main.commit=$(git rev-parse
+// --short HEAD)"
+var commit = "unknown"
+
+// BuildInfo describes how a wrtcion binary was built. It is exchanged while
+// signaling, to help debug problems between different builds
+type BuildInfo struct {
+	Version   string
+	Commit    string
+	Go        string
+	WebRTC    string
+	GStreamer string
+	// Tags are the build tags the binary was built with. Binaries built
+	// with Go before 1.18 only tell whether they have jack
+	Tags []string
+}
+
+func (b *BuildInfo) String() string {
+	tags := "none"
+	if len(b.Tags) > 0 {
+		tags = strings.Join(b.Tags, ",")
+	}
+	return fmt.Sprintf(
+		"wrtcion %s (commit %s), %s, pion/webrtc %s, %s, build tags: %s",
+		b.Version, b.Commit, b.Go, b.WebRTC, b.GStreamer, tags,
+	)
+}
+
+// localBuildInfo describes this binary
+func localBuildInfo() *BuildInfo {
+	info := &BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		Go:        runtime.Version(),
+		WebRTC:    "unknown",
+		GStreamer: gst.Version(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range bi.Deps {
+			if dep.Path == "github.com/pion/webrtc/v3" {
+				info.WebRTC = dep.Version
+			}
+		}
+		info.Tags = buildTags(bi)
+	}
+	if gst.JACK && !hasTag(info.Tags, "jack") {
+		info.Tags = append(info.Tags, "jack")
+	}
+	return info
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// setBuild stores what the remote of conn told about its build, older
+// clients don't tell
+func (conn *Connection) setBuild(build *BuildInfo) {
+	if build == nil {
+		delete(conn.local.builds, conn.remoteAddr)
+		return
+	}
+	conn.local.builds[conn.remoteAddr] = build
+}
+
+// Version logs how this binary was built
+func (peer *RTCPeer) Version() {
+	log.Println(localBuildInfo())
+}
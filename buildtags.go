@@ -0,0 +1,19 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import (
+	"runtime/debug"
+	"strings"
+)
+
+// buildTags returns the tags given to go build, as recorded in the binary
+func buildTags(bi *debug.BuildInfo) []string {
+	for _, s := range bi.Settings {
+		if s.Key == "-tags" && s.Value != "" {
+			return strings.Split(s.Value, ",")
+		}
+	}
+	return nil
+}
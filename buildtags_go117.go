@@ -0,0 +1,12 @@
+//go:build !go1.18
+// +build !go1.18
+
+package main
+
+import "runtime/debug"
+
+// buildTags returns nothing, Go releases before 1.18 don't record the tags
+// given to go build
+func buildTags(bi *debug.BuildInfo) []string {
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/rivo/tview"
+)
+
+// callPrompts are the dialogs of the full screen interface asking whether
+// to answer incoming calls, nil elsewhere
+var callPrompts *prompts
+
+// prompts shows a dialog for every incoming call over the main screen, with
+// buttons to accept, reject or ignore it. Ignored calls keep ringing and can
+// still be answered with /accept until they time out
+type prompts struct {
+	tapp  *tview.Application
+	pages *tview.Pages
+	back  tview.Primitive
+	exec  func(cmd string)
+	// shown are the remotes with a dialog, the last one has the focus
+	shown []string
+}
+
+func newPrompts(
+	tapp *tview.Application,
+	pages *tview.Pages,
+	back tview.Primitive,
+	exec func(cmd string),
+) *prompts {
+	return &prompts{tapp: tapp, pages: pages, back: back, exec: exec}
+}
+
+// show asks whether to answer the call from remote, described by text
+func (p *prompts) show(remote, text string) {
+	p.tapp.QueueUpdateDraw(func() {
+		if p.pages.HasPage(promptPage(remote)) {
+			return
+		}
+		modal := tview.NewModal().
+			SetText(text).
+			AddButtons([]string{"Accept", "Reject", "Ignore"}).
+			SetDoneFunc(func(_ int, label string) {
+				p.remove(remote)
+				switch label {
+				case "Accept":
+					p.exec("/accept " + remote)
+				case "Reject":
+					p.exec("/reject " + remote)
+				}
+			})
+		p.shown = append(p.shown, remote)
+		p.pages.AddPage(promptPage(remote), modal, true, true)
+		p.tapp.SetFocus(modal)
+	})
+}
+
+// dismiss takes down the dialog of the call from remote, if there is one.
+// /accept and /reject call it from the event loop, so it doesn't wait
+func (p *prompts) dismiss(remote string) {
+	go p.tapp.QueueUpdateDraw(func() {
+		p.remove(remote)
+	})
+}
+
+// remove takes down the dialog of remote and gives the focus to the one
+// left on top. It has to be called from the event loop
+func (p *prompts) remove(remote string) {
+	for i, r := range p.shown {
+		if r == remote {
+			p.shown = append(p.shown[:i], p.shown[i+1:]...)
+			break
+		}
+	}
+	p.pages.RemovePage(promptPage(remote))
+	if len(p.shown) == 0 {
+		p.tapp.SetFocus(p.back)
+		return
+	}
+	p.pages.SendToFront(promptPage(p.shown[len(p.shown)-1]))
+	if _, item := p.pages.GetFrontPage(); item != nil {
+		p.tapp.SetFocus(item)
+	}
+}
+
+func promptPage(remote string) string {
+	return "call " + remote
+}
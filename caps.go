@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+)
+
+const capsPath = outputPath + "peers.json"
+
+// Capability is a feature advertised to peers during signaling, so that we
+// don't send them anything they wouldn't understand
+type Capability string
+
+const (
+	// CapControl is the support for binary control messages in the data
+	// channel. Clients without it show them as chat
+	CapControl Capability = "control"
+	CapFiles   Capability = "files"
+	CapVideo   Capability = "video"
+	// CapEnvelope is the support for typed envelopes around data channel
+	// messages
+	CapEnvelope Capability = "envelope"
+	// CapChannels is the support for data channels opened with /channel,
+	// which older clients would take for the chat channel
+	CapChannels Capability = "channels"
+	// CapChat is the support for structured chat messages, with an ID,
+	// a timestamp and the name of the sender
+	CapChat     Capability = "chat"
+	CapReceipts Capability = "receipts"
+	// CapClipboard is the support for clipboards shared with /paste
+	CapClipboard Capability = "clipboard"
+	// CapE2E is the support for end-to-end encrypted messages
+	CapE2E Capability = "e2e"
+	// CapCompress is the support for compressed messages
+	CapCompress Capability = "compress"
+	// CapTransfer is the support for calls transferred with /transfer
+	CapTransfer Capability = "transfer"
+	// CapPreview is advertised by callers that let the callee see their
+	// video while the call rings
+	CapPreview Capability = "preview"
+)
+
+var capNames = map[Capability]string{
+	CapControl:   "call control messages",
+	CapFiles:     "file transfer",
+	CapVideo:     "video calls",
+	CapEnvelope:  "typed data channel messages",
+	CapChannels:  "extra data channels",
+	CapChat:      "structured chat messages",
+	CapReceipts:  "message receipts",
+	CapClipboard: "clipboard sharing",
+	CapE2E:       "end-to-end encrypted messages",
+	CapCompress:  "compressed messages",
+	CapTransfer:  "call transfer",
+	CapPreview:   "video previews while ringing",
+}
+
+var errUnsupported = errors.New("not supported by the peer")
+
+// localCaps returns the capabilities we advertise
+func (peer *RTCPeer) localCaps() []Capability {
+	caps := []Capability{
+		CapControl,
+		CapVideo,
+		CapEnvelope,
+		CapChannels,
+		CapChat,
+		CapReceipts,
+		CapClipboard,
+		CapE2E,
+		CapCompress,
+		CapTransfer,
+	}
+	if peer.AcceptFiles {
+		caps = append(caps, CapFiles)
+	}
+	if peer.VideoPreview {
+		caps = append(caps, CapPreview)
+	}
+	return caps
+}
+
+// loadCaps reads the capabilities of the peers we have talked to before
+func (peer *RTCPeer) loadCaps() {
+	data, err := os.ReadFile(capsPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logError("couldn't read peer capabilities:", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &peer.caps); err != nil {
+		logError("couldn't parse peer capabilities:", err)
+	}
+}
+
+// learnCaps caches what remote advertised. Older clients don't advertise
+// anything, so they end up with no capabilities at all
+func (peer *RTCPeer) learnCaps(remote string, caps []Capability) {
+	if caps == nil {
+		caps = []Capability{}
+	}
+	peer.caps[remote] = caps
+	data, err := json.MarshalIndent(peer.caps, "", "\t")
+	if err != nil {
+		logError("couldn't save peer capabilities:", err)
+		return
+	}
+	if err := os.MkdirAll(outputPath, 0755); err != nil {
+		logError("couldn't save peer capabilities:", err)
+		return
+	}
+	if err := os.WriteFile(capsPath, data, 0644); err != nil {
+		logError("couldn't save peer capabilities:", err)
+	}
+}
+
+// setCaps stores the capabilities the remote of conn advertised while
+// signaling. If there were none, the remote is an older client and the
+// connection falls back to legacy mode
+func (conn *Connection) setCaps(caps []Capability) {
+	conn.local.learnCaps(conn.remoteAddr, caps)
+	conn.legacy = len(caps) == 0
+	if conn.legacy {
+		logWarn(conn, "runs an older version, using legacy mode:",
+			"only chat and calls are available")
+	}
+}
+
+// knownLegacy tells whether remote is known to run an older client, one
+// that advertised no capabilities when we last talked to it
+func (peer *RTCPeer) knownLegacy(remote string) bool {
+	caps, ok := peer.caps[remote]
+	return ok && len(caps) == 0
+}
+
+// supports tells whether remote supports c. Peers we haven't talked to yet
+// are given the benefit of the doubt
+func (peer *RTCPeer) supports(remote string, c Capability) bool {
+	caps, ok := peer.caps[remote]
+	if !ok {
+		return true
+	}
+	for _, have := range caps {
+		if have == c {
+			return true
+		}
+	}
+	return false
+}
+
+// requireCap is like supports, but tells the user when remote lacks c
+func (peer *RTCPeer) requireCap(remote string, c Capability) bool {
+	if peer.supports(remote, c) {
+		return true
+	}
+	log.Printf("%s doesn't support %s\n", remote, capNames[c])
+	return false
+}
+
+// Caps logs the capabilities known for remote
+func (peer *RTCPeer) Caps(remote string) {
+	caps, ok := peer.caps[remote]
+	if !ok {
+		log.Println("never talked to", remote, "so its capabilities are unknown")
+		return
+	}
+	if len(caps) == 0 {
+		log.Println(remote, "is an older client, only chat and calls work")
+		return
+	}
+	names := make([]string, len(caps))
+	for i, c := range caps {
+		if name, ok := capNames[c]; ok {
+			names[i] = name
+		} else {
+			names[i] = string(c)
+		}
+	}
+	log.Println(remote, "supports", strings.Join(names, ", "))
+	if build, ok := peer.builds[remote]; ok {
+		log.Println(remote, "runs", build)
+	}
+}
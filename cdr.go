@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const cdrPath = outputPath + "calls.jsonl"
+
+// How many calls /history shows
+const historyLength = 20
+
+// CallOutcome is how a call ended up
+type CallOutcome string
+
+const (
+	OutcomeAnswered CallOutcome = "answered"
+	// OutcomeMissed is an incoming call nobody answered
+	OutcomeMissed CallOutcome = "missed"
+	// OutcomeUnanswered is an outgoing call nobody answered
+	OutcomeUnanswered CallOutcome = "unanswered"
+	OutcomeRejected   CallOutcome = "rejected"
+	OutcomeBusy       CallOutcome = "busy"
+	OutcomeFailed     CallOutcome = "failed"
+	// OutcomeUnavailable is a call refused in do not disturb mode
+	OutcomeUnavailable CallOutcome = "unavailable"
+	// OutcomeVoicemail is an incoming call that went to voicemail
+	OutcomeVoicemail CallOutcome = "voicemail"
+)
+
+// CallRecord is a call detail record. One is appended to cdrPath as a line of
+// JSON every time a connection is closed
+type CallRecord struct {
+	Peer     string
+	Outgoing bool
+	Mode     ConnectionMode
+	// Start and End are in UTC, and rendered in local time when shown
+	Start time.Time
+	End   time.Time
+	// PeerZone is the time zone the peer advertised, nil if it didn't
+	PeerZone *TimeZone
+	// Interrupted is how long the call was cut off by suspends of the
+	// system. A call that didn't survive one ends when it started
+	Interrupted time.Duration `json:",omitempty"`
+	// Duration is how long the call went on for, without interruptions
+	Duration time.Duration
+	// Outcome is empty in the records of older versions
+	Outcome CallOutcome `json:",omitempty"`
+	// Stats is the last stats snapshot taken right before closing the peer
+	// connection
+	Stats webrtc.StatsReport
+	// Rating and Note are only filled in if the user answered the end-of-call
+	// survey
+	Rating int
+	Note   string
+}
+
+func appendJSONLine(path string, v interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(v)
+}
+
+func (conn *Connection) callRecord() *CallRecord {
+	end := time.Now()
+	if !conn.sleptAt.IsZero() {
+		end = conn.sleptAt
+	}
+	rec := &CallRecord{
+		Peer:        conn.remoteAddr,
+		Outgoing:    conn.isInitiator,
+		Mode:        conn.mode,
+		Start:       conn.started.UTC(),
+		End:         end.UTC(),
+		PeerZone:    conn.zone,
+		Interrupted: conn.interrupted,
+		Outcome:     conn.outcome,
+		Stats:       conn.peer.GetStats(),
+	}
+	if !conn.started.IsZero() {
+		rec.Duration = conn.duration()
+		rec.Outcome = OutcomeAnswered
+		if conn.voicemail {
+			rec.Outcome = OutcomeVoicemail
+		}
+	}
+	if rec.Outcome == "" {
+		rec.Outcome = rec.outcome()
+	}
+	return rec
+}
+
+// duration is how long the call has been going on for, without
+// interruptions, zero until it is connected
+func (conn *Connection) duration() time.Duration {
+	if conn.started.IsZero() {
+		return 0
+	}
+	end := time.Now()
+	if !conn.sleptAt.IsZero() {
+		end = conn.sleptAt
+	}
+	return end.Sub(conn.started) - conn.interrupted
+}
+
+// formatDuration renders d as a call timer, like 4:05 or 1:04:05
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	h, m, s := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+// outcome returns the outcome of the call, guessing it for the records of
+// older versions
+func (rec *CallRecord) outcome() CallOutcome {
+	switch {
+	case rec.Outcome != "":
+		return rec.Outcome
+	case !rec.Start.IsZero():
+		return OutcomeAnswered
+	case rec.Outgoing:
+		return OutcomeUnanswered
+	}
+	return OutcomeMissed
+}
+
+func (peer *RTCPeer) saveCallRecord(rec *CallRecord) {
+	if err := appendJSONLine(cdrPath, rec); err != nil {
+		logError("couldn't save call record:", err)
+	}
+}
+
+// endCall takes care of the call record of a connection that has just been
+// closed. If the survey is enabled and the call was actually established, the
+// record is held until the user rates the call or skips the survey
+func (peer *RTCPeer) endCall(rec *CallRecord) {
+	if !peer.AskSurvey || rec.Start.IsZero() {
+		peer.saveCallRecord(rec)
+		return
+	}
+	if peer.pendingSurvey != nil {
+		peer.saveCallRecord(peer.pendingSurvey)
+	}
+	peer.pendingSurvey = rec
+	log.Println("how was the call with", rec.Peer, "?")
+	log.Println("rate it with /rate <1-5> [note], or /skip")
+}
+
+// RateLastCall stores the user's answer to the end-of-call survey
+func (peer *RTCPeer) RateLastCall(rating int, note string) {
+	if peer.pendingSurvey == nil {
+		log.Println("there is no call to rate")
+		return
+	}
+	if rating < 1 || rating > 5 {
+		log.Println("rating must be between 1 and 5")
+		return
+	}
+	peer.pendingSurvey.Rating = rating
+	peer.pendingSurvey.Note = note
+	peer.saveCallRecord(peer.pendingSurvey)
+	peer.pendingSurvey = nil
+	log.Println("thanks for the feedback")
+}
+
+// SkipSurvey saves the last call record without a rating
+func (peer *RTCPeer) SkipSurvey() {
+	if peer.pendingSurvey == nil {
+		return
+	}
+	peer.saveCallRecord(peer.pendingSurvey)
+	peer.pendingSurvey = nil
+}
+
+// readCallRecords returns the records kept for which keep returns true,
+// oldest first
+func readCallRecords(keep func(rec *CallRecord) bool) ([]CallRecord, error) {
+	f, err := os.Open(cdrPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var recs []CallRecord
+	scanner := bufio.NewScanner(f)
+	// Records carry a full stats report, which can make for long lines
+	scanner.Buffer(nil, 1<<24)
+	for scanner.Scan() {
+		var rec CallRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		if keep(&rec) {
+			recs = append(recs, rec)
+		}
+	}
+	return recs, scanner.Err()
+}
+
+// History logs the latest calls, in local time and in the peer's local time.
+// filter keeps only the calls with a peer, or with an outcome, if given
+func (peer *RTCPeer) History(filter string) {
+	recs, err := readCallRecords(func(rec *CallRecord) bool {
+		return filter == "" || rec.Peer == filter ||
+			string(rec.outcome()) == filter
+	})
+	if err != nil {
+		logError("couldn't read the call history:", err)
+		return
+	}
+	if len(recs) == 0 {
+		log.Println("no calls yet")
+		return
+	}
+	if len(recs) > historyLength {
+		recs = recs[len(recs)-historyLength:]
+	}
+	for _, rec := range recs {
+		dir := "from"
+		if rec.Outgoing {
+			dir = "to"
+		}
+		what := modeNames[rec.Mode]
+		who := peer.displayName(rec.Peer)
+		if rec.Start.IsZero() {
+			log.Printf("%s %s %s %s: %s\n",
+				rec.End.Local().Format("2006-01-02 15:04"), what, dir, who,
+				rec.outcome())
+			continue
+		}
+		length := rec.Duration
+		if length == 0 {
+			length = rec.End.Sub(rec.Start) - rec.Interrupted
+		}
+		log.Printf("%s %s %s %s at %s, %s\n",
+			rec.Start.Local().Format("2006-01-02"), what, dir, who,
+			formatTimes(rec.Start, rec.PeerZone),
+			formatDuration(length))
+	}
+}
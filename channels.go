@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Labels of the data channels opened with /channel start with this, so that
+// they aren't mistaken for the chat or file channels
+const appChanPrefix = "app:"
+
+var errChannelExists = errors.New("channel already open")
+
+// ChannelOptions are the delivery guarantees of an application data channel
+type ChannelOptions struct {
+	// Unordered lets messages be delivered as they arrive, instead of
+	// waiting for the ones sent before them
+	Unordered bool
+	// MaxRetransmits limits how many times a lost message is sent again,
+	// nil to retransmit until it arrives
+	MaxRetransmits *uint16
+}
+
+func (opts ChannelOptions) String() string {
+	s := "ordered"
+	if opts.Unordered {
+		s = "unordered"
+	}
+	if opts.MaxRetransmits == nil {
+		return s + ", reliable"
+	}
+	return s + ", " + strconv.Itoa(int(*opts.MaxRetransmits)) + " retransmits"
+}
+
+// parseChannelOptions parses the options given to /channel, unordered and
+// retransmits=<n>
+func parseChannelOptions(args []string) (ChannelOptions, error) {
+	var opts ChannelOptions
+	for _, arg := range args {
+		switch {
+		case arg == "unordered":
+			opts.Unordered = true
+		case strings.HasPrefix(arg, "retransmits="):
+			n, err := strconv.ParseUint(
+				strings.TrimPrefix(arg, "retransmits="),
+				10,
+				16,
+			)
+			if err != nil {
+				return opts, err
+			}
+			max := uint16(n)
+			opts.MaxRetransmits = &max
+		default:
+			return opts, errors.New("unknown option " + arg)
+		}
+	}
+	return opts, nil
+}
+
+// OpenChannel opens a data channel called label to remote, for payloads
+// that shouldn't wait behind chat and files
+func (peer *RTCPeer) OpenChannel(remote, label string, opts ChannelOptions) {
+	conn, ok := peer.Connections[remote]
+	if !ok {
+		log.Println("not connected to", remote)
+		return
+	}
+	if !peer.requireCap(remote, CapChannels) {
+		return
+	}
+	if err := conn.openChannel(label, opts); err != nil {
+		logError("couldn't open channel", label, "to", conn, ":", err)
+	}
+}
+
+func (conn *Connection) openChannel(label string, opts ChannelOptions) error {
+	if _, ok := conn.channels[label]; ok {
+		return errChannelExists
+	}
+	ordered := !opts.Unordered
+	d, err := conn.peer.CreateDataChannel(
+		appChanPrefix+label,
+		&webrtc.DataChannelInit{
+			Ordered:        &ordered,
+			MaxRetransmits: opts.MaxRetransmits,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	conn.handleAppChannel(d)
+	d.OnOpen(func() {
+		log.Printf("channel %s to %s open, %s\n", label, conn, opts)
+	})
+	return nil
+}
+
+// handleAppChannel keeps track of an application data channel, opened by
+// either side, and logs what arrives through it
+func (conn *Connection) handleAppChannel(d *webrtc.DataChannel) {
+	label := strings.TrimPrefix(d.Label(), appChanPrefix)
+	conn.channels[label] = d
+	d.OnMessage(func(msg webrtc.DataChannelMessage) {
+		if !msg.IsString {
+			log.Printf("channel %s@%s: %d bytes\n", label, conn,
+				len(msg.Data))
+			return
+		}
+		log.Printf("channel %s@%s: %s\n", label, conn, string(msg.Data))
+	})
+	d.OnClose(func() {
+		delete(conn.channels, label)
+		log.Printf("channel %s to %s closed\n", label, conn)
+	})
+}
+
+// SendOnChannel sends text through the application data channel label
+func (peer *RTCPeer) SendOnChannel(remote, label, text string) {
+	conn, ok := peer.Connections[remote]
+	if !ok {
+		log.Println("not connected to", remote)
+		return
+	}
+	d, ok := conn.channels[label]
+	if !ok {
+		log.Println("no channel", label, "open to", remote)
+		return
+	}
+	if err := d.SendText(text); err != nil {
+		logError("couldn't send on channel", label, "to", conn, ":", err)
+	}
+}
+
+// CloseChannel closes the application data channel label
+func (peer *RTCPeer) CloseChannel(remote, label string) {
+	conn, ok := peer.Connections[remote]
+	if !ok {
+		log.Println("not connected to", remote)
+		return
+	}
+	d, ok := conn.channels[label]
+	if !ok {
+		log.Println("no channel", label, "open to", remote)
+		return
+	}
+	if err := d.Close(); err != nil {
+		logError("couldn't close channel", label, ":", err)
+	}
+}
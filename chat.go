@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ChatType is the kind of a chat message
+type ChatType string
+
+const (
+	ChatText ChatType = "text"
+	// ChatAction is written in the third person, like /me waves
+	ChatAction ChatType = "action"
+)
+
+// ChatMessage is sent as JSON in an envelope of type MsgChatJSON to peers
+// with CapChat. Older peers get the plain text of the body
+type ChatMessage struct {
+	// ID numbers the messages sent through a connection, for receipts
+	ID     uint64
+	Time   time.Time
+	Sender string
+	Type   ChatType
+	Body   string
+}
+
+// text is how the message reads as plain text
+func (msg ChatMessage) text() string {
+	if msg.Type == ChatAction {
+		return "* " + msg.Sender + " " + msg.Body
+	}
+	return msg.Body
+}
+
+// sendChat sends body, as a structured message if the remote understands
+// them
+func (conn *Connection) sendChat(t ChatType, body string) error {
+	msg := ChatMessage{
+		Time:   time.Now().UTC(),
+		Sender: conn.local.Name,
+		Type:   t,
+		Body:   body,
+	}
+	if !conn.local.supports(conn.remoteAddr, CapChat) {
+		err := conn.sendMessage(conn.dataChan, MsgChat, []byte(msg.text()))
+		if err == nil {
+			conn.saveChat(msg, true)
+			echoLine(conn.remoteAddr, "me: "+msg.text())
+		}
+		return err
+	}
+	conn.msgCount++
+	msg.ID = conn.msgCount
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if err := conn.sendMessage(conn.dataChan, MsgChatJSON, payload); err != nil {
+		return err
+	}
+	conn.lastSent = msg.ID
+	conn.lastSentState = Sent
+	conn.saveChat(msg, true)
+	echoLine(conn.remoteAddr, "me: "+msg.text())
+	return nil
+}
+
+// handleChatJSON shows a structured chat message and acknowledges its
+// delivery. It will be acknowledged as read on the next sign of the user
+// being around
+func (conn *Connection) handleChatJSON(payload []byte) {
+	var msg ChatMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		logError("couldn't parse chat message from", conn, ":", err)
+		return
+	}
+	conn.showChat(msg)
+	if !conn.local.supports(conn.remoteAddr, CapReceipts) {
+		return
+	}
+	conn.sendReceipt(Receipt{IDs: []uint64{msg.ID}})
+	if conn.local.ReadReceipts {
+		conn.unread = append(conn.unread, msg.ID)
+	}
+}
+
+func (conn *Connection) showChat(msg ChatMessage) {
+	// Whatever they were typing has arrived
+	conn.typingAt = time.Time{}
+	if msg.Time.IsZero() {
+		msg.Time = time.Now().UTC()
+	}
+	conn.saveChat(msg, false)
+	peerEvents.messageReceived(conn, msg)
+	if conn.local.Echo {
+		defer conn.echoChat(msg)
+	}
+	from := conn.local.displayName(conn.remoteAddr)
+	if msg.Sender != "" && msg.Sender != conn.remoteName {
+		from += " (" + selfDeclared(msg.Sender) + ")"
+	}
+	if conn.legacy {
+		from += " (legacy)"
+	}
+	line := fmt.Sprintf("channel %s@%s: %s", conn.dataChan.Label(), from,
+		msg.Body)
+	if msg.Type == ChatAction {
+		line = fmt.Sprintf("* %s %s", from, msg.Body)
+	}
+	if conn.local.dnd {
+		conn.local.quiet = append(conn.local.quiet,
+			msg.Time.Local().Format("15:04")+" "+line)
+		return
+	}
+	messageLine(conn.remoteAddr, line)
+	notify(eventMessage, from, msg.Body)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// How many messages are shown again when a peer reconnects
+	chatHistoryLength = 20
+	// How many matches /search shows at most
+	searchResultsLength = 50
+)
+
+// ChatEntry is a message kept in the chat history with a peer
+type ChatEntry struct {
+	ChatMessage
+	Peer     string
+	Outgoing bool
+}
+
+// chatHistoryDir is where the chat history is kept, one file of JSON lines
+// per peer, in the XDG data directory
+func chatHistoryDir() string {
+	data := os.Getenv("XDG_DATA_HOME")
+	if data == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return filepath.Join(outputPath, "chats")
+		}
+		data = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(data, "wrtcion", "chats")
+}
+
+// chatHistoryPath returns the file the chat with remote is kept in
+func chatHistoryPath(remote string) string {
+	name := strings.NewReplacer("/", "_", ":", "_").Replace(remote)
+	return filepath.Join(chatHistoryDir(), name+".jsonl")
+}
+
+// saveChat appends msg to the chat history with the remote
+func (conn *Connection) saveChat(msg ChatMessage, outgoing bool) {
+	if !conn.local.ChatHistory {
+		return
+	}
+	entry := ChatEntry{
+		ChatMessage: msg,
+		Peer:        conn.remoteAddr,
+		Outgoing:    outgoing,
+	}
+	if err := appendJSONLine(chatHistoryPath(conn.remoteAddr), entry); err != nil {
+		logError("couldn't save chat message:", err)
+	}
+}
+
+// readChatFile returns the last n messages of a chat history file for which
+// keep returns true, oldest first
+func readChatFile(
+	path string,
+	n int,
+	keep func(entry *ChatEntry) bool,
+) ([]ChatEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []ChatEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry ChatEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if !keep(&entry) {
+			continue
+		}
+		entries = append(entries, entry)
+		if len(entries) > n {
+			entries = entries[1:]
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// readChatHistory returns the last n messages exchanged with remote, oldest
+// first
+func readChatHistory(remote string, n int) ([]ChatEntry, error) {
+	return readChatFile(
+		chatHistoryPath(remote),
+		n,
+		func(*ChatEntry) bool { return true },
+	)
+}
+
+// formatChatEntry renders entry as a line of the history, from would be the
+// sender
+func formatChatEntry(entry ChatEntry, from string) string {
+	when := entry.Time.Local().Format("2006-01-02 15:04")
+	if entry.Type == ChatAction {
+		return fmt.Sprintf("[%s] * %s %s", when, from, entry.Body)
+	}
+	return fmt.Sprintf("[%s] %s: %s", when, from, entry.Body)
+}
+
+// showChatHistory logs the latest messages exchanged with the remote in
+// earlier connections, so that the conversation picks up where it was left
+func (conn *Connection) showChatHistory() {
+	if !conn.local.ChatHistory {
+		return
+	}
+	entries, err := readChatHistory(conn.remoteAddr, chatHistoryLength)
+	if err != nil {
+		logError("couldn't read the chat history:", err)
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+	chatLine(conn.remoteAddr, fmt.Sprintf("earlier with %s:", conn))
+	for _, entry := range entries {
+		from := entry.Sender
+		if entry.Outgoing {
+			from = "me"
+		} else if from == "" {
+			from = conn.remoteAddr
+		}
+		chatLine(conn.remoteAddr, "  "+formatChatEntry(entry, from))
+	}
+}
+
+// currentPeer is the one a command without an address is about: the call
+// with the focus, or the only connection there is
+func (peer *RTCPeer) currentPeer() (string, bool) {
+	if _, ok := peer.Connections[peer.focus]; ok {
+		return peer.focus, true
+	}
+	if len(peer.Connections) == 1 {
+		for remote := range peer.Connections {
+			return remote, true
+		}
+	}
+	return "", false
+}
+
+// SearchChats logs the messages of the chat history that contain term,
+// ignoring case, with every peer or only the current one if here is set. In
+// the full screen interface, the latest one still in the scrollback is shown
+// in the pane of its peer
+func (peer *RTCPeer) SearchChats(term string, here bool) {
+	paths, err := filepath.Glob(filepath.Join(chatHistoryDir(), "*.jsonl"))
+	if err != nil {
+		logError("couldn't search the chat history:", err)
+		return
+	}
+	if here {
+		remote, ok := peer.currentPeer()
+		if !ok {
+			log.Println("no current peer to search the chat with")
+			return
+		}
+		paths = []string{chatHistoryPath(remote)}
+	}
+
+	term = strings.ToLower(term)
+	var matches []ChatEntry
+	for _, path := range paths {
+		entries, err := readChatFile(path, searchResultsLength,
+			func(entry *ChatEntry) bool {
+				return strings.Contains(strings.ToLower(entry.Body), term)
+			})
+		if err != nil {
+			logError("couldn't search", path, ":", err)
+			continue
+		}
+		if len(entries) > 0 && entries[0].Peer == "" {
+			// Written before the peer was recorded in every entry
+			name := strings.TrimSuffix(filepath.Base(path), ".jsonl")
+			for i := range entries {
+				entries[i].Peer = name
+			}
+		}
+		matches = append(matches, entries...)
+	}
+	if len(matches) == 0 {
+		log.Println("no messages found")
+		return
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Time.Before(matches[j].Time)
+	})
+	if len(matches) > searchResultsLength {
+		log.Printf("%d messages found, showing the latest %d:\n",
+			len(matches), searchResultsLength)
+		matches = matches[len(matches)-searchResultsLength:]
+	} else {
+		log.Printf("%d messages found:\n", len(matches))
+	}
+	for _, entry := range matches {
+		from := entry.Peer
+		if entry.Outgoing {
+			from = "me to " + entry.Peer
+		} else if entry.Sender != "" {
+			from = entry.Sender + " (" + entry.Peer + ")"
+		}
+		log.Println(" ", formatChatEntry(entry, from))
+	}
+	if chatPanes != nil {
+		chatPanes.jump(matches)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	clipText = "text/plain;charset=utf-8"
+	// Clips have to fit in a single data channel message, with room for
+	// their base64 encoding
+	maxClipSize = 32 << 10
+	// How much of a received clip is shown in the log
+	clipPreview = 60
+)
+
+var errNoClipboard = errors.New("no clipboard tool found, install wl-clipboard, xclip or xsel")
+
+// Clip is the content of a clipboard, sent as JSON in a message of type
+// MsgClipboard. Only text is sent for now, Mime leaves room for images
+type Clip struct {
+	Mime string
+	Data []byte
+}
+
+// clipboardTools are the programs the system clipboard is read and written
+// with, in order of preference. The Wayland ones only work in a Wayland
+// session
+var clipboardTools = []struct {
+	wayland bool
+	read    []string
+	write   []string
+}{
+	{true, []string{"wl-paste", "--no-newline"}, []string{"wl-copy"}},
+	{false, []string{"xclip", "-selection", "clipboard", "-o"},
+		[]string{"xclip", "-selection", "clipboard", "-i"}},
+	{false, []string{"xsel", "--clipboard", "--output"},
+		[]string{"xsel", "--clipboard", "--input"}},
+	{false, []string{"pbpaste"}, []string{"pbcopy"}},
+}
+
+// clipboardTool returns the read or write command of the first clipboard
+// tool installed
+func clipboardTool(write bool) ([]string, error) {
+	wayland := os.Getenv("WAYLAND_DISPLAY") != ""
+	for _, tool := range clipboardTools {
+		if tool.wayland && !wayland {
+			continue
+		}
+		args := tool.read
+		if write {
+			args = tool.write
+		}
+		if _, err := exec.LookPath(args[0]); err == nil {
+			return args, nil
+		}
+	}
+	return nil, errNoClipboard
+}
+
+func readClipboard() ([]byte, error) {
+	args, err := clipboardTool(false)
+	if err != nil {
+		return nil, err
+	}
+	return exec.Command(args[0], args[1:]...).Output()
+}
+
+func writeClipboard(data []byte) error {
+	args, err := clipboardTool(true)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	return cmd.Run()
+}
+
+// Paste sends the text in our clipboard to remote
+func (peer *RTCPeer) Paste(remote string) {
+	conn, ok := peer.Connections[remote]
+	if !ok {
+		log.Println("not connected to", remote)
+		return
+	}
+	if !peer.requireCap(remote, CapClipboard) {
+		return
+	}
+	data, err := readClipboard()
+	if err != nil {
+		logError("couldn't read the clipboard:", err)
+		return
+	}
+	if len(data) == 0 || !utf8.Valid(data) {
+		log.Println("there is no text in the clipboard")
+		return
+	}
+	if len(data) > maxClipSize {
+		log.Printf("the clipboard is too big to share, %d bytes at most\n",
+			maxClipSize)
+		return
+	}
+	payload, err := json.Marshal(Clip{Mime: clipText, Data: data})
+	if err == nil {
+		err = conn.sendMessage(conn.dataChan, MsgClipboard, payload)
+	}
+	if err != nil {
+		logError("couldn't share the clipboard with", remote, ":", err)
+		return
+	}
+	log.Printf("shared %d bytes of clipboard with %s\n", len(data), remote)
+}
+
+// handleClip keeps the clip the remote shared until it is copied
+func (conn *Connection) handleClip(payload []byte) {
+	var clip Clip
+	if err := json.Unmarshal(payload, &clip); err != nil {
+		logError("couldn't parse clipboard from", conn, ":", err)
+		return
+	}
+	if clip.Mime != clipText || !utf8.Valid(clip.Data) {
+		log.Printf("%s shared a %s clipboard, which isn't supported\n",
+			conn, clip.Mime)
+		return
+	}
+	conn.local.clip = &clip
+	preview := strings.Join(strings.Fields(string(clip.Data)), " ")
+	if utf8.RuneCountInString(preview) > clipPreview {
+		preview = string([]rune(preview)[:clipPreview]) + "…"
+	}
+	log.Printf("%s shared its clipboard, /copy to copy it: %s\n",
+		conn, preview)
+}
+
+// Copy puts the last clip shared by a peer into our clipboard
+func (peer *RTCPeer) Copy() {
+	if peer.clip == nil {
+		log.Println("nobody shared their clipboard yet")
+		return
+	}
+	if err := writeClipboard(peer.clip.Data); err != nil {
+		logError("couldn't write the clipboard:", err)
+		return
+	}
+	log.Println("copied to the clipboard")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// peerCommands take a peer as their first argument, which Tab completes
+var peerCommands = map[string]bool{
+	"/chat":       true,
+	"/call":       true,
+	"/video":      true,
+	"/accept":     true,
+	"/reject":     true,
+	"/acceptfile": true,
+	"/rejectfile": true,
+	"/end":        true,
+	"/msg":        true,
+	"/mute":       true,
+	"/unmute":     true,
+}
+
+// completionPeers are the names of the contacts, and the addresses and names
+// of the connected peers, sorted
+func (peer *RTCPeer) completionPeers() []string {
+	seen := make(map[string]bool)
+	var peers []string
+	add := func(s string) {
+		if s != "" && !seen[s] {
+			seen[s] = true
+			peers = append(peers, s)
+		}
+	}
+	for _, c := range peer.contacts {
+		add(c.Name)
+	}
+	for remote := range peer.Connections {
+		add(remote)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// complete completes the command being entered in text, or the peer given
+// to it, from the commands of /help and peers. It returns text with as much
+// as is common to all the matches added, and the matches when there are
+// several
+func complete(text string, peers []string) (string, []string) {
+	if !strings.HasPrefix(text, "/") {
+		return text, nil
+	}
+	args := strings.Split(text, " ")
+	var candidates []string
+	switch {
+	case len(args) == 1:
+		for _, c := range commands {
+			candidates = append(candidates, c.Name)
+		}
+	case len(args) == 2 && peerCommands[args[0]]:
+		candidates = peers
+	default:
+		return text, nil
+	}
+	word := args[len(args)-1]
+	var matches []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word) {
+			matches = append(matches, c)
+		}
+	}
+	if len(matches) == 0 {
+		return text, nil
+	}
+	prefix := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	text = strings.TrimSuffix(text, word) + prefix
+	if len(matches) == 1 {
+		return text + " ", nil
+	}
+	return text, matches
+}
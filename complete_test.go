@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComplete(t *testing.T) {
+	peers := []string{"alice", "alan", "bob", "localhost:8002"}
+	tests := []struct {
+		text    string
+		want    string
+		matches []string
+	}{
+		{text: "hello", want: "hello"},
+		{text: "", want: ""},
+		{text: "/acceptf", want: "/acceptfile "},
+		{
+			text:    "/reje",
+			want:    "/reject",
+			matches: []string{"/reject", "/rejectfile"},
+		},
+		{text: "/nosuch", want: "/nosuch"},
+		{text: "/msg al", want: "/msg al", matches: []string{"alice", "alan"}},
+		{text: "/msg ali", want: "/msg alice "},
+		{text: "/call lo", want: "/call localhost:8002 "},
+		{text: "/msg alice hel", want: "/msg alice hel"},
+		{text: "/help ali", want: "/help ali"},
+		{text: "/msg ", want: "/msg ", matches: peers},
+		{text: "/msg zed", want: "/msg zed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, matches := complete(tt.text, peers)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(matches, tt.matches) {
+				t.Errorf("matches %q, want %q", matches, tt.matches)
+			}
+		})
+	}
+}
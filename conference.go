@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Yaroslav-95/wrtcion/gst"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// conference is a call between several peers hosted by us. Each member gets
+// its own mix of everyone else and our microphone, but not of itself. mu
+// guards the members, which the tracks of every member walk for each packet
+type conference struct {
+	mu      sync.Mutex
+	members map[string]*Connection
+	// invited are the peers called with /invite that haven't connected yet
+	invited map[string]bool
+	count   int
+}
+
+// StartConference starts hosting a conference, peers join it with Invite
+func (peer *RTCPeer) StartConference() {
+	if peer.conference != nil {
+		log.Println("already hosting a conference")
+		return
+	}
+	peer.conference = &conference{
+		members: make(map[string]*Connection),
+		invited: make(map[string]bool),
+	}
+	log.Println("hosting a conference, /invite <address> to add people")
+}
+
+// StopConference hangs up on every member of the conference
+func (peer *RTCPeer) StopConference() {
+	conf := peer.conference
+	if conf == nil {
+		log.Println("not hosting a conference")
+		return
+	}
+	// Closing a member takes it out of the conference
+	conf.mu.Lock()
+	members := make([]*Connection, 0, len(conf.members))
+	for _, conn := range conf.members {
+		members = append(members, conn)
+	}
+	conf.mu.Unlock()
+	for _, conn := range members {
+		conn.Close()
+	}
+	peer.conference = nil
+	log.Println("conference ended")
+}
+
+// ShowConference logs who is in the conference
+func (peer *RTCPeer) ShowConference() {
+	conf := peer.conference
+	if conf == nil {
+		log.Println("not hosting a conference, /conference start")
+		return
+	}
+	conf.mu.Lock()
+	defer conf.mu.Unlock()
+	var remotes []string
+	for remote := range conf.members {
+		remotes = append(remotes, remote)
+	}
+	sort.Strings(remotes)
+	log.Printf("conference of %d: %v\n", len(remotes), remotes)
+	for remote := range conf.invited {
+		log.Println("still calling", remote)
+	}
+}
+
+// Invite calls remote into the conference
+func (peer *RTCPeer) Invite(remote string) {
+	if peer.conference == nil {
+		log.Println("start a conference first with /conference start")
+		return
+	}
+	peer.conference.mu.Lock()
+	peer.conference.invited[remote] = true
+	peer.conference.mu.Unlock()
+	peer.Ring(remote, VoiceConnectionDuplex)
+}
+
+// joinConference makes conn a member of the conference if it was invited,
+// sending it the mix of the other members instead of our own audio. It
+// tells whether conn joined
+func (conn *Connection) joinConference() bool {
+	conf := conn.local.conference
+	if conf == nil {
+		return false
+	}
+	conf.mu.Lock()
+	defer conf.mu.Unlock()
+	if !conf.invited[conn.remoteAddr] {
+		return false
+	}
+	delete(conf.invited, conn.remoteAddr)
+	if conn.audioSndr == nil {
+		return false
+	}
+	conf.count++
+	conn.confInput = fmt.Sprintf("member%d", conf.count)
+	mix, err := gst.CreateConferenceMix(
+		conn.local.Capture,
+		conn.settings.Audio,
+		func(data []byte, duration time.Duration) {
+			if conn.state != InCall || conn.muted || conn.held || conn.asleep {
+				return
+			}
+			err := conn.audioSndr.track.WriteSample(media.Sample{
+				Data:     data,
+				Duration: duration,
+			})
+			if err != nil {
+				logError("error writing samples:", err)
+			}
+		},
+	)
+	if err != nil {
+		logError("couldn't mix the conference for", conn, ":", err)
+		return false
+	}
+	for _, member := range conf.members {
+		mix.AddInput(member.confInput)
+		member.confMix.AddInput(conn.confInput)
+	}
+	conn.confMix = mix
+	conf.members[conn.remoteAddr] = conn
+	go conn.handleAudioRTCP()
+	mix.Start()
+	log.Printf("%s joined the conference, %d in it\n", conn, len(conf.members))
+	return true
+}
+
+// relayToConference gives an RTP packet of the audio of conn to the mixes of
+// the other members
+func (conn *Connection) relayToConference(packet []byte) {
+	conf := conn.local.conference
+	if conf == nil {
+		return
+	}
+	conf.mu.Lock()
+	defer conf.mu.Unlock()
+	if conn.confMix == nil {
+		return
+	}
+	for _, member := range conf.members {
+		if member != conn {
+			member.confMix.PushInput(conn.confInput, packet)
+		}
+	}
+}
+
+// leaveConference takes conn out of the conference it is a member of
+func (conn *Connection) leaveConference() {
+	conf := conn.local.conference
+	if conf != nil {
+		conf.mu.Lock()
+		defer conf.mu.Unlock()
+	}
+	if conn.confMix == nil {
+		return
+	}
+	if conf != nil {
+		delete(conf.members, conn.remoteAddr)
+		for _, member := range conf.members {
+			member.confMix.RemoveInput(conn.confInput)
+		}
+		log.Printf("%s left the conference, %d in it\n", conn,
+			len(conf.members))
+	}
+	conn.confMix.Stop()
+	conn.confMix = nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+const configPath = outputPath + "config.json"
+
+// Config holds the settings of the full screen interface
+type Config struct {
+	// Keys binds key names, like F2 or Alt+M, to the command they run. An
+	// empty command unbinds a default key
+	Keys map[string]string `json:",omitempty"`
+	// Theme is one of themes, the default one if empty
+	Theme string `json:",omitempty"`
+	// TimeFormat and DayFormat are Go time layouts for the time before
+	// every line and the day between the lines of different days, or none
+	TimeFormat string `json:",omitempty"`
+	DayFormat  string `json:",omitempty"`
+	// Notify is how the call and message events are announced when nobody
+	// is looking: bell, desktop, both or none
+	Notify map[string]string `json:",omitempty"`
+}
+
+// loadConfig reads the config file, an empty one if there is none. The
+// environment variables of its keys take precedence
+func loadConfig() Config {
+	var cfg Config
+	data, err := os.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		logError("couldn't read config:", err)
+	} else if err == nil {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			logError("couldn't parse config:", err)
+		}
+	}
+	cfg.Theme = envString("THEME", cfg.Theme)
+	cfg.TimeFormat = envString("TIME_FORMAT", cfg.TimeFormat)
+	cfg.DayFormat = envString("DAY_FORMAT", cfg.DayFormat)
+	return cfg
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+)
+
+// ICERoute is how the media of a call may travel
+type ICERoute int
+
+const (
+	// RouteAsk follows the relay policy of the peer, asking before
+	// connecting directly to peers that aren't trusted
+	RouteAsk ICERoute = iota
+	// RouteDirect connects directly, the user agreed to reveal their address
+	RouteDirect
+	// RouteRelay only uses the TURN server
+	RouteRelay
+)
+
+// pendingCall is a call waiting for the user to agree to reveal their IP
+// address to the remote
+type pendingCall struct {
+	remote   string
+	mode     ConnectionMode
+	settings CallSettings
+}
+
+// needsConsent tells whether calling remote directly would reveal our
+// address to someone we don't trust
+func (peer *RTCPeer) needsConsent(remote string, settings CallSettings) bool {
+	if !peer.ConfirmDirect || settings.Route != RouteAsk {
+		return false
+	}
+	if _, ok := peer.trusted[remote]; ok {
+		return false
+	}
+	return !peer.relayOnly(remote)
+}
+
+// askConsent holds the call until the user picks how to connect
+func (peer *RTCPeer) askConsent(
+	remote string,
+	mode ConnectionMode,
+	settings CallSettings,
+) {
+	peer.pendingCall = &pendingCall{remote, mode, settings}
+	log.Println("connecting directly to", remote,
+		"will reveal your IP address to it")
+	log.Println("/continue to connect directly, /userelay to go through",
+		"the TURN server, or /cancel")
+}
+
+// ResolveConsent places the call held by askConsent through route, or drops
+// it if cancel is set
+func (peer *RTCPeer) ResolveConsent(route ICERoute, cancel bool) {
+	call := peer.pendingCall
+	if call == nil {
+		log.Println("there is no call waiting")
+		return
+	}
+	if route == RouteRelay && peer.TURNServer == "" {
+		log.Println("there is no TURN server to relay through, set -turn")
+		return
+	}
+	peer.pendingCall = nil
+	if cancel {
+		log.Println("call to", call.remote, "cancelled")
+		return
+	}
+	call.settings.Route = route
+	peer.RingWith(call.remote, call.mode, call.settings)
+}
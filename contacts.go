@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const contactsPath = outputPath + "contacts.json"
+
+// Contact is an entry of the address book
+type Contact struct {
+	Name    string
+	Address string
+	// Fingerprint is the one of the contact's certificate, if known
+	Fingerprint string `json:",omitempty"`
+	// AutoAnswer answers the calls of the contact without asking
+	AutoAnswer bool `json:",omitempty"`
+	// Watch is the directory whose new files are sent to the contact
+	Watch string `json:",omitempty"`
+}
+
+func loadContacts() ([]Contact, error) {
+	data, err := os.ReadFile(contactsPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var contacts []Contact
+	return contacts, json.Unmarshal(data, &contacts)
+}
+
+func saveContacts(contacts []Contact) error {
+	data, err := json.MarshalIndent(contacts, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outputPath, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(contactsPath, data, 0644)
+}
+
+// loadAddressBook reads the contacts whose names can be used instead of
+// their addresses
+func (peer *RTCPeer) loadAddressBook() {
+	contacts, err := loadContacts()
+	if err != nil {
+		logError("couldn't read contacts:", err)
+		return
+	}
+	peer.contacts = contacts
+}
+
+// AddContact adds name to the address book, for address. Names can't have
+// spaces nor colons, to be told apart from addresses in commands
+func (peer *RTCPeer) AddContact(name, address string) {
+	if name == "" || strings.ContainsAny(name, ": \t") {
+		log.Println("contact names can't have spaces nor colons")
+		return
+	}
+	contacts, added, conflicts := mergeContacts(
+		peer.contacts,
+		[]Contact{{Name: name, Address: address}},
+	)
+	for _, conflict := range conflicts {
+		log.Println(conflict)
+	}
+	if added == 0 {
+		return
+	}
+	if err := saveContacts(contacts); err != nil {
+		logError("couldn't save contacts:", err)
+		return
+	}
+	peer.contacts = contacts
+	log.Println("added", name, "at", address)
+}
+
+// Contacts logs the address book
+func (peer *RTCPeer) Contacts() {
+	if len(peer.contacts) == 0 {
+		log.Println("no contacts yet, /add <name> <address>")
+		return
+	}
+	for _, c := range peer.contacts {
+		log.Println(c.Name, c.Address, peer.presenceOf(c.Address))
+	}
+}
+
+// contactAddress returns the address of the contact called name, or name
+// itself if there is none. The names peers give themselves are never looked
+// up, anybody could take those
+func (peer *RTCPeer) contactAddress(name string) string {
+	for _, c := range peer.contacts {
+		if c.Name != "" && strings.EqualFold(c.Name, name) {
+			return c.Address
+		}
+	}
+	return name
+}
+
+// displayName returns the name of the contact at address, or else the one
+// the peer there told us marked as such, followed by the address. It is only
+// the address if there is no name
+func (peer *RTCPeer) displayName(address string) string {
+	for _, c := range peer.contacts {
+		if c.Address == address && c.Name != "" {
+			return fmt.Sprintf("%s (%s)", c.Name, address)
+		}
+	}
+	if conn, ok := peer.Connections[address]; ok && conn.remoteName != "" {
+		return fmt.Sprintf("%s (%s)", selfDeclared(conn.remoteName), address)
+	}
+	return address
+}
+
+// fieldMap tells which fields of an imported file hold what. CSV files are
+// matched by column header and vCards by property name, ignoring case
+type fieldMap struct {
+	Name    string
+	Address string
+	Key     string
+}
+
+var (
+	csvFields = fieldMap{Name: "name", Address: "address", Key: "fingerprint"}
+	// vCard has no property for our addresses, so an extension is used
+	vcardFields = fieldMap{Name: "FN", Address: "X-WRTCION", Key: "KEY"}
+)
+
+// readCSVContacts reads contacts from a CSV file with a header row
+func readCSVContacts(r io.Reader, fields fieldMap) ([]Contact, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	column := func(name string) int {
+		for i, header := range rows[0] {
+			if strings.EqualFold(strings.TrimSpace(header), name) {
+				return i
+			}
+		}
+		return -1
+	}
+	nameCol, addrCol, keyCol := column(fields.Name), column(fields.Address),
+		column(fields.Key)
+	if addrCol < 0 {
+		return nil, fmt.Errorf("no %s column", fields.Address)
+	}
+	get := func(row []string, col int) string {
+		if col < 0 || col >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[col])
+	}
+	var contacts []Contact
+	for _, row := range rows[1:] {
+		contacts = append(contacts, Contact{
+			Name:        get(row, nameCol),
+			Address:     get(row, addrCol),
+			Fingerprint: get(row, keyCol),
+		})
+	}
+	return contacts, nil
+}
+
+// readVCardContacts reads the contacts of a vCard file, of any version
+func readVCardContacts(r io.Reader, fields fieldMap) ([]Contact, error) {
+	// Long lines are folded, continuing on lines starting with whitespace
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") ||
+			strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var contacts []Contact
+	var card *Contact
+	for _, line := range lines {
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			continue
+		}
+		// Parameters such as TYPE follow the name after a semicolon
+		prop := strings.SplitN(line[:colon], ";", 2)[0]
+		// and groups precede it with a dot
+		if dot := strings.LastIndex(prop, "."); dot >= 0 {
+			prop = prop[dot+1:]
+		}
+		value := strings.TrimSpace(line[colon+1:])
+		switch {
+		case strings.EqualFold(prop, "BEGIN") &&
+			strings.EqualFold(value, "VCARD"):
+			card = &Contact{}
+		case strings.EqualFold(prop, "END") && card != nil:
+			contacts = append(contacts, *card)
+			card = nil
+		case card == nil:
+		case strings.EqualFold(prop, fields.Name):
+			card.Name = value
+		case strings.EqualFold(prop, fields.Address):
+			card.Address = value
+		case strings.EqualFold(prop, fields.Key):
+			card.Fingerprint = value
+		}
+	}
+	return contacts, nil
+}
+
+// mergeContacts adds the imported contacts to the existing ones. Contacts
+// already there are never overwritten, differences are returned as conflicts
+func mergeContacts(existing, imported []Contact) ([]Contact, int, []string) {
+	var conflicts []string
+	added := 0
+next:
+	for _, c := range imported {
+		if c.Address == "" {
+			conflicts = append(conflicts,
+				fmt.Sprintf("%s has no address, skipped", c.Name))
+			continue
+		}
+		if c.Fingerprint != "" {
+			c.Fingerprint = normalizeFingerprint(c.Fingerprint)
+		}
+		for _, e := range existing {
+			switch {
+			case e.Address == c.Address && e.Name != c.Name:
+				conflicts = append(conflicts, fmt.Sprintf(
+					"%s is already known as %s, not as %s",
+					c.Address, e.Name, c.Name))
+			case e.Address == c.Address && c.Fingerprint != "" &&
+				e.Fingerprint != c.Fingerprint:
+				conflicts = append(conflicts, fmt.Sprintf(
+					"%s has a different fingerprint, kept %q",
+					c.Address, e.Fingerprint))
+			case e.Address != c.Address && c.Name != "" && e.Name == c.Name:
+				conflicts = append(conflicts, fmt.Sprintf(
+					"%s is already %s, not %s",
+					c.Name, e.Address, c.Address))
+			case e.Address != c.Address:
+				continue
+			}
+			continue next
+		}
+		existing = append(existing, c)
+		added++
+	}
+	return existing, added, conflicts
+}
+
+var errUnknownFormat = errors.New("not a vCard or CSV file")
+
+// importContacts merges the contacts of the vCard or CSV file at path into
+// the address book
+func importContacts(path string, fields *fieldMap) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var imported []Contact
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".vcf", ".vcard":
+		if fields == nil {
+			fields = &vcardFields
+		}
+		imported, err = readVCardContacts(f, *fields)
+	case ".csv":
+		if fields == nil {
+			fields = &csvFields
+		}
+		imported, err = readCSVContacts(f, *fields)
+	default:
+		return errUnknownFormat
+	}
+	if err != nil {
+		return err
+	}
+
+	existing, err := loadContacts()
+	if err != nil {
+		return err
+	}
+	contacts, added, conflicts := mergeContacts(existing, imported)
+	for _, conflict := range conflicts {
+		fmt.Println("conflict:", conflict)
+	}
+	if err := saveContacts(contacts); err != nil {
+		return err
+	}
+	fmt.Printf("imported %d of %d contacts\n", added, len(imported))
+	return nil
+}
+
+// contactsMain runs the contacts subcommand, returning the exit status
+func contactsMain(args []string) int {
+	if len(args) == 0 || args[0] != "import" {
+		fmt.Fprintln(os.Stderr, "usage: wrtcion contacts import",
+			"[-name field] [-address field] [-key field] <file>")
+		return 2
+	}
+	fs := flag.NewFlagSet("contacts import", flag.ContinueOnError)
+	name := fs.String("name", "", "field holding the name, FN or name by default")
+	addr := fs.String("address", "", "field holding the address, X-WRTCION or address by default")
+	key := fs.String("key", "", "field holding the certificate fingerprint, KEY or fingerprint by default")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "which file?")
+		return 2
+	}
+
+	var fields *fieldMap
+	if *name != "" || *addr != "" || *key != "" {
+		defaults := csvFields
+		if ext := strings.ToLower(filepath.Ext(fs.Arg(0))); ext == ".vcf" ||
+			ext == ".vcard" {
+			defaults = vcardFields
+		}
+		fields = &defaults
+		if *name != "" {
+			fields.Name = *name
+		}
+		if *addr != "" {
+			fields.Address = *addr
+		}
+		if *key != "" {
+			fields.Key = *key
+		}
+	}
+	if err := importContacts(fs.Arg(0), fields); err != nil {
+		fmt.Fprintln(os.Stderr, "couldn't import contacts:", err)
+		return 1
+	}
+	return 0
+}
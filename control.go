@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+var errNoDataChannel = errors.New("data channel is not open")
+
+type ControlAction int
+
+const (
+	Muted ControlAction = iota
+	Unmuted
+	Held
+	Resumed
+	FileOffer
+	FileAccept
+	FileRejected
+	FileReceived
+	FileResend
+	TypingStarted
+	TypingStopped
+	// Refer asks the remote to call Target instead of us
+	Refer
+	Transferred
+	TransferFailed
+	// Hello tells the display name of the sender
+	Hello
+)
+
+// ControlMessage is sent over the data channel as JSON, in an envelope of
+// type MsgControl
+type ControlMessage struct {
+	Action ControlAction
+	File   *FileInfo
+	// Chunks requested again by FileResend
+	Chunks []uint32
+	// Target is the address a call is transferred to
+	Target string
+	// Name is the display name sent with Hello
+	Name string `json:",omitempty"`
+}
+
+func (conn *Connection) sendControl(msg ControlMessage) error {
+	if conn.dataChan == nil {
+		return errNoDataChannel
+	}
+	if !conn.local.supports(conn.remoteAddr, CapControl) {
+		return errUnsupported
+	}
+	payload, err := json.Marshal(&msg)
+	if err != nil {
+		return err
+	}
+	return conn.sendMessage(conn.dataChan, MsgControl, payload)
+}
+
+func (conn *Connection) handleControlMsg(data []byte) {
+	var msg ControlMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		logError("couldn't parse control message from", conn, ":", err)
+		return
+	}
+	// A previewed call isn't answered yet, only the name of the caller is
+	// taken
+	if conn.previewing && msg.Action != Hello {
+		return
+	}
+
+	switch msg.Action {
+	case Muted:
+		conn.remoteMuted = true
+		log.Println(conn, "muted their microphone")
+	case Unmuted:
+		conn.remoteMuted = false
+		log.Println(conn, "unmuted their microphone")
+	case Held:
+		log.Println(conn, "put you on hold")
+	case Resumed:
+		log.Println(conn, "resumed the call")
+	case FileOffer:
+		conn.handleFileOffer(msg.File)
+	case FileAccept, FileRejected, FileReceived, FileResend:
+		conn.handleFileReply(msg)
+	case TypingStarted:
+		conn.typingAt = time.Now()
+	case TypingStopped:
+		conn.typingAt = time.Time{}
+	case Refer:
+		conn.handleRefer(msg.Target)
+	case Transferred:
+		log.Println(conn, "was transferred to", msg.Target)
+	case TransferFailed:
+		log.Println(conn, "couldn't be transferred to", msg.Target,
+			"the call goes on")
+	case Hello:
+		conn.setRemoteName(msg.Name)
+	default:
+		log.Println("unknown control message from", conn)
+	}
+}
+
+// SetMuted stops or resumes sending audio to remote. The track is kept, only
+// the samples stop being written
+func (peer *RTCPeer) SetMuted(remote string, muted bool) {
+	conn, ok := peer.Connections[remote]
+	if !ok {
+		log.Println("not connected to", remote)
+		return
+	}
+	if conn.audioSndr == nil {
+		log.Println("not sending any audio to", remote)
+		return
+	}
+	if conn.muted == muted {
+		return
+	}
+	conn.muted = muted
+	refreshStatus()
+
+	action := Unmuted
+	if muted {
+		action = Muted
+		log.Println("muted", remote)
+	} else {
+		log.Println("unmuted", remote)
+	}
+	if err := conn.sendControl(ControlMessage{Action: action}); err != nil {
+		logError("couldn't notify", remote, "of mute state:", err)
+	}
+}
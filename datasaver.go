@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"github.com/Yaroslav-95/wrtcion/gst"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// Audio in data saver mode: a low bitrate per channel, long frames to
+	// save on packet overhead, and nothing sent during silences
+	dataSaverBitrate   = 16000
+	dataSaverFrameSize = 60
+	// opusenc's own bitrate, used again when data saver is turned off in
+	// calls that didn't set one
+	opusEncoderBitrate = 64000
+	// ICE keepalives are sent far less often in data saver mode, and the
+	// connection is given longer to answer them
+	dataSaverKeepalive    = 10 * time.Second
+	dataSaverDisconnected = 15 * time.Second
+	dataSaverFailed       = 30 * time.Second
+	// Smaller messages aren't worth compressing
+	compressThreshold = 256
+	// Decompressed messages can't be bigger than this
+	maxInflatedSize = 1 << 20
+)
+
+var errTooBig = errors.New("decompressed message too big")
+
+// dataSaverAudio returns opts capped for data saver mode
+func dataSaverAudio(opts gst.OpusOptions) gst.OpusOptions {
+	max := dataSaverBitrate * opts.Channels()
+	if opts.Bitrate == 0 || opts.Bitrate > max {
+		opts.Bitrate = max
+	}
+	if opts.FrameSize < dataSaverFrameSize {
+		opts.FrameSize = dataSaverFrameSize
+	}
+	opts.DTX = true
+	return opts
+}
+
+// dataSaverTimeouts makes ICE keepalives less frequent
+func dataSaverTimeouts(s *webrtc.SettingEngine) {
+	s.SetICETimeouts(dataSaverDisconnected, dataSaverFailed, dataSaverKeepalive)
+}
+
+// SetDataSaver turns data saver mode on or off. Calls started from then on
+// send low bitrate audio and no video, messages are compressed and fewer
+// keepalives and typing notifications are sent. Ongoing calls have their
+// audio capped and their video paused
+func (peer *RTCPeer) SetDataSaver(on bool) {
+	if peer.DataSaver == on {
+		return
+	}
+	peer.DataSaver = on
+	if on {
+		log.Println("data saver on")
+	} else {
+		log.Println("data saver off")
+	}
+	for _, conn := range peer.Connections {
+		if conn.state != InCall {
+			continue
+		}
+		if conn.audioSndr != nil && conn.audioSndr.pipeline != nil {
+			bitrate := conn.settings.Audio.Bitrate
+			if on {
+				bitrate = dataSaverAudio(conn.settings.Audio).Bitrate
+			} else if bitrate == 0 {
+				bitrate = opusEncoderBitrate
+			}
+			conn.audioSndr.pipeline.SetBitrate(bitrate)
+		}
+		if conn.videoSndr != nil && !on {
+			// The remote can't decode anything until the next keyframe
+			conn.videoSndr.pipeline.ForceKeyframe()
+		}
+	}
+}
+
+// compress returns a message of type t deflated, if it is worth it
+func compress(t MessageType, payload []byte) ([]byte, bool) {
+	if len(payload) < compressThreshold {
+		return nil, false
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(byte(t))
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, false
+	}
+	if _, err := w.Write(payload); err != nil || w.Close() != nil {
+		return nil, false
+	}
+	if buf.Len() >= len(payload)+1 {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// decompress returns the type and the payload of a compressed message
+func decompress(msg []byte) (MessageType, []byte, error) {
+	if len(msg) == 0 {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	r := flate.NewReader(bytes.NewReader(msg[1:]))
+	defer r.Close()
+	payload, err := io.ReadAll(io.LimitReader(r, maxInflatedSize+1))
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(payload) > maxInflatedSize {
+		return 0, nil, errTooBig
+	}
+	return MessageType(msg[0]), payload, nil
+}
+
+// mbPerHour estimates how much data a call uses per hour at its current
+// rates
+func (conn *Connection) mbPerHour() float64 {
+	up, down := conn.rates.rates()
+	return (up + down) / 8 * 3600 / 1000
+}
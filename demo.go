@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// Where the demo peer listens when no port is given
+	demoAddr = "localhost:8009"
+	// How long the demo peer waits before playing the audio back, so that
+	// it isn't drowned by what is being said
+	demoDelay = time.Second
+)
+
+// demoListenAddr returns where wrtcion demo listens, given its argument: a
+// port, an address, or nothing for demoAddr
+func demoListenAddr(arg string) string {
+	if arg == "" {
+		return demoAddr
+	}
+	if !strings.Contains(arg, ":") {
+		return "localhost:" + arg
+	}
+	return arg
+}
+
+type echoPacket struct {
+	at     time.Time
+	packet *rtp.Packet
+}
+
+// echoAudio sends the audio the remote sends us back to it, demoDelay
+// later. It replaces getAudio for the calls of the demo peer
+func (conn *Connection) echoAudio() error {
+	track, err := webrtc.NewTrackLocalStaticRTP(
+		audioCodec,
+		"audio",
+		conn.String(),
+	)
+	if err != nil {
+		return err
+	}
+	sender, err := conn.peer.AddTrack(track)
+	if err != nil {
+		return err
+	}
+	go func() {
+		buf := make([]byte, conn.local.receiveMTU())
+		for {
+			if _, _, err := sender.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn.peer.OnTrack(func(
+		remote *webrtc.TrackRemote,
+		recvr *webrtc.RTPReceiver,
+	) {
+		if remote.Kind() != webrtc.RTPCodecTypeAudio {
+			return
+		}
+		// Enough for demoDelay of 20 ms packets, with room to spare
+		delayed := make(chan echoPacket, 4*int(demoDelay/(20*time.Millisecond)))
+		defer close(delayed)
+		go func() {
+			for p := range delayed {
+				time.Sleep(time.Until(p.at.Add(demoDelay)))
+				if err := track.WriteRTP(p.packet); err != nil {
+					logError("couldn't echo audio to", conn, ":", err)
+				}
+			}
+		}()
+		log.Println("echoing audio to", conn)
+		for conn.state == InCall {
+			packet, _, err := remote.ReadRTP()
+			if err == io.EOF {
+				return
+			} else if err != nil {
+				logError("track read error:", err)
+				return
+			}
+			if strings.EqualFold(remote.Codec().MimeType, mimeTypeTelephoneEvent) {
+				continue
+			}
+			select {
+			case delayed <- echoPacket{time.Now(), packet}:
+			default:
+				// Falling behind, better to skip than to grow the delay
+			}
+		}
+	})
+	return nil
+}
+
+// echoChat sends a chat message back to the remote it came from
+func (conn *Connection) echoChat(msg ChatMessage) {
+	if err := conn.sendChat(msg.Type, msg.Body); err != nil {
+		logError("couldn't echo message to", conn, ":", err)
+	}
+}
+
+// demoMain runs a peer that answers every call, playing back the audio and
+// the messages it gets, for new users to try their setup against
+func demoMain(rtcpeer *RTCPeer, flog io.Writer) {
+	log.SetOutput(io.MultiWriter(flog, os.Stdout))
+	fmt.Println("demo peer answering calls at", rtcpeer.listenAddr)
+	fmt.Println("in another terminal, run wrtcion and enter:")
+	fmt.Printf("  /call %s    to hear yourself %s later\n",
+		rtcpeer.listenAddr, demoDelay)
+	fmt.Printf("  /chat %s    to have your messages sent back\n",
+		rtcpeer.listenAddr)
+	rtcpeer.Listen()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// Outgoing media is packetized into RTP packets of up to this size, as
+	// Pion does, or smaller ones if the path doesn't take that
+	rtpOutboundMTU = 1200
+	// Worst case IPv6 + UDP + SRTP overhead on top of the RTP payload
+	mediaOverhead = 40 + 8 + 10
+	defaultMTU    = 1500
+	// The smallest RTP packets sent, what is left of the smallest datagram
+	// every IPv4 host takes
+	minRTPPacket = 576 - mediaOverhead
+)
+
+var errNoInterface = errors.New("no interface with that address")
+
+// linkMTU finds the MTU of the local network interface that owns ip. The
+// path MTU can't be larger than that, so it is a good first guess for links
+// such as VPN tunnels which usually have a small one
+func linkMTU(ip string) (int, string, error) {
+	addr := net.ParseIP(ip)
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return 0, "", err
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			if ok && ipnet.IP.Equal(addr) {
+				return iface.MTU, iface.Name, nil
+			}
+		}
+	}
+	return 0, "", errNoInterface
+}
+
+func (conn *Connection) selectedPair() *webrtc.ICECandidatePair {
+	pair, err := conn.peer.SCTP().Transport().ICETransport().
+		GetSelectedCandidatePair()
+	if err != nil {
+		return nil
+	}
+	return pair
+}
+
+// pathMTU returns the MTU discovered for the currently selected candidate
+// pair, falling back to the configured one
+func (conn *Connection) pathMTU() (int, string) {
+	mtu := int(conn.local.receiveMTU())
+	pair := conn.selectedPair()
+	if pair == nil {
+		return mtu, ""
+	}
+	linkmtu, iface, err := linkMTU(pair.Local.Address)
+	if err != nil || linkmtu >= mtu {
+		return mtu, ""
+	}
+	return linkmtu, iface
+}
+
+func (peer *RTCPeer) receiveMTU() uint {
+	if peer.MTU == 0 {
+		return defaultMTU
+	}
+	return peer.MTU
+}
+
+// Diag logs diagnostic information about the connection to remote
+func (peer *RTCPeer) Diag(remote string) {
+	conn, ok := peer.Connections[remote]
+	if !ok {
+		log.Println("not connected to", remote)
+		return
+	}
+	log.Println("diagnostics for", conn)
+	log.Println("  connection state:", conn.peer.ConnectionState())
+	log.Println("  ice state:", conn.peer.ICEConnectionState())
+	if pair := conn.selectedPair(); pair != nil {
+		log.Println("  candidate pair:", pair)
+	}
+	mtu, iface := conn.pathMTU()
+	if iface != "" {
+		log.Printf("  path mtu: %d (limited by %s)\n", mtu, iface)
+	} else {
+		log.Println("  path mtu:", mtu)
+	}
+	log.Println("  media packets:", conn.packetSize(), "bytes")
+	if mtu < minRTPPacket+mediaOverhead {
+		log.Println("  warning: the path mtu is too small for media",
+			"packets, they will likely be dropped")
+	}
+}
+
+// packetSize is the size of the RTP packets sent to the remote
+func (conn *Connection) packetSize() int {
+	if conn.packetMTU == 0 {
+		return rtpOutboundMTU
+	}
+	return conn.packetMTU
+}
+
+// checkPathMTU sizes the media packets we send to the link used by the
+// connection, warning when it is too small for any
+func (conn *Connection) checkPathMTU() {
+	mtu, iface := conn.pathMTU()
+	size := mtu - mediaOverhead
+	switch {
+	case size >= rtpOutboundMTU:
+		size = rtpOutboundMTU
+	case size < minRTPPacket:
+		logWarn(fmt.Sprintf(
+			"path to %s has an mtu of %d (%s), media might not get through",
+			conn,
+			mtu,
+			iface,
+		))
+		size = minRTPPacket
+	default:
+		logDebug(fmt.Sprintf("path to %s has an mtu of %d (%s), sending media "+
+			"in packets of %d bytes", conn, mtu, iface, size))
+	}
+	conn.packetMTU = size
+	if conn.audioSndr != nil && conn.audioSndr.track != nil {
+		conn.audioSndr.track.setMTU(size)
+	}
+	if conn.videoSndr != nil && conn.videoSndr.track != nil {
+		conn.videoSndr.track.setMTU(size)
+	}
+}
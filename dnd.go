@@ -0,0 +1,27 @@
+package main
+
+import "log"
+
+// SetDND turns do not disturb mode on or off. While on, calls are refused as
+// unavailable without ringing, and messages are kept to be shown when it is
+// turned off
+func (peer *RTCPeer) SetDND(on bool) {
+	if peer.dnd == on {
+		return
+	}
+	peer.dnd = on
+	if on {
+		log.Println("do not disturb: calls are refused and messages kept",
+			"until /dnd off")
+		return
+	}
+	log.Println("do not disturb is off")
+	if len(peer.quiet) == 0 {
+		return
+	}
+	log.Println("messages received meanwhile:")
+	for _, line := range peer.quiet {
+		log.Println(line)
+	}
+	peer.quiet = nil
+}
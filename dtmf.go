@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	mimeTypeTelephoneEvent = "audio/telephone-event"
+	telephoneEventPT       = 101
+	// How long each digit lasts, and how often its packets are sent
+	dtmfToneDuration   = 100 * time.Millisecond
+	dtmfPacketInterval = 50 * time.Millisecond
+	// RFC 4733 recommends sending the end of an event three times
+	dtmfEndRepeats = 3
+	dtmfVolume     = 10
+)
+
+const dtmfDigits = "0123456789*#ABCD"
+
+var errNoTelephoneEvents = errors.New("peer doesn't accept telephone events")
+
+// dtmfInterceptor puts RFC 4733 telephone events into the outgoing audio
+// stream. Pion's tracks write every packet with the payload type of the
+// track's codec and their own sequence numbers, so the interceptor takes
+// over the sequence numbers of the stream and slots the events in between
+// the audio packets
+type dtmfInterceptor struct {
+	interceptor.NoOp
+	mu      sync.Mutex
+	writer  interceptor.RTPWriter
+	ssrc    uint32
+	seq     uint16
+	lastTS  uint32
+	started bool
+}
+
+// NewInterceptor lets the interceptor be its own factory, there is one per
+// connection anyway
+func (d *dtmfInterceptor) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return d, nil
+}
+
+func (d *dtmfInterceptor) BindLocalStream(
+	info *interceptor.StreamInfo,
+	writer interceptor.RTPWriter,
+) interceptor.RTPWriter {
+	if !strings.EqualFold(info.MimeType, webrtc.MimeTypeOpus) {
+		return writer
+	}
+	d.mu.Lock()
+	d.writer = writer
+	d.ssrc = info.SSRC
+	d.seq = uint16(rand.Uint32())
+	d.mu.Unlock()
+
+	return interceptor.RTPWriterFunc(func(
+		header *rtp.Header,
+		payload []byte,
+		attributes interceptor.Attributes,
+	) (int, error) {
+		d.mu.Lock()
+		header.SequenceNumber = d.seq
+		d.seq++
+		d.lastTS = header.Timestamp
+		d.started = true
+		d.mu.Unlock()
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+// writeEvent sends a single telephone event packet
+func (d *dtmfInterceptor) writeEvent(
+	pt uint8,
+	ts uint32,
+	event byte,
+	duration uint16,
+	first, end bool,
+) error {
+	payload := make([]byte, 4)
+	payload[0] = event
+	payload[1] = dtmfVolume
+	if end {
+		payload[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(payload[2:], duration)
+
+	d.mu.Lock()
+	header := &rtp.Header{
+		Version:        2,
+		Marker:         first,
+		PayloadType:    pt,
+		SequenceNumber: d.seq,
+		Timestamp:      ts,
+		SSRC:           d.ssrc,
+	}
+	d.seq++
+	writer := d.writer
+	d.mu.Unlock()
+	_, err := writer.Write(header, payload, nil)
+	return err
+}
+
+// sendDigit sends event as a tone of dtmfToneDuration, starting at the
+// timestamp of the latest audio packet
+func (d *dtmfInterceptor) sendDigit(pt uint8, clockRate uint32, event byte) error {
+	d.mu.Lock()
+	ts := d.lastTS
+	d.mu.Unlock()
+
+	step := uint16(uint32(dtmfPacketInterval/time.Millisecond) * clockRate / 1000)
+	total := uint16(uint32(dtmfToneDuration/time.Millisecond) * clockRate / 1000)
+	ticker := time.NewTicker(dtmfPacketInterval)
+	defer ticker.Stop()
+	for duration := step; duration < total; duration += step {
+		err := d.writeEvent(pt, ts, event, duration, duration == step, false)
+		if err != nil {
+			return err
+		}
+		<-ticker.C
+	}
+	for i := 0; i < dtmfEndRepeats; i++ {
+		if err := d.writeEvent(pt, ts, event, total, false, true); err != nil {
+			return err
+		}
+	}
+	// Leave a gap before the next digit
+	<-ticker.C
+	return nil
+}
+
+// telephoneEventCodec finds the telephone-event codec negotiated for the
+// audio we send
+func (conn *Connection) telephoneEventCodec() (webrtc.RTPCodecParameters, bool) {
+	if conn.audioSndr == nil {
+		return webrtc.RTPCodecParameters{}, false
+	}
+	for _, codec := range conn.audioSndr.rtp.GetParameters().Codecs {
+		if strings.EqualFold(codec.MimeType, mimeTypeTelephoneEvent) {
+			return codec, true
+		}
+	}
+	return webrtc.RTPCodecParameters{}, false
+}
+
+// SendDTMF sends digits to remote as RFC 4733 telephone events in the audio
+// stream
+func (peer *RTCPeer) SendDTMF(remote, digits string) {
+	conn, ok := peer.Connections[remote]
+	if !ok {
+		log.Println("not connected to", remote)
+		return
+	}
+	if conn.state != InCall || conn.audioSndr == nil || !conn.dtmf.started {
+		log.Println("not sending any audio to", remote)
+		return
+	}
+	codec, ok := conn.telephoneEventCodec()
+	if !ok {
+		log.Println("can't send dtmf to", remote, ":", errNoTelephoneEvents)
+		return
+	}
+	digits = strings.ToUpper(digits)
+	for _, c := range digits {
+		if !strings.ContainsRune(dtmfDigits, c) {
+			log.Printf("%c isn't a dtmf digit\n", c)
+			return
+		}
+	}
+	go func() {
+		for _, c := range digits {
+			event := byte(strings.IndexRune(dtmfDigits, c))
+			err := conn.dtmf.sendDigit(
+				uint8(codec.PayloadType),
+				codec.ClockRate,
+				event,
+			)
+			if err != nil {
+				logError("couldn't send dtmf to", remote, ":", err)
+				return
+			}
+		}
+		log.Println("sent", digits, "to", remote)
+	}()
+}
+
+// handleTelephoneEvent reports the digits received in telephone event
+// packets. Only the first packet marking the end of each event is
+// reported, the rest are repeats
+func (conn *Connection) handleTelephoneEvent(buf []byte) {
+	var pkt rtp.Packet
+	if err := pkt.Unmarshal(buf); err != nil || len(pkt.Payload) < 4 {
+		return
+	}
+	end := pkt.Payload[1]&0x80 != 0
+	if !end || pkt.Timestamp == conn.lastEventTS {
+		return
+	}
+	conn.lastEventTS = pkt.Timestamp
+	if int(pkt.Payload[0]) >= len(dtmfDigits) {
+		return
+	}
+	log.Printf("%s sent dtmf %c\n", conn, dtmfDigits[pkt.Payload[0]])
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"log"
+	"sync"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const (
+	// e2eContext is signed along with the keys, so that the signatures can't
+	// be passed off as anything else made with the same certificate
+	e2eContext = "wrtcion e2e key\x00"
+	// How far behind the newest sealed message others may still arrive, as
+	// the data channels aren't ordered with each other
+	e2eWindow = 64
+	// The direction and sequence number sealed before every message
+	e2eHeader = 1 + 8
+)
+
+var (
+	errNoE2EKey     = errors.New("no end-to-end key from the peer")
+	errE2ESignature = errors.New("end-to-end key not signed by the certificate pinned for the peer")
+	errE2EOpen      = errors.New("couldn't decrypt message")
+	errE2EReplay    = errors.New("message already received")
+)
+
+// E2EKey is the X25519 key a peer uses for a single connection, signed with
+// the key of its certificate. Chat messages and files are encrypted with
+// it on top of DTLS, so that nothing relaying them can read them. Peers
+// only send one to those they pinned with /trust, and the certificate has to
+// be the one pinned for them, not whatever the DTLS hop was made with
+type E2EKey struct {
+	Public      []byte
+	Signature   []byte
+	Certificate []byte
+}
+
+// e2eSession holds the keys of the end-to-end encryption of a connection
+type e2eSession struct {
+	mu      sync.Mutex
+	private [32]byte
+	public  [32]byte
+	theirs  *E2EKey
+	shared  *[32]byte
+	// pinned is the fingerprint pinned for the remote when we sent our key,
+	// empty if we didn't
+	pinned string
+	// err is set if the remote's key can't be trusted
+	err error
+	// sent is the sequence number of the last message sealed, received the
+	// highest one opened and seen the ones opened in the window below it
+	sent     uint64
+	received uint64
+	seen     uint64
+}
+
+func newE2ESession() (*e2eSession, error) {
+	s := new(e2eSession)
+	if _, err := rand.Read(s.private[:]); err != nil {
+		return nil, err
+	}
+	public, err := curve25519.X25519(s.private[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	copy(s.public[:], public)
+	return s, nil
+}
+
+// identityKey returns the private key of the identity certificate stored in
+// pems, the way Pion writes it
+func identityKey(pems []byte) (crypto.Signer, error) {
+	_, rest := pem.Decode(pems)
+	block, _ := pem.Decode(rest)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("no private key in the identity")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("the identity key can't sign")
+	}
+	return signer, nil
+}
+
+func e2eDigest(public []byte) []byte {
+	sum := sha256.Sum256(append([]byte(e2eContext), public...))
+	return sum[:]
+}
+
+// certificateDER returns our identity certificate, nil if we use a new one
+// for every connection
+func (peer *RTCPeer) certificateDER() []byte {
+	if peer.certificate == nil {
+		return nil
+	}
+	pems, err := peer.certificate.PEM()
+	if err != nil {
+		return nil
+	}
+	block, _ := pem.Decode([]byte(pems))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil
+	}
+	return block.Bytes
+}
+
+// e2eKey returns the signed key conn advertises while signaling, nil if we
+// have no identity to sign it with or haven't pinned the remote's
+func (conn *Connection) e2eKey() *E2EKey {
+	if conn.local.identityKey == nil || conn.e2e == nil {
+		return nil
+	}
+	pinned, ok := conn.local.trusted[conn.remoteAddr]
+	if !ok {
+		return nil
+	}
+	der := conn.local.certificateDER()
+	if der == nil {
+		return nil
+	}
+	sig, err := conn.local.identityKey.Sign(
+		rand.Reader,
+		e2eDigest(conn.e2e.public[:]),
+		crypto.SHA256,
+	)
+	if err != nil {
+		logError("couldn't sign the end-to-end key:", err)
+		return nil
+	}
+	conn.e2e.mu.Lock()
+	conn.e2e.pinned = pinned
+	conn.e2e.mu.Unlock()
+	return &E2EKey{
+		Public:      conn.e2e.public[:],
+		Signature:   sig,
+		Certificate: der,
+	}
+}
+
+// setTheirKey keeps the end-to-end key the remote sent while signaling, nil
+// if it sent none
+func (conn *Connection) setTheirKey(key *E2EKey) {
+	conn.e2e.mu.Lock()
+	conn.e2e.theirs = key
+	conn.e2e.mu.Unlock()
+}
+
+// sealing tells whether the messages exchanged with the remote are
+// encrypted end-to-end: when both ends sent their key, having pinned each
+// other. Both ends decide it the same way
+func (conn *Connection) sealing() bool {
+	if conn.e2e == nil || !conn.local.supports(conn.remoteAddr, CapE2E) {
+		return false
+	}
+	conn.e2e.mu.Lock()
+	defer conn.e2e.mu.Unlock()
+	return conn.e2e.theirs != nil && conn.e2e.pinned != ""
+}
+
+// sharedKey derives the key shared with the remote, once the certificate
+// pinned for it has vouched for its key
+func (conn *Connection) sharedKey() (*[32]byte, error) {
+	s := conn.e2e
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shared != nil || s.err != nil {
+		return s.shared, s.err
+	}
+	// The remote may send sealed messages before its key, or never send it
+	if s.theirs == nil || len(s.theirs.Public) != 32 {
+		return nil, errNoE2EKey
+	}
+	if !verifyE2EKey(s.theirs, s.pinned) {
+		s.err = errE2ESignature
+		log.Println("not talking to", conn, ":", s.err)
+		return nil, s.err
+	}
+	var theirs [32]byte
+	copy(theirs[:], s.theirs.Public)
+	s.shared = new([32]byte)
+	box.Precompute(s.shared, &theirs, &s.private)
+	log.Println("chat and files with", conn, "are encrypted end-to-end")
+	return s.shared, nil
+}
+
+// verifyE2EKey checks that key was signed with a certificate whose
+// fingerprint is pinned
+func verifyE2EKey(key *E2EKey, pinned string) bool {
+	if pinned == "" || fingerprint(key.Certificate) != pinned {
+		return false
+	}
+	cert, err := x509.ParseCertificate(key.Certificate)
+	if err != nil {
+		return false
+	}
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	return ok && ecdsa.VerifyASN1(pub, e2eDigest(key.Public), key.Signature)
+}
+
+// direction tells the messages sealed by the end with public key from apart
+// from those sealed by the other, which share the key, so that a relay can't
+// send our own messages back to us
+func direction(from, to []byte) byte {
+	if bytes.Compare(from, to) < 0 {
+		return 0
+	}
+	return 1
+}
+
+// seal encrypts a message of type t for the remote, numbering it
+func (conn *Connection) seal(t MessageType, payload []byte) ([]byte, error) {
+	key, err := conn.sharedKey()
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	s := conn.e2e
+	s.mu.Lock()
+	s.sent++
+	seq := s.sent
+	dir := direction(s.public[:], s.theirs.Public)
+	s.mu.Unlock()
+	msg := make([]byte, e2eHeader+1+len(payload))
+	msg[0] = dir
+	binary.BigEndian.PutUint64(msg[1:e2eHeader], seq)
+	msg[e2eHeader] = byte(t)
+	copy(msg[e2eHeader+1:], payload)
+	return secretbox.Seal(nonce[:], msg, &nonce, key), nil
+}
+
+// unseal decrypts a sealed message, returning its type and payload. Messages
+// from us, already opened or too old to tell are rejected
+func (conn *Connection) unseal(sealed []byte) (MessageType, []byte, error) {
+	key, err := conn.sharedKey()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(sealed) < 24 {
+		return 0, nil, errE2EOpen
+	}
+	var nonce [24]byte
+	copy(nonce[:], sealed)
+	msg, ok := secretbox.Open(nil, sealed[24:], &nonce, key)
+	if !ok || len(msg) <= e2eHeader {
+		return 0, nil, errE2EOpen
+	}
+	s := conn.e2e
+	s.mu.Lock()
+	if msg[0] != direction(s.theirs.Public, s.public[:]) {
+		s.mu.Unlock()
+		return 0, nil, errE2EOpen
+	}
+	fresh := s.accept(binary.BigEndian.Uint64(msg[1:e2eHeader]))
+	s.mu.Unlock()
+	if !fresh {
+		return 0, nil, errE2EReplay
+	}
+	return MessageType(msg[e2eHeader]), msg[e2eHeader+1:], nil
+}
+
+// accept records the sequence number of a message opened, telling whether
+// it is new. s.mu must be held
+func (s *e2eSession) accept(seq uint64) bool {
+	switch {
+	case seq == 0:
+		return false
+	case seq > s.received:
+		shift := seq - s.received
+		if shift >= e2eWindow {
+			s.seen = 0
+		} else {
+			s.seen <<= shift
+		}
+		// The bit of the newest one
+		s.seen |= 1
+		s.received = seq
+		return true
+	case s.received-seq >= e2eWindow:
+		return false
+	}
+	bit := uint64(1) << (s.received - seq)
+	if s.seen&bit != 0 {
+		return false
+	}
+	s.seen |= bit
+	return true
+}
+
+// e2eEstablished tells whether the shared key has been derived
+func (conn *Connection) e2eEstablished() bool {
+	if conn.e2e == nil {
+		return false
+	}
+	conn.e2e.mu.Lock()
+	defer conn.e2e.mu.Unlock()
+	return conn.e2e.shared != nil
+}
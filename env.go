@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix starts the environment variables that stand in for flags and
+// config file keys, for containers and services
+const envPrefix = "WRTCION_"
+
+// envNames are the variables of the flags whose names don't say enough
+var envNames = map[string]string{
+	"l": envPrefix + "LISTEN",
+}
+
+// envName is the variable that sets the flag called name, like
+// WRTCION_TURN_USER for -turn-user
+func envName(name string) string {
+	if env, ok := envNames[name]; ok {
+		return env
+	}
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnv sets the flags not given on the command line from their
+// environment variables, if those are set. It has to be called after
+// flag.Parse
+func applyEnv() error {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || given[f.Name] || err != nil {
+			return
+		}
+		if e := flag.Set(f.Name, value); e != nil {
+			err = fmt.Errorf("%s: %v", envName(f.Name), e)
+		}
+	})
+	return err
+}
+
+// envString returns the value of the variable for the config file key
+// called name, like WRTCION_TIME_FORMAT for TimeFormat, or value if it isn't
+// set
+func envString(name, value string) string {
+	if env, ok := os.LookupEnv(envPrefix + name); ok {
+		return env
+	}
+	return value
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// MessageType tells what a data channel message carries. It is the first
+// byte of every binary message sent to peers with CapEnvelope, followed by
+// the payload
+type MessageType byte
+
+const (
+	MsgChat MessageType = iota + 1
+	MsgControl
+	MsgFileChunk
+	MsgChatJSON
+	MsgReceipt
+	MsgClipboard
+	// MsgSealed wraps another message encrypted end-to-end
+	MsgSealed
+	// MsgCompressed wraps another message deflated, in data saver mode
+	MsgCompressed
+)
+
+func (t MessageType) String() string {
+	switch t {
+	case MsgChat:
+		return "chat"
+	case MsgControl:
+		return "control"
+	case MsgFileChunk:
+		return "file chunk"
+	case MsgChatJSON:
+		return "chat"
+	case MsgReceipt:
+		return "receipt"
+	case MsgClipboard:
+		return "clipboard"
+	case MsgSealed:
+		return "sealed"
+	case MsgCompressed:
+		return "compressed"
+	}
+	return "unknown"
+}
+
+// sendMessage sends payload of type t through d. Peers without envelopes get
+// chat as text and everything else bare, as they always have
+func (conn *Connection) sendMessage(
+	d *webrtc.DataChannel,
+	t MessageType,
+	payload []byte,
+) error {
+	if d == nil {
+		return errNoDataChannel
+	}
+	if !conn.local.supports(conn.remoteAddr, CapEnvelope) {
+		if t == MsgChat {
+			return d.SendText(string(payload))
+		}
+		return d.Send(payload)
+	}
+	if conn.local.DataSaver && conn.local.supports(conn.remoteAddr, CapCompress) {
+		if deflated, ok := compress(t, payload); ok {
+			t, payload = MsgCompressed, deflated
+		}
+	}
+	if conn.sealing() {
+		sealed, err := conn.seal(t, payload)
+		if err != nil {
+			return err
+		}
+		t, payload = MsgSealed, sealed
+	}
+	msg := make([]byte, 1+len(payload))
+	msg[0] = byte(t)
+	copy(msg[1:], payload)
+	return d.Send(msg)
+}
+
+// openEnvelope returns the type and payload of msg. Text is always chat, and
+// binary messages from peers without envelopes are of type bare, the only
+// type such peers send through that channel. Sealed messages are decrypted,
+// and unless they fail to, those left unsealed by a peer that should have
+// sealed them are dropped
+func (conn *Connection) openEnvelope(
+	msg webrtc.DataChannelMessage,
+	bare MessageType,
+) (MessageType, []byte) {
+	if msg.IsString {
+		return MsgChat, msg.Data
+	}
+	if !conn.local.supports(conn.remoteAddr, CapEnvelope) {
+		return bare, msg.Data
+	}
+	if len(msg.Data) == 0 {
+		return 0, nil
+	}
+	t, payload := MessageType(msg.Data[0]), msg.Data[1:]
+	if t == MsgSealed {
+		var err error
+		t, payload, err = conn.unseal(payload)
+		if err != nil {
+			logWarn("dropped message from", conn, ":", err)
+			return 0, nil
+		}
+	} else if conn.sealing() {
+		logWarn(fmt.Sprintf("dropped unencrypted %s message from %s", t, conn))
+		return 0, nil
+	}
+	if t == MsgCompressed {
+		var err error
+		t, payload, err = decompress(payload)
+		if err != nil {
+			logWarn("dropped compressed message from", conn, ":", err)
+			return 0, nil
+		}
+	}
+	return t, payload
+}
+
+func (conn *Connection) handleDataChanMsg(msg webrtc.DataChannelMessage) {
+	t, payload := conn.openEnvelope(msg, MsgControl)
+	// Nothing but control messages until a previewed call is answered
+	if conn.previewing && t != MsgControl {
+		return
+	}
+	switch t {
+	case MsgChat:
+		conn.showChat(ChatMessage{Type: ChatText, Body: string(payload)})
+	case MsgControl:
+		conn.handleControlMsg(payload)
+	case MsgChatJSON:
+		conn.handleChatJSON(payload)
+	case MsgReceipt:
+		conn.handleReceipt(payload)
+	case MsgClipboard:
+		conn.handleClip(payload)
+	default:
+		// Never dump binary payloads to the log
+		logDebug(fmt.Sprintf("ignored %s message (%d bytes) from %s", t,
+			len(payload), conn))
+	}
+}
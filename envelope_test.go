@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const testRemote = "peer.example:8001"
+
+// testConn is a connection to testRemote, which advertised caps. nil caps
+// are those of a peer never talked to, which is assumed to have them all
+func testConn(t *testing.T, caps []Capability) *Connection {
+	t.Helper()
+	peer := &RTCPeer{caps: make(map[string][]Capability)}
+	if caps != nil {
+		peer.caps[testRemote] = caps
+	}
+	e2e, err := newE2ESession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Connection{local: peer, remoteAddr: testRemote, e2e: e2e}
+}
+
+func deflated(t *testing.T, typ MessageType, payload []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	buf.WriteByte(byte(MsgCompressed))
+	buf.WriteByte(byte(typ))
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestOpenEnvelope(t *testing.T) {
+	long := bytes.Repeat([]byte("hello "), 100)
+	tests := []struct {
+		name    string
+		caps    []Capability
+		sealing bool
+		msg     webrtc.DataChannelMessage
+		bare    MessageType
+		want    MessageType
+		payload []byte
+	}{
+		{
+			name:    "text is chat",
+			msg:     webrtc.DataChannelMessage{IsString: true, Data: []byte("hi")},
+			want:    MsgChat,
+			payload: []byte("hi"),
+		},
+		{
+			name:    "binary from an older client",
+			caps:    []Capability{CapControl},
+			msg:     webrtc.DataChannelMessage{Data: []byte{byte(MsgChat), 'x'}},
+			bare:    MsgControl,
+			want:    MsgControl,
+			payload: []byte{byte(MsgChat), 'x'},
+		},
+		{
+			name: "empty",
+			msg:  webrtc.DataChannelMessage{Data: []byte{}},
+		},
+		{
+			name:    "typed",
+			msg:     webrtc.DataChannelMessage{Data: []byte{byte(MsgReceipt), '{', '}'}},
+			want:    MsgReceipt,
+			payload: []byte("{}"),
+		},
+		{
+			name:    "type only",
+			msg:     webrtc.DataChannelMessage{Data: []byte{byte(MsgControl)}},
+			want:    MsgControl,
+			payload: []byte{},
+		},
+		{
+			name:    "compressed",
+			msg:     webrtc.DataChannelMessage{Data: deflated(t, MsgChatJSON, long)},
+			want:    MsgChatJSON,
+			payload: long,
+		},
+		{
+			name: "compressed past the limit",
+			msg: webrtc.DataChannelMessage{Data: deflated(t, MsgChatJSON,
+				make([]byte, maxInflatedSize+1))},
+		},
+		{
+			name: "compressed garbage",
+			msg: webrtc.DataChannelMessage{Data: []byte{
+				byte(MsgCompressed), byte(MsgChat), 0xff, 0xff, 0xff,
+			}},
+		},
+		{
+			name: "compressed without a type",
+			msg:  webrtc.DataChannelMessage{Data: []byte{byte(MsgCompressed)}},
+		},
+		{
+			name: "sealed before the key",
+			msg: webrtc.DataChannelMessage{Data: append(
+				[]byte{byte(MsgSealed)}, make([]byte, 64)...)},
+		},
+		{
+			name:    "unsealed while sealing",
+			sealing: true,
+			msg:     webrtc.DataChannelMessage{Data: []byte{byte(MsgChatJSON), '{', '}'}},
+		},
+		{
+			name:    "sealed garbage while sealing",
+			sealing: true,
+			msg:     webrtc.DataChannelMessage{Data: []byte{byte(MsgSealed), 1, 2, 3}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := testConn(t, tt.caps)
+			if tt.sealing {
+				conn.e2e.theirs = &E2EKey{Public: make([]byte, 32)}
+				conn.e2e.pinned = "sha-256 00"
+			}
+			typ, payload := conn.openEnvelope(tt.msg, tt.bare)
+			if typ != tt.want || !bytes.Equal(payload, tt.payload) {
+				t.Errorf("got %s %q, want %s %q", typ, payload, tt.want,
+					tt.payload)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	defaultVideoBitrate = 1000000
+	minVideoBitrate     = 100000
+	maxVideoBitrate     = 2500000
+)
+
+// readSenderRTCP reads the RTCP packets the remote sends about one of our
+// tracks and passes each of them to handle. They have to be read even if
+// they are not needed, otherwise the interceptors don't get to see them
+func readSenderRTCP(sender *webrtc.RTPSender, handle func(rtcp.Packet)) {
+	for {
+		pkts, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		for _, pkt := range pkts {
+			handle(pkt)
+		}
+	}
+}
+
+// bitrateController estimates the bitrate we can send at from the feedback
+// of the receiver. The estimate is based on the packet loss reported in
+// receiver reports, following the loss based part of Google's congestion
+// control, and capped by the REMB of the receiver if it sends any
+type bitrateController struct {
+	bitrate int
+	min     int
+	max     int
+	remb    int
+}
+
+func newBitrateController(start, min, max int) *bitrateController {
+	return &bitrateController{
+		bitrate: start,
+		min:     min,
+		max:     max,
+	}
+}
+
+// update feeds pkt to the controller, returns the new bitrate and whether it
+// changed. Only the reports about ssrc are taken into account, and they only
+// raise the bitrate if grow is set
+func (c *bitrateController) update(
+	pkt rtcp.Packet,
+	ssrc uint32,
+	grow bool,
+) (int, bool) {
+	prev := c.bitrate
+	switch p := pkt.(type) {
+	case *rtcp.ReceiverEstimatedMaximumBitrate:
+		c.remb = int(p.Bitrate)
+	case *rtcp.ReceiverReport:
+		for _, report := range p.Reports {
+			if report.SSRC != ssrc {
+				continue
+			}
+			loss := float64(report.FractionLost) / 256
+			switch {
+			case loss > 0.1:
+				c.bitrate = int(float64(c.bitrate) * (1 - 0.5*loss))
+			case loss < 0.02 && grow:
+				c.bitrate = int(float64(c.bitrate) * 1.08)
+			}
+		}
+	default:
+		return c.bitrate, false
+	}
+
+	if c.remb > 0 && c.bitrate > c.remb {
+		c.bitrate = c.remb
+	}
+	if c.bitrate > c.max {
+		c.bitrate = c.max
+	}
+	if c.bitrate < c.min {
+		c.bitrate = c.min
+	}
+	return c.bitrate, c.bitrate != prev
+}
+
+// handleVideoRTCP adapts the video encoder to the feedback of the remote:
+// its bitrate is kept in line with what the network between us can take
+// after the audio, and a keyframe is sent whenever the remote loses the
+// picture, for as long as the video track is being sent
+func (conn *Connection) handleVideoRTCP() {
+	start, max := defaultVideoBitrate, maxVideoBitrate
+	if conn.settings.Video.Bitrate > 0 {
+		start = conn.settings.Video.Bitrate
+		max = conn.settings.Video.Bitrate
+	}
+	ssrc := senderSSRC(conn.videoSndr.rtp)
+	var audioSSRC uint32
+	if conn.audioSndr != nil {
+		audioSSRC = senderSSRC(conn.audioSndr.rtp)
+	}
+	conn.priority.begin(audioSSRC, ssrc, conn.audioBitrate(), start, max)
+	conn.videoSndr.pipeline.SetBitrate(start)
+
+	readSenderRTCP(conn.videoSndr.rtp, func(pkt rtcp.Packet) {
+		conn.stats.update(pkt, ssrc, videoCodec.ClockRate)
+		switch pkt.(type) {
+		case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+			conn.videoSndr.pipeline.ForceKeyframe()
+			return
+		}
+		conn.prioritize(pkt, ssrc)
+	})
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	filesPath       = outputPath + "files/"
+	fileChanPrefix  = "file-"
+	fileChunkSize   = 16 * 1024
+	fileChunkHeader = 8
+	// Stop queueing chunks once this much data is waiting to be sent
+	fileMaxBuffered = 1024 * 1024
+	// Largest file accepted unless -max-file-size says otherwise
+	defaultMaxFileSize = 100 * 1024 * 1024
+	// Give up on a file the remote still finds damaged after this many
+	// rounds of chunks sent again
+	fileMaxResends = 5
+)
+
+type FileState int
+
+const (
+	FileSending FileState = iota
+	FileSent
+	FileVerified
+	FileFailed
+)
+
+// FileInfo describes a file being transferred, it is sent along with the
+// FileOffer control message
+type FileInfo struct {
+	ID   string
+	Name string
+	Size int64
+	// Hash is the hex encoded SHA-256 of the whole file
+	Hash string
+}
+
+// chunks returns the number of chunks the file is sent in. Empty files are
+// still sent as a single empty chunk
+func (info *FileInfo) chunks() uint32 {
+	if info.Size == 0 {
+		return 1
+	}
+	return uint32((info.Size + fileChunkSize - 1) / fileChunkSize)
+}
+
+// fileTransfer is either end of a file transfer. Each transfer gets its own
+// data channel, through which the chunks are sent prefixed with their index
+// and CRC32
+type fileTransfer struct {
+	info    FileInfo
+	state   FileState
+	file    *os.File
+	channel *webrtc.DataChannel
+	// Chunks that haven't been received, or had a bad checksum
+	missing map[uint32]bool
+	// The last chunk we expect to receive before checking the file
+	last    uint32
+	lowChan chan struct{}
+	// accepted is called when the other side accepts a file we offered
+	accepted func()
+	// resends counts the rounds of chunks the other side asked for again
+	resends int
+}
+
+func fileHash(f *os.File) (string, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// SendFile offers the file at path to remote and starts sending it
+func (peer *RTCPeer) SendFile(remote, path string) {
+	conn, ok := peer.Connections[remote]
+	if !ok {
+		log.Println("not connected to", remote)
+		return
+	}
+	if conn.state != InCall {
+		log.Println("but there was nobody listening...")
+		return
+	}
+	if !peer.requireCap(remote, CapFiles) {
+		return
+	}
+	if err := conn.offerFile(path, nil); err != nil {
+		logError("couldn't send", path, "to", conn, ":", err)
+	}
+}
+
+// offerFile tells the other side about the file at path, it is sent once
+// they accept it. accepted, if any, is called then
+func (conn *Connection) offerFile(path string, accepted func()) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	hash, err := fileHash(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	conn.fileCount++
+	xfer := &fileTransfer{
+		info: FileInfo{
+			ID:   fmt.Sprintf("%d", conn.fileCount),
+			Name: filepath.Base(path),
+			Size: stat.Size(),
+			Hash: hash,
+		},
+		state:    FileSending,
+		file:     f,
+		lowChan:  make(chan struct{}, 1),
+		accepted: accepted,
+	}
+	err = conn.sendControl(ControlMessage{Action: FileOffer, File: &xfer.info})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	conn.filesOut[xfer.info.ID] = xfer
+	log.Printf("offering %s (%d bytes) to %s\n", xfer.info.Name,
+		xfer.info.Size, conn)
+	return nil
+}
+
+// startFile opens the data channel of a file transfer once the other side
+// has accepted it, and sends all the chunks through it
+func (conn *Connection) startFile(xfer *fileTransfer) {
+	var err error
+	xfer.channel, err = conn.peer.CreateDataChannel(
+		fileChanPrefix+xfer.info.ID,
+		nil,
+	)
+	if err != nil {
+		logError("couldn't create file data channel:", err)
+		xfer.state = FileFailed
+		return
+	}
+	xfer.channel.SetBufferedAmountLowThreshold(fileMaxBuffered / 2)
+	xfer.channel.OnBufferedAmountLow(func() {
+		select {
+		case xfer.lowChan <- struct{}{}:
+		default:
+		}
+	})
+	xfer.channel.OnOpen(func() {
+		all := make([]uint32, xfer.info.chunks())
+		for i := range all {
+			all[i] = uint32(i)
+		}
+		conn.sendChunks(xfer, all)
+	})
+	log.Printf("sending %s to %s\n", xfer.info.Name, conn)
+}
+
+func (conn *Connection) sendChunks(xfer *fileTransfer, chunks []uint32) {
+	buf := make([]byte, fileChunkHeader+fileChunkSize)
+	for _, i := range chunks {
+		n, err := xfer.file.ReadAt(
+			buf[fileChunkHeader:],
+			int64(i)*fileChunkSize,
+		)
+		if err != nil && err != io.EOF {
+			logError("error reading", xfer.info.Name, ":", err)
+			xfer.state = FileFailed
+			return
+		}
+		binary.BigEndian.PutUint32(buf, i)
+		binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(
+			buf[fileChunkHeader:fileChunkHeader+n],
+		))
+		if xfer.channel.BufferedAmount() > fileMaxBuffered {
+			<-xfer.lowChan
+		}
+		err = conn.sendMessage(xfer.channel, MsgFileChunk,
+			buf[:fileChunkHeader+n])
+		if err != nil {
+			logError("error sending", xfer.info.Name, ":", err)
+			xfer.state = FileFailed
+			return
+		}
+	}
+	xfer.state = FileSent
+	log.Printf("%s sent to %s, waiting for confirmation\n", xfer.info.Name,
+		conn)
+}
+
+// handleFileOffer keeps the files offered by the remote until they are
+// accepted with /acceptfile, unless they are too big or files aren't taken
+func (conn *Connection) handleFileOffer(info *FileInfo) {
+	if info == nil || info.Size < 0 {
+		return
+	}
+	name := safeFileName(filepath.Base(info.Name))
+	if !conn.local.AcceptFiles ||
+		(conn.local.MaxFileSize > 0 && info.Size > conn.local.MaxFileSize) {
+		log.Printf("rejected %s (%d bytes) from %s\n", name, info.Size, conn)
+		conn.rejectFile(info.ID)
+		return
+	}
+	conn.fileOffers[info.ID] = info
+	log.Printf("%s offers you %s (%d bytes), /acceptfile %s %s to take it "+
+		"or /rejectfile %s %s\n", conn, name, info.Size,
+		conn.remoteAddr, info.ID, conn.remoteAddr, info.ID)
+	notify(eventMessage, "File from "+conn.local.displayName(conn.remoteAddr),
+		name)
+}
+
+func (conn *Connection) rejectFile(id string) {
+	err := conn.sendControl(ControlMessage{
+		Action: FileRejected,
+		File:   &FileInfo{ID: id},
+	})
+	if err != nil {
+		logError("couldn't reject file from", conn, ":", err)
+	}
+}
+
+// AnswerFile accepts or rejects the file with id offered by remote. id can
+// be left empty when there is only one waiting
+func (peer *RTCPeer) AnswerFile(remote, id string, accept bool) {
+	conn, ok := peer.Connections[remote]
+	if !ok {
+		log.Println("not connected to", remote)
+		return
+	}
+	if id == "" && len(conn.fileOffers) == 1 {
+		for id = range conn.fileOffers {
+		}
+	}
+	info, ok := conn.fileOffers[id]
+	if !ok {
+		log.Println("no such file offered by", conn)
+		return
+	}
+	delete(conn.fileOffers, id)
+	if !accept {
+		log.Println("rejected", info.Name, "from", conn)
+		conn.rejectFile(id)
+		return
+	}
+	conn.acceptFile(info)
+}
+
+// acceptFile creates the file the offer in info is saved to, under a name of
+// its own, and asks the remote to start sending it
+func (conn *Connection) acceptFile(info *FileInfo) {
+	if err := os.MkdirAll(filesPath, 0755); err != nil {
+		logError("couldn't create download directory:", err)
+		return
+	}
+	name := safeFileName(filepath.Base(info.Name))
+	ext := filepath.Ext(name)
+	f, err := createUnique(filesPath,
+		filepath.Join(filesPath, strings.TrimSuffix(name, ext)), ext)
+	if err != nil {
+		logError("couldn't create file:", err)
+		return
+	}
+	xfer := &fileTransfer{
+		info:    *info,
+		state:   FileSending,
+		file:    f,
+		missing: make(map[uint32]bool),
+		last:    info.chunks() - 1,
+	}
+	for i := uint32(0); i < info.chunks(); i++ {
+		xfer.missing[i] = true
+	}
+	conn.filesIn[info.ID] = xfer
+	log.Printf("%s is sending you %s (%d bytes) to %s\n", conn, name,
+		info.Size, f.Name())
+	err = conn.sendControl(ControlMessage{
+		Action: FileAccept,
+		File:   &FileInfo{ID: info.ID},
+	})
+	if err != nil {
+		logError("couldn't accept file from", conn, ":", err)
+	}
+}
+
+func (conn *Connection) handleFileChannel(d *webrtc.DataChannel) {
+	id := strings.TrimPrefix(d.Label(), fileChanPrefix)
+	xfer, ok := conn.filesIn[id]
+	if !ok {
+		log.Println(conn, "opened a file channel without offering a file")
+		d.Close()
+		return
+	}
+	xfer.channel = d
+	d.OnMessage(func(msg webrtc.DataChannelMessage) {
+		t, payload := conn.openEnvelope(msg, MsgFileChunk)
+		if t != MsgFileChunk {
+			logDebug(fmt.Sprintf("ignored %s message (%d bytes) on the channel of %s",
+				t, len(payload), xfer.info.Name))
+			return
+		}
+		conn.handleFileChunk(xfer, payload)
+	})
+}
+
+func (conn *Connection) handleFileChunk(xfer *fileTransfer, data []byte) {
+	if len(data) < fileChunkHeader {
+		return
+	}
+	i := binary.BigEndian.Uint32(data)
+	sum := binary.BigEndian.Uint32(data[4:])
+	chunk := data[fileChunkHeader:]
+	// Nothing may be written past the size that was offered and accepted
+	if i > xfer.last || len(chunk) > fileChunkSize ||
+		int64(i)*fileChunkSize+int64(len(chunk)) > xfer.info.Size {
+		return
+	}
+	if crc32.ChecksumIEEE(chunk) == sum {
+		_, err := xfer.file.WriteAt(chunk, int64(i)*fileChunkSize)
+		if err != nil {
+			logError("error writing", xfer.info.Name, ":", err)
+			return
+		}
+		delete(xfer.missing, i)
+	}
+
+	if i == xfer.last || len(xfer.missing) == 0 {
+		conn.verifyFile(xfer)
+	}
+}
+
+// verifyFile checks whether the file was received completely and intact,
+// and tells the sender. If it wasn't, the missing chunks are requested again
+func (conn *Connection) verifyFile(xfer *fileTransfer) {
+	reply := ControlMessage{Action: FileResend, File: &FileInfo{
+		ID: xfer.info.ID,
+	}}
+	if len(xfer.missing) == 0 {
+		hash, err := fileHash(xfer.file)
+		if err != nil {
+			logError("couldn't hash", xfer.info.Name, ":", err)
+		}
+		if err == nil && hash == xfer.info.Hash {
+			reply.Action = FileReceived
+		} else {
+			for i := uint32(0); i < xfer.info.chunks(); i++ {
+				xfer.missing[i] = true
+			}
+		}
+	}
+
+	if reply.Action == FileReceived {
+		xfer.state = FileVerified
+		xfer.file.Close()
+		delete(conn.filesIn, xfer.info.ID)
+		log.Printf("received %s from %s\n", xfer.info.Name, conn)
+	} else {
+		for i := range xfer.missing {
+			reply.Chunks = append(reply.Chunks, i)
+		}
+		// The sender sends them in order, so we know which one comes last
+		sort.Slice(reply.Chunks, func(a, b int) bool {
+			return reply.Chunks[a] < reply.Chunks[b]
+		})
+		xfer.last = reply.Chunks[len(reply.Chunks)-1]
+		log.Printf("%s from %s is damaged, requesting %d chunks again\n",
+			xfer.info.Name, conn, len(reply.Chunks))
+	}
+	if err := conn.sendControl(reply); err != nil {
+		logError("couldn't reply to", conn, ":", err)
+	}
+}
+
+func (conn *Connection) handleFileReply(msg ControlMessage) {
+	if msg.File == nil {
+		return
+	}
+	xfer, ok := conn.filesOut[msg.File.ID]
+	if !ok {
+		return
+	}
+	switch msg.Action {
+	case FileAccept:
+		if xfer.channel != nil || xfer.state != FileSending {
+			return
+		}
+		if xfer.accepted != nil {
+			xfer.accepted()
+		}
+		conn.startFile(xfer)
+		return
+	case FileRejected:
+		xfer.state = FileFailed
+		xfer.file.Close()
+		log.Println(conn, "rejected", xfer.info.Name)
+		return
+	}
+	// Only files being sent can be received or sent again
+	if xfer.channel == nil || xfer.state == FileFailed ||
+		xfer.state == FileVerified {
+		return
+	}
+	if msg.Action == FileResend {
+		xfer.resends++
+		if xfer.resends > fileMaxResends {
+			xfer.state = FileFailed
+			xfer.file.Close()
+			xfer.channel.Close()
+			logError(fmt.Sprintf("%s still arrives damaged at %s after %d "+
+				"tries, giving up", xfer.info.Name, conn, fileMaxResends))
+			return
+		}
+		go conn.sendChunks(xfer, msg.Chunks)
+		return
+	}
+	xfer.state = FileVerified
+	xfer.file.Close()
+	xfer.channel.Close()
+	log.Printf("%s delivered to %s and verified\n", xfer.info.Name, conn)
+}
+
+func (conn *Connection) closeFiles() {
+	for _, xfer := range conn.filesOut {
+		xfer.file.Close()
+		if xfer.channel != nil {
+			xfer.channel.Close()
+		}
+	}
+	for _, xfer := range conn.filesIn {
+		xfer.file.Close()
+	}
+}
+
+// Files logs the state of the file transfers with remote
+func (peer *RTCPeer) Files(remote string) {
+	conn, ok := peer.Connections[remote]
+	if !ok {
+		log.Println("not connected to", remote)
+		return
+	}
+	states := map[FileState]string{
+		FileSending:  "sending",
+		FileSent:     "sent",
+		FileVerified: "delivered and verified",
+		FileFailed:   "failed",
+	}
+	var b bytes.Buffer
+	for _, xfer := range conn.filesOut {
+		fmt.Fprintf(&b, "\n  %s: %s", xfer.info.Name, states[xfer.state])
+	}
+	for _, xfer := range conn.filesIn {
+		fmt.Fprintf(&b, "\n  %s: receiving, %d chunks left",
+			xfer.info.Name, len(xfer.missing))
+	}
+	for id, info := range conn.fileOffers {
+		fmt.Fprintf(&b, "\n  %s: offered, /acceptfile %s %s to take it",
+			info.Name, conn.remoteAddr, id)
+	}
+	if b.Len() == 0 {
+		log.Println("no file transfers in progress with", conn)
+		return
+	}
+	log.Printf("file transfers with %s:%s\n", conn, b.String())
+}
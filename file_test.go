@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func chunkMessage(i uint32, data []byte, sum uint32) []byte {
+	msg := make([]byte, fileChunkHeader+len(data))
+	binary.BigEndian.PutUint32(msg, i)
+	binary.BigEndian.PutUint32(msg[4:], sum)
+	copy(msg[fileChunkHeader:], data)
+	return msg
+}
+
+func goodChunk(i uint32, data []byte) []byte {
+	return chunkMessage(i, data, crc32.ChecksumIEEE(data))
+}
+
+func TestHandleFileChunk(t *testing.T) {
+	// Two chunks, the second of them 10 bytes long
+	size := int64(fileChunkSize + 10)
+	first := bytes.Repeat([]byte{'a'}, fileChunkSize)
+	tests := []struct {
+		name string
+		data []byte
+		// missing are the chunks still missing after data
+		missing []uint32
+		// written is what the file starts with after data
+		written []byte
+	}{
+		{
+			name:    "short header",
+			data:    []byte{0, 0, 0, 0},
+			missing: []uint32{0, 1},
+		},
+		{
+			name:    "first chunk",
+			data:    goodChunk(0, first),
+			missing: []uint32{1},
+			written: first,
+		},
+		{
+			name:    "bad checksum",
+			data:    chunkMessage(0, first, 1),
+			missing: []uint32{0, 1},
+		},
+		{
+			name:    "past the last chunk",
+			data:    goodChunk(2, []byte("x")),
+			missing: []uint32{0, 1},
+		},
+		{
+			name:    "far past the last chunk",
+			data:    goodChunk(0xffffffff, []byte("x")),
+			missing: []uint32{0, 1},
+		},
+		{
+			name:    "past the size offered",
+			data:    goodChunk(1, bytes.Repeat([]byte{'b'}, 11)),
+			missing: []uint32{0, 1},
+		},
+		{
+			name:    "longer than a chunk",
+			data:    goodChunk(0, append(first, 'a')),
+			missing: []uint32{0, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Create(filepath.Join(t.TempDir(), "file"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			xfer := &fileTransfer{
+				info:    FileInfo{ID: "1", Name: "file", Size: size},
+				file:    f,
+				missing: map[uint32]bool{0: true, 1: true},
+				last:    1,
+			}
+			conn := testConn(t, nil)
+			conn.handleFileChunk(xfer, tt.data)
+
+			if len(xfer.missing) != len(tt.missing) {
+				t.Errorf("missing %v, want %v", xfer.missing, tt.missing)
+			}
+			for _, i := range tt.missing {
+				if !xfer.missing[i] {
+					t.Errorf("chunk %d isn't missing", i)
+				}
+			}
+			got, err := os.ReadFile(f.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.written) {
+				t.Errorf("wrote %d bytes, want %d", len(got), len(tt.written))
+			}
+		})
+	}
+}
+
+func TestFileInfoChunks(t *testing.T) {
+	tests := []struct {
+		size int64
+		want uint32
+	}{
+		{0, 1},
+		{1, 1},
+		{fileChunkSize, 1},
+		{fileChunkSize + 1, 2},
+		{3 * fileChunkSize, 3},
+	}
+	for _, tt := range tests {
+		info := FileInfo{Size: tt.size}
+		if got := info.chunks(); got != tt.want {
+			t.Errorf("chunks of %d bytes = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestAcceptFileVerifies(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	content := bytes.Repeat([]byte("wrtcion "), fileChunkSize/4)
+	sum := sha256.Sum256(content)
+	info := &FileInfo{
+		ID:   "1",
+		Name: "notes.txt",
+		Size: int64(len(content)),
+		Hash: hex.EncodeToString(sum[:]),
+	}
+	conn := testConn(t, nil)
+	conn.filesIn = make(map[string]*fileTransfer)
+	conn.acceptFile(info)
+	xfer, ok := conn.filesIn[info.ID]
+	if !ok {
+		t.Fatal("the file wasn't accepted")
+	}
+	for i := uint32(0); i < info.chunks(); i++ {
+		end := int(i+1) * fileChunkSize
+		if end > len(content) {
+			end = len(content)
+		}
+		conn.handleFileChunk(xfer, goodChunk(i, content[int(i)*fileChunkSize:end]))
+	}
+	if xfer.state != FileVerified {
+		t.Errorf("state %d, want verified; missing %v", xfer.state, xfer.missing)
+	}
+	if _, ok := conn.filesIn[info.ID]; ok {
+		t.Error("transfer still in progress")
+	}
+	got, err := os.ReadFile(filepath.Join(filesPath, info.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("received file differs")
+	}
+}
+
+func TestHandleFileReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		action  ControlAction
+		started bool
+		resends int
+		want    FileState
+	}{
+		{name: "received before accepted", action: FileReceived, want: FileSending},
+		{name: "resend before accepted", action: FileResend, want: FileSending},
+		{name: "received", action: FileReceived, started: true, want: FileVerified},
+		{
+			name:    "resent too many times",
+			action:  FileResend,
+			started: true,
+			resends: fileMaxResends,
+			want:    FileFailed,
+		},
+		{name: "rejected", action: FileRejected, want: FileFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Create(filepath.Join(t.TempDir(), "file"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			xfer := &fileTransfer{
+				info:    FileInfo{ID: "1", Name: "file"},
+				state:   FileSending,
+				file:    f,
+				resends: tt.resends,
+			}
+			if tt.started {
+				xfer.channel = &webrtc.DataChannel{}
+			}
+			conn := testConn(t, nil)
+			conn.filesOut = map[string]*fileTransfer{"1": xfer}
+			conn.handleFileReply(ControlMessage{
+				Action: tt.action,
+				File:   &FileInfo{ID: "1"},
+			})
+			if xfer.state != tt.want {
+				t.Errorf("state %d, want %d", xfer.state, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+
+	pb "github.com/Yaroslav-95/wrtcion/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var pbStates = map[ConnectionState]pb.State{
+	Standby:   pb.State_STANDBY,
+	Ringing:   pb.State_RINGING,
+	Answering: pb.State_ANSWERING,
+	InCall:    pb.State_IN_CALL,
+	Closed:    pb.State_CLOSED,
+}
+
+var pbModes = map[ConnectionMode]pb.Mode{
+	TextConnection:         pb.Mode_CHAT,
+	VoiceConnectionSimplex: pb.Mode_CALL,
+	VoiceConnectionDuplex:  pb.Mode_CALL,
+	VideoConnectionSimplex: pb.Mode_VIDEO,
+}
+
+// Codes the errors of the control actions are answered with, by HTTP status
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest: codes.InvalidArgument,
+	http.StatusNotFound:   codes.NotFound,
+	http.StatusConflict:   codes.FailedPrecondition,
+	http.StatusBadGateway: codes.Unavailable,
+}
+
+// eventFeed sends the call state changes and the incoming messages to the
+// clients of the Events RPC. Like eventHub, events are dropped for clients
+// that fall eventBacklog behind
+type eventFeed struct {
+	mu   sync.Mutex
+	subs map[chan *pb.Event]bool
+}
+
+// peerEvents is fed by the connections whether anybody follows it or not
+var peerEvents = &eventFeed{subs: make(map[chan *pb.Event]bool)}
+
+func (f *eventFeed) subscribe() chan *pb.Event {
+	sub := make(chan *pb.Event, eventBacklog)
+	f.mu.Lock()
+	f.subs[sub] = true
+	f.mu.Unlock()
+	return sub
+}
+
+func (f *eventFeed) unsubscribe(sub chan *pb.Event) {
+	f.mu.Lock()
+	delete(f.subs, sub)
+	f.mu.Unlock()
+}
+
+// publish sends the event made by ev to every subscriber, ev is only called
+// if there is one
+func (f *eventFeed) publish(ev func() *pb.Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.subs) == 0 {
+		return
+	}
+	event := ev()
+	event.Time = timestamppb.Now()
+	for sub := range f.subs {
+		select {
+		case sub <- event:
+		default:
+		}
+	}
+}
+
+func (f *eventFeed) stateChanged(conn *Connection) {
+	f.publish(func() *pb.Event {
+		return &pb.Event{Event: &pb.Event_State{State: &pb.StateChange{
+			Connection: pbConnection(connectionInfo(conn.local, conn), conn),
+		}}}
+	})
+}
+
+func (f *eventFeed) messageReceived(conn *Connection, msg ChatMessage) {
+	f.publish(func() *pb.Event {
+		return &pb.Event{Event: &pb.Event_Message{Message: &pb.Message{
+			Remote: conn.remoteAddr,
+			Text:   msg.text(),
+		}}}
+	})
+}
+
+func pbConnection(info ConnectionInfo, conn *Connection) *pb.Connection {
+	return &pb.Connection{
+		Remote:   info.Remote,
+		Name:     info.Name,
+		State:    pbStates[conn.state],
+		Mode:     pbModes[conn.mode],
+		Outgoing: info.Outgoing,
+		Muted:    info.Muted,
+		Duration: durationpb.New(info.Duration),
+	}
+}
+
+// grpcStatus turns the error of a control action into a gRPC status
+func grpcStatus(err error) error {
+	var e apiError
+	if errors.As(err, &e) {
+		if code, ok := grpcCodes[e.status]; ok {
+			return status.Error(code, e.Error())
+		}
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// grpcServer is the control API as a gRPC service. It shares the token and
+// the lock of the control server, commands from both are run one at a time
+type grpcServer struct {
+	pb.UnimplementedPeerServer
+	ctl *controlServer
+}
+
+func (g *grpcServer) authorize(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		if g.ctl.validToken(auth) {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, errUnauthorized.Error())
+}
+
+func (g *grpcServer) unaryAuth(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if err := g.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (g *grpcServer) streamAuth(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if err := g.authorize(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// do runs one of the control actions with the lock of the control server
+func (g *grpcServer) do(action func() error) (*pb.Empty, error) {
+	g.ctl.mu.Lock()
+	err := action()
+	g.ctl.mu.Unlock()
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	return &pb.Empty{}, nil
+}
+
+func (g *grpcServer) ListConnections(
+	ctx context.Context,
+	req *pb.ListConnectionsRequest,
+) (*pb.ListConnectionsResponse, error) {
+	g.ctl.mu.Lock()
+	defer g.ctl.mu.Unlock()
+	resp := &pb.ListConnectionsResponse{}
+	for _, info := range g.ctl.connections() {
+		conn := g.ctl.rtcpeer.Connections[info.Remote]
+		resp.Connections = append(resp.Connections, pbConnection(info, conn))
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) Call(ctx context.Context, req *pb.CallRequest) (*pb.Connection, error) {
+	mode := VoiceConnectionSimplex
+	switch req.Mode {
+	case pb.Mode_VIDEO:
+		mode = VideoConnectionSimplex
+	case pb.Mode_CHAT:
+		mode = TextConnection
+	}
+	g.ctl.mu.Lock()
+	defer g.ctl.mu.Unlock()
+	conn, err := g.ctl.call(req.Remote, mode)
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	return pbConnection(connectionInfo(g.ctl.rtcpeer, conn), conn), nil
+}
+
+func (g *grpcServer) Accept(ctx context.Context, req *pb.PeerRequest) (*pb.Empty, error) {
+	return g.do(func() error { return g.ctl.accept(req.Remote) })
+}
+
+func (g *grpcServer) Reject(ctx context.Context, req *pb.PeerRequest) (*pb.Empty, error) {
+	return g.do(func() error { return g.ctl.reject(req.Remote) })
+}
+
+func (g *grpcServer) HangUp(ctx context.Context, req *pb.PeerRequest) (*pb.Empty, error) {
+	return g.do(func() error { return g.ctl.hangUp(req.Remote) })
+}
+
+func (g *grpcServer) SendMessage(ctx context.Context, req *pb.MessageRequest) (*pb.Empty, error) {
+	return g.do(func() error { return g.ctl.sendMessage(req.Remote, req.Text) })
+}
+
+func (g *grpcServer) GetStats(ctx context.Context, req *pb.PeerRequest) (*pb.Stats, error) {
+	g.ctl.mu.Lock()
+	conn, err := g.ctl.connection(req.Remote)
+	g.ctl.mu.Unlock()
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	report, err := json.Marshal(conn.peer.GetStats())
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	quality := conn.Stats()
+	stats := &pb.Stats{
+		Remote:       conn.remoteAddr,
+		FractionLost: quality.FractionLost,
+		Jitter:       durationpb.New(quality.Jitter),
+		Rtt:          durationpb.New(quality.RTT),
+		Report:       string(report),
+	}
+	if !quality.Updated.IsZero() {
+		stats.Updated = timestamppb.New(quality.Updated)
+	}
+	return stats, nil
+}
+
+// Events streams the state changes of the calls and the messages received
+// until the client goes away or the peer exits
+func (g *grpcServer) Events(req *pb.EventsRequest, stream pb.Peer_EventsServer) error {
+	sub := peerEvents.subscribe()
+	defer peerEvents.unsubscribe(sub)
+	for {
+		select {
+		case event := <-sub:
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		case <-g.ctl.quit:
+			return nil
+		}
+	}
+}
+
+// serveGRPC serves the gRPC service at addr, next to the control API
+func (ctl *controlServer) serveGRPC(addr string) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	g := &grpcServer{ctl: ctl}
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(g.unaryAuth),
+		grpc.StreamInterceptor(g.streamAuth),
+	)
+	pb.RegisterPeerServer(srv, g)
+	log.Println("grpc api listening at", addr)
+	log.Fatal(srv.Serve(lis))
+}
@@ -0,0 +1,135 @@
+package gst
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AudioBackend is the sound server audio is captured from and played to
+type AudioBackend string
+
+const (
+	// BackendAuto lets GStreamer pick the elements, which usually ends up
+	// going through PulseAudio
+	BackendAuto AudioBackend = "auto"
+	// BackendPipeWire talks to PipeWire directly
+	BackendPipeWire AudioBackend = "pipewire"
+	// BackendJACK exposes the microphone and the calls as JACK ports
+	BackendJACK AudioBackend = "jack"
+)
+
+// The nodes created by PipeWire's libpipewire-module-echo-cancel with its
+// default configuration
+const (
+	echoCancelSource = "echo-cancel-source"
+	echoCancelSink   = "echo-cancel-sink"
+)
+
+// AudioOptions configure the audio backend
+type AudioOptions struct {
+	Backend AudioBackend
+	// Source and Sink are the names of the PipeWire nodes to capture from
+	// and play to, empty for the default ones
+	Source string
+	Sink   string
+	// EchoCancel captures from and plays to the nodes of PipeWire's echo
+	// cancel module, unless Source or Sink are given
+	EchoCancel bool
+	// JACKConnect connects our JACK ports to the physical ones, otherwise
+	// they are left for the user to route
+	JACKConnect bool
+}
+
+// JACKStatus is the state of the JACK server
+type JACKStatus struct {
+	SampleRate int
+	Xruns      int
+}
+
+var audioOpts = AudioOptions{Backend: BackendAuto}
+
+// ParseAudioBackend returns the backend called name
+func ParseAudioBackend(name string) (AudioBackend, error) {
+	switch b := AudioBackend(strings.ToLower(name)); b {
+	case BackendAuto, BackendPipeWire, BackendJACK:
+		return b, nil
+	}
+	return "", fmt.Errorf("unknown audio backend %s", name)
+}
+
+// SetAudioOptions sets the backend used by the pipelines created from now on
+func SetAudioOptions(opts AudioOptions) {
+	audioOpts = opts
+}
+
+// pipewireElement describes a PipeWire source or sink element for node.
+// Every stream gets its own name, so that its volume can be set apart from
+// the others in PipeWire's mixers
+func pipewireElement(element, node, stream string) string {
+	str := fmt.Sprintf(
+		"%s client-name=wrtcion stream-properties=\"props,media.name=%s,media.role=Communication\"",
+		element,
+		stream,
+	)
+	if node != "" {
+		str += fmt.Sprintf(" target-object=\"%s\"", node)
+	}
+	return str
+}
+
+// jackElement describes a JACK source or sink element, whose ports are
+// named after stream
+func jackElement(element, stream string) string {
+	connect := "none"
+	if audioOpts.JACKConnect {
+		connect = "auto"
+	}
+	return fmt.Sprintf("%s client-name=wrtcion-%s connect=%s",
+		element, stream, connect)
+}
+
+// audioSource describes the element audio is captured with when no device
+// is chosen. Music skips the echo canceller
+func audioSource(music bool) string {
+	switch audioOpts.Backend {
+	case BackendPipeWire:
+		source := audioOpts.Source
+		if source == "" && audioOpts.EchoCancel && !music {
+			source = echoCancelSource
+		}
+		return pipewireElement("pipewiresrc", source, "microphone")
+	case BackendJACK:
+		// The send pipelines resample to 48kHz whatever rate JACK runs at
+		return jackElement("jackaudiosrc", "microphone")
+	}
+	return "autoaudiosrc"
+}
+
+// audioSink describes the element the audio of stream is played with
+func audioSink(stream string) string {
+	switch audioOpts.Backend {
+	case BackendPipeWire:
+		sink := audioOpts.Sink
+		if sink == "" && audioOpts.EchoCancel {
+			sink = echoCancelSink
+		}
+		return pipewireElement("pipewiresink", sink, stream)
+	case BackendJACK:
+		// Opus is always decoded at 48kHz, JACK may run at another rate
+		return "audioresample ! " + jackElement("jackaudiosink", stream)
+	}
+	return "autoaudiosink"
+}
+
+// deviceSink describes the element that plays audio to the sink named
+// device of the sound server
+func deviceSink(device, stream string) string {
+	switch audioOpts.Backend {
+	case BackendPipeWire:
+		return pipewireElement("pipewiresink", device, stream)
+	case BackendJACK:
+		// JACK has no sinks to play to, the ports are named after device
+		return "audioresample ! " + jackElement("jackaudiosink", device)
+	}
+	return fmt.Sprintf("pulsesink device=\"%s\"", device)
+}
@@ -48,6 +48,90 @@ gstreamer_create_pipeline(char *pipeline)
 	return gst_parse_launch(pipeline, &error);
 }
 
+/* Sets the location of the element named file, a filesrc or a filesink.
+ * Returns 0 if the pipeline has none */
+int
+gstreamer_set_location(GstElement *pipeline, const char *location)
+{
+	GstElement *file = gst_bin_get_by_name(GST_BIN(pipeline), "file");
+	if (file == NULL)
+		return 0;
+	g_object_set(file, "location", location, NULL);
+	gst_object_unref(file);
+	return 1;
+}
+
+/* Devices */
+
+static GList *
+gstreamer_get_devices(const char *klass)
+{
+	GstDeviceMonitor *monitor;
+	GList *devices;
+
+	gst_init(NULL, NULL);
+	monitor = gst_device_monitor_new();
+	gst_device_monitor_add_filter(monitor, klass, NULL);
+	devices = gst_device_monitor_get_devices(monitor);
+	gst_object_unref(monitor);
+
+	return devices;
+}
+
+char *
+gstreamer_list_devices(const char *klass)
+{
+	GList *devices, *l;
+	GString *names = g_string_new(NULL);
+
+	devices = gstreamer_get_devices(klass);
+	for (l = devices; l != NULL; l = l->next) {
+		gchar *name = gst_device_get_display_name(GST_DEVICE(l->data));
+		g_string_append(names, name);
+		g_string_append_c(names, '\n');
+		g_free(name);
+	}
+	g_list_free_full(devices, gst_object_unref);
+
+	return g_string_free(names, FALSE);
+}
+
+GstElement *
+gstreamer_create_device_pipeline(const char *klass, const char *name,
+	char *rest)
+{
+	GList *devices, *l;
+	GstElement *src = NULL, *bin, *pipeline;
+	GError *error = NULL;
+
+	devices = gstreamer_get_devices(klass);
+	for (l = devices; l != NULL && src == NULL; l = l->next) {
+		gchar *dname = gst_device_get_display_name(GST_DEVICE(l->data));
+		if (g_strcmp0(dname, name) == 0) {
+			src = gst_device_create_element(GST_DEVICE(l->data), "src");
+		}
+		g_free(dname);
+	}
+	g_list_free_full(devices, gst_object_unref);
+	if (src == NULL) {
+		return NULL;
+	}
+
+	bin = gst_parse_bin_from_description(rest, TRUE, &error);
+	if (bin == NULL) {
+		g_printerr("Error: %s\n", error->message);
+		g_error_free(error);
+		gst_object_unref(src);
+		return NULL;
+	}
+
+	pipeline = gst_pipeline_new(NULL);
+	gst_bin_add_many(GST_BIN(pipeline), src, bin, NULL);
+	gst_element_link(src, bin);
+
+	return pipeline;
+}
+
 /* Receive */
 
 void
@@ -69,7 +153,131 @@ gstreamer_receive_stop_pipeline(GstElement *pipeline)
 void
 gstreamer_receive_push_buffer(GstElement *pipeline, void *buffer, int len)
 {
-	GstElement *src = gst_bin_get_by_name(GST_BIN(pipeline), "src");
+	gstreamer_push_buffer_to(pipeline, "src", buffer, len);
+}
+
+
+void
+gstreamer_receive_set_volume(GstElement *pipeline, double volume)
+{
+	GstElement *vol = gst_bin_get_by_name(GST_BIN(pipeline), "volume");
+	if (vol != NULL) {
+		g_object_set(vol, "volume", volume, NULL);
+		gst_object_unref(vol);
+	}
+}
+
+void
+gstreamer_receive_get_jitter_stats(GstElement *pipeline, guint64 *pushed,
+	guint64 *lost)
+{
+	GstElement *jitter = gst_bin_get_by_name(GST_BIN(pipeline), "jitter");
+	GstStructure *stats = NULL;
+
+	*pushed = *lost = 0;
+	if (jitter == NULL)
+		return;
+	g_object_get(jitter, "stats", &stats, NULL);
+	if (stats != NULL) {
+		gst_structure_get_uint64(stats, "num-pushed", pushed);
+		gst_structure_get_uint64(stats, "num-lost", lost);
+		gst_structure_free(stats);
+	}
+	gst_object_unref(jitter);
+}
+
+void
+gstreamer_receive_set_latency(GstElement *pipeline, int latency)
+{
+	GstElement *jitter = gst_bin_get_by_name(GST_BIN(pipeline), "jitter");
+	if (jitter != NULL) {
+		g_object_set(jitter, "latency", latency, NULL);
+		gst_object_unref(jitter);
+	}
+}
+
+/* Send */
+
+static GstFlowReturn
+gstreamer_send_new_sample_handler(GstElement *object, gpointer data)
+{
+	GstSample *sample = NULL;
+	GstBuffer *buffer = NULL;
+	gpointer copy = NULL;
+	gsize copy_size = 0;
+	int id = GPOINTER_TO_INT(data);
+
+	g_signal_emit_by_name(object, "pull-sample", &sample);
+	if (sample != NULL) {
+		buffer = gst_sample_get_buffer(sample);
+		if (buffer != NULL) {
+			gst_buffer_extract_dup(buffer, 0, gst_buffer_get_size(buffer),
+				&copy, &copy_size);
+			goHandlePipelineBuffer(copy, copy_size,
+				GST_BUFFER_DURATION(buffer), id);
+		}
+		gst_sample_unref(sample);
+	}
+
+	return GST_FLOW_OK;
+}
+
+void
+gstreamer_send_start_pipeline(GstElement *pipeline, int id)
+{
+	GstBus *bus = gst_pipeline_get_bus(GST_PIPELINE(pipeline));
+	gst_bus_add_watch(bus, gstreamer_bus_call, NULL);
+	gst_object_unref(bus);
+
+	GstElement *sink = gst_bin_get_by_name(GST_BIN(pipeline), "sink");
+	g_object_set(sink, "emit-signals", TRUE, NULL);
+	g_signal_connect(sink, "new-sample",
+		G_CALLBACK(gstreamer_send_new_sample_handler), GINT_TO_POINTER(id));
+	gst_object_unref(sink);
+
+	gst_element_set_state(pipeline, GST_STATE_PLAYING);
+}
+
+void
+gstreamer_send_stop_pipeline(GstElement *pipeline)
+{
+	gst_element_set_state(pipeline, GST_STATE_NULL);
+}
+
+void
+gstreamer_send_set_bitrate(GstElement *pipeline, const char *property,
+	int bitrate)
+{
+	GstElement *encoder = gst_bin_get_by_name(GST_BIN(pipeline), "encoder");
+	if (encoder != NULL) {
+		g_object_set(encoder, property, bitrate, NULL);
+		gst_object_unref(encoder);
+	}
+}
+
+void
+gstreamer_send_force_keyframe(GstElement *pipeline)
+{
+	GstElement *encoder = gst_bin_get_by_name(GST_BIN(pipeline), "encoder");
+	if (encoder == NULL) {
+		return;
+	}
+	/* Sent to the source pad, the event travels upstream into the encoder */
+	GstPad *pad = gst_element_get_static_pad(encoder, "src");
+	gst_pad_send_event(pad,
+		gst_video_event_new_upstream_force_key_unit(GST_CLOCK_TIME_NONE,
+			TRUE, 0));
+	gst_object_unref(pad);
+	gst_object_unref(encoder);
+}
+
+/* Record */
+
+void
+gstreamer_push_buffer_to(GstElement *pipeline, const char *name,
+	void *buffer, int len)
+{
+	GstElement *src = gst_bin_get_by_name(GST_BIN(pipeline), name);
 	if (src != NULL) {
 		/* Used to use g_memdup */
 		gpointer p = g_memdup2(buffer, len);
@@ -79,3 +287,142 @@ gstreamer_receive_push_buffer(GstElement *pipeline, void *buffer, int len)
 	}
 }
 
+void
+gstreamer_recorder_start(GstElement *pipeline)
+{
+	/* No bus watch, the default one quits on EOS and that is exactly how a
+	 * recording ends */
+	gst_element_set_state(pipeline, GST_STATE_PLAYING);
+}
+
+void
+gstreamer_recorder_stop(GstElement *pipeline)
+{
+	const char *names[] = { "audio", "video" };
+	GstBus *bus;
+	GstMessage *msg;
+
+	for (int i = 0; i < 2; i++) {
+		GstElement *src = gst_bin_get_by_name(GST_BIN(pipeline), names[i]);
+		if (src != NULL) {
+			gst_app_src_end_of_stream(GST_APP_SRC(src));
+			gst_object_unref(src);
+		}
+	}
+
+	/* Wait for the muxer to write everything out */
+	bus = gst_pipeline_get_bus(GST_PIPELINE(pipeline));
+	msg = gst_bus_timed_pop_filtered(bus, 5 * GST_SECOND,
+		GST_MESSAGE_EOS | GST_MESSAGE_ERROR);
+	if (msg != NULL) {
+		gst_message_unref(msg);
+	}
+	gst_object_unref(bus);
+
+	gst_element_set_state(pipeline, GST_STATE_NULL);
+}
+
+/* Mix */
+
+void
+gstreamer_mixer_add(GstElement *mixer, const char *name, const char *desc)
+{
+	GstElement *mix, *bin;
+	GError *error = NULL;
+
+	bin = gst_parse_bin_from_description(desc, TRUE, &error);
+	if (bin == NULL) {
+		g_printerr("Error: %s\n", error->message);
+		g_error_free(error);
+		return;
+	}
+	/* Named so that it can be found to remove it */
+	gst_element_set_name(bin, name);
+
+	mix = gst_bin_get_by_name(GST_BIN(mixer), "mix");
+	gst_bin_add(GST_BIN(mixer), bin);
+	gst_element_link(bin, mix);
+	gst_element_sync_state_with_parent(bin);
+	gst_object_unref(mix);
+}
+
+void
+gstreamer_mixer_remove(GstElement *mixer, const char *name)
+{
+	GstElement *mix, *bin;
+	GstPad *src, *sink;
+
+	bin = gst_bin_get_by_name(GST_BIN(mixer), name);
+	if (bin == NULL) {
+		return;
+	}
+	mix = gst_bin_get_by_name(GST_BIN(mixer), "mix");
+	src = gst_element_get_static_pad(bin, "src");
+	sink = gst_pad_get_peer(src);
+
+	gst_element_set_state(bin, GST_STATE_NULL);
+	if (sink != NULL) {
+		gst_pad_unlink(src, sink);
+		gst_element_release_request_pad(mix, sink);
+		gst_object_unref(sink);
+	}
+	gst_object_unref(src);
+	gst_bin_remove(GST_BIN(mixer), bin);
+
+	gst_object_unref(bin);
+	gst_object_unref(mix);
+}
+
+/* Play */
+
+static gboolean
+gstreamer_player_bus_call(GstBus *bus, GstMessage *msg, gpointer data)
+{
+	GstElement *pipeline = GST_ELEMENT(data);
+
+	switch (GST_MESSAGE_TYPE(msg)) {
+	case GST_MESSAGE_EOS:
+		/* Loop */
+		gst_element_seek_simple(pipeline, GST_FORMAT_TIME,
+			GST_SEEK_FLAG_FLUSH | GST_SEEK_FLAG_KEY_UNIT, 0);
+		break;
+
+	case GST_MESSAGE_ERROR:;
+		gchar *debug;
+		GError *error;
+
+		gst_message_parse_error(msg, &error, &debug);
+		g_free(debug);
+
+		/* Not worth quitting over a ringtone */
+		g_printerr("Error: %s\n", error->message);
+		g_error_free(error);
+		gst_element_set_state(pipeline, GST_STATE_NULL);
+		return FALSE;
+
+	default:
+		break;
+	}
+
+	return TRUE;
+}
+
+void
+gstreamer_player_start(GstElement *pipeline)
+{
+	GstBus *bus = gst_pipeline_get_bus(GST_PIPELINE(pipeline));
+	gst_bus_add_watch(bus, gstreamer_player_bus_call, pipeline);
+	gst_object_unref(bus);
+
+	gst_element_set_state(pipeline, GST_STATE_PLAYING);
+}
+
+void
+gstreamer_player_stop(GstElement *pipeline)
+{
+	GstBus *bus = gst_pipeline_get_bus(GST_PIPELINE(pipeline));
+	gst_bus_remove_watch(bus);
+	gst_object_unref(bus);
+
+	gst_element_set_state(pipeline, GST_STATE_NULL);
+}
@@ -1,15 +1,18 @@
 package gst
 
 /*
-#cgo pkg-config: gstreamer-1.0 gstreamer-app-1.0
+#cgo pkg-config: gstreamer-1.0 gstreamer-app-1.0 gstreamer-video-1.0
 
 #include "gst.h"
 
 */
 import "C"
 import (
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 	"unsafe"
 
 	"github.com/pion/webrtc/v3"
@@ -23,25 +26,84 @@ func StartMainLoop() {
 	C.gstreamer_start_mainloop()
 }
 
+// Version returns the version of the GStreamer library in use
+func Version() string {
+	v := C.gst_version_string()
+	defer C.g_free(C.gpointer(unsafe.Pointer(v)))
+	return C.GoString(v)
+}
+
 // Pipeline is a wrapper for a GStreamer Pipeline
 type Pipeline struct {
 	Pipeline *C.GstElement
 }
 
+// JitterLatency is how long in milliseconds received video is held to wait
+// for lost packets to be retransmitted
+const JitterLatency = 200
+
+// AudioJitterLatency is how long in milliseconds received audio is held to
+// put packets arriving out of order back in order. Audio isn't retransmitted,
+// so it can be much shorter than for video
+const AudioJitterLatency = 60
+
+// MusicJitterLatency is the jitter buffer latency of music calls, where
+// latency matters more than the odd lost packet
+const MusicJitterLatency = 10
+
 // CreatePipeline creates a GStreamer Pipeline
 func CreatePipeline(payloadType webrtc.PayloadType, codecName string) *Pipeline {
+	return createPipeline(payloadType, codecName, audioSink("call"))
+}
+
+// CreateMixedPipeline creates a GStreamer Pipeline that plays audio through
+// the channel of a Mixer instead of its own output
+func CreateMixedPipeline(
+	payloadType webrtc.PayloadType,
+	codecName string,
+	channel string,
+) *Pipeline {
+	return createPipeline(
+		payloadType,
+		codecName,
+		fmt.Sprintf("interaudiosink channel=%s", channel),
+	)
+}
+
+// CreateRoutedPipeline creates a GStreamer Pipeline that plays audio to the
+// sink named device of the sound server, e.g. a null sink whose monitor other
+// programs record from
+func CreateRoutedPipeline(
+	payloadType webrtc.PayloadType,
+	codecName string,
+	device string,
+) *Pipeline {
+	return createPipeline(
+		payloadType,
+		codecName,
+		deviceSink(device, "call"),
+	)
+}
+
+func createPipeline(
+	payloadType webrtc.PayloadType,
+	codecName string,
+	audioSink string,
+) *Pipeline {
 	pipelineStr := "appsrc format=time is-live=true do-timestamp=true name=src ! application/x-rtp"
 	switch strings.ToLower(codecName) {
 	case "vp8":
-		pipelineStr += fmt.Sprintf(", payload=%d, encoding-name=VP8-DRAFT-IETF-01 ! rtpvp8depay ! decodebin ! autovideosink", payloadType)
+		// The jitter buffer puts the packets retransmitted after a NACK back
+		// in order before they reach the depayloader
+		pipelineStr += fmt.Sprintf(", media=video, clock-rate=90000, payload=%d, encoding-name=VP8-DRAFT-IETF-01 ! rtpjitterbuffer name=jitter latency=%d ! rtpvp8depay ! decodebin ! autovideosink", payloadType, JitterLatency)
 	case "opus":
-		pipelineStr += fmt.Sprintf(", payload=%d, encoding-name=OPUS ! rtpopusdepay ! decodebin ! autoaudiosink", payloadType)
+		pipelineStr += fmt.Sprintf(", media=audio, clock-rate=48000, payload=%d, encoding-name=OPUS ! rtpjitterbuffer name=jitter latency=%d do-lost=true ! rtpopusdepay ! opusdec plc=true use-inband-fec=true ! audioconvert ! volume name=volume ! %s", payloadType, AudioJitterLatency, audioSink)
 	case "vp9":
 		pipelineStr += " ! rtpvp9depay ! decodebin ! autovideosink"
 	case "h264":
 		pipelineStr += " ! rtph264depay ! decodebin ! autovideosink"
 	case "g722":
-		pipelineStr += " clock-rate=8000 ! rtpg722depay ! decodebin ! autoaudiosink"
+		pipelineStr += " clock-rate=8000 ! rtpg722depay ! decodebin ! audioconvert ! volume name=volume ! " + audioSink
 	default:
 		panic("Unhandled codec " + codecName)
 	}
@@ -61,6 +123,27 @@ func (p *Pipeline) Stop() {
 	C.gstreamer_receive_stop_pipeline(p.Pipeline)
 }
 
+// SetVolume sets the playback volume of an audio Pipeline, 1.0 being the
+// original volume
+func (p *Pipeline) SetVolume(volume float64) {
+	C.gstreamer_receive_set_volume(p.Pipeline, C.double(volume))
+}
+
+// SetLatency sets how long in milliseconds received packets are held in the
+// jitter buffer of the Pipeline
+func (p *Pipeline) SetLatency(latency int) {
+	C.gstreamer_receive_set_latency(p.Pipeline, C.int(latency))
+}
+
+// Concealment returns how many packets were played and how many were lost
+// and concealed by the decoder, with in-band FEC when the sender includes
+// it and by guessing the missing audio otherwise
+func (p *Pipeline) Concealment() (played, concealed uint64) {
+	var pushed, lost C.guint64
+	C.gstreamer_receive_get_jitter_stats(p.Pipeline, &pushed, &lost)
+	return uint64(pushed), uint64(lost)
+}
+
 // Push pushes a buffer on the appsrc of the GStreamer Pipeline
 func (p *Pipeline) Push(buffer []byte) {
 	b := C.CBytes(buffer)
@@ -68,3 +151,485 @@ func (p *Pipeline) Push(buffer []byte) {
 	C.gstreamer_receive_push_buffer(p.Pipeline, b, C.int(len(buffer)))
 }
  
+
+// OpusOptions are the settings passed to the Opus encoder of an audio
+// SendPipeline
+type OpusOptions struct {
+	// InbandFEC makes the encoder add forward error correction data, so that
+	// the receiver can recover from the loss of single packets
+	InbandFEC bool
+	// DTX enables discontinuous transmission, i.e. almost nothing is sent
+	// while there is silence
+	DTX bool
+	// PacketLoss is the expected packet loss percentage, the higher it is
+	// the more redundancy FEC adds
+	PacketLoss int
+	// Bitrate is the target bitrate in bits per second, 0 leaves it up to
+	// the encoder
+	Bitrate int
+	// Stereo encodes two channels instead of one
+	Stereo bool
+	// FrameSize is the duration of each Opus frame in milliseconds
+	FrameSize int
+	// Music encodes in the low delay, full band mode of Opus instead of
+	// tuning it for speech, and captures without the echo canceller, whose
+	// noise suppression mangles music
+	Music bool
+}
+
+// Channels returns the number of audio channels encoded
+func (opts OpusOptions) Channels() int {
+	if opts.Stereo {
+		return 2
+	}
+	return 1
+}
+
+// SampleHandler is called for every encoded buffer a SendPipeline produces
+type SampleHandler func(data []byte, duration time.Duration)
+
+// SendPipeline is a wrapper for a GStreamer Pipeline that captures and
+// encodes media
+type SendPipeline struct {
+	Pipeline *C.GstElement
+	id       int
+	handler  SampleHandler
+	// Name of the property that controls the bitrate of the encoder
+	bitrateProp string
+}
+
+var (
+	sendPipelines      = make(map[int]*SendPipeline)
+	sendPipelinesLock  sync.Mutex
+	sendPipelinesCount int
+)
+
+// ErrNoDevice is returned when creating a pipeline for a device that doesn't
+// exist
+var ErrNoDevice = errors.New("no such device")
+
+const (
+	audioSourceClass = "Audio/Source"
+	videoSourceClass = "Video/Source"
+)
+
+func listDevices(class string) []string {
+	classUnsafe := C.CString(class)
+	defer C.free(unsafe.Pointer(classUnsafe))
+	namesUnsafe := C.gstreamer_list_devices(classUnsafe)
+	defer C.g_free(C.gpointer(unsafe.Pointer(namesUnsafe)))
+
+	names := strings.Split(C.GoString(namesUnsafe), "\n")
+	return names[:len(names)-1]
+}
+
+// AudioSources returns the names of the available audio capture devices
+func AudioSources() []string {
+	return listDevices(audioSourceClass)
+}
+
+// VideoSources returns the names of the available video capture devices
+func VideoSources() []string {
+	return listDevices(videoSourceClass)
+}
+
+// VideoOptions are the capture settings of a video SendPipeline
+type VideoOptions struct {
+	// Width and Height of the captured video, 0 lets the device decide
+	Width  int
+	Height int
+	// Format is the raw pixel format requested from the device, e.g. YUY2
+	// or NV12. If empty the device decides
+	Format string
+	// Framerate in frames per second, 0 lets the device decide
+	Framerate int
+	// Bitrate is the target bitrate in bits per second, 0 leaves it up to
+	// the encoder
+	Bitrate int
+}
+
+// CreateVideoSendPipeline creates a GStreamer Pipeline that captures video
+// from device and encodes it with VP8. If device is empty the default source
+// is used
+func CreateVideoSendPipeline(
+	device string,
+	opts VideoOptions,
+	handler SampleHandler,
+) (*SendPipeline, error) {
+	pipelineStr := "video/x-raw"
+	if opts.Format != "" {
+		pipelineStr += ", format=" + opts.Format
+	}
+	if opts.Width > 0 && opts.Height > 0 {
+		pipelineStr += fmt.Sprintf(", width=%d, height=%d",
+			opts.Width, opts.Height)
+	}
+	if opts.Framerate > 0 {
+		pipelineStr += fmt.Sprintf(", framerate=%d/1", opts.Framerate)
+	}
+	pipelineStr += " ! videoconvert ! " +
+		"vp8enc name=encoder deadline=1 error-resilient=partitions " +
+		"keyframe-max-dist=10 auto-alt-ref=true cpu-used=5"
+	if opts.Bitrate > 0 {
+		pipelineStr += fmt.Sprintf(" target-bitrate=%d", opts.Bitrate)
+	}
+	pipelineStr += " ! appsink name=sink"
+
+	p, err := createSendPipeline(videoSourceClass, device, "autovideosrc",
+		pipelineStr, handler)
+	if err != nil {
+		return nil, err
+	}
+	p.bitrateProp = "target-bitrate"
+	return p, nil
+}
+
+// CreateAudioSendPipeline creates a GStreamer Pipeline that captures audio
+// from device and encodes it with Opus. If device is empty the default
+// source is used
+func CreateAudioSendPipeline(
+	device string,
+	opts OpusOptions,
+	handler SampleHandler,
+) (*SendPipeline, error) {
+	pipelineStr := opusEncoder(opts) + " ! appsink name=sink"
+	p, err := createSendPipeline(audioSourceClass, device, audioSource(opts.Music),
+		pipelineStr, handler)
+	if err != nil {
+		return nil, err
+	}
+	p.bitrateProp = "bitrate"
+	return p, nil
+}
+
+// opusEncoder returns the elements that encode raw audio with Opus
+func opusEncoder(opts OpusOptions) string {
+	pipelineStr := fmt.Sprintf(
+		"audioconvert ! audioresample ! "+
+			"audio/x-raw, rate=48000, channels=%d ! "+
+			"opusenc name=encoder inband-fec=%t dtx=%t "+
+			"packet-loss-percentage=%d "+
+			"frame-size=%d",
+		opts.Channels(),
+		opts.InbandFEC,
+		opts.DTX,
+		opts.PacketLoss,
+		opts.FrameSize,
+	)
+	if opts.Bitrate > 0 {
+		pipelineStr += fmt.Sprintf(" bitrate=%d", opts.Bitrate)
+	}
+	if opts.Music {
+		pipelineStr += " audio-type=restricted-lowdelay bandwidth=fullband"
+	}
+	return pipelineStr
+}
+
+// CreateConferenceMix creates a SendPipeline that mixes the RTP Opus streams
+// added with AddInput, and the default microphone if mic is set, and encodes
+// the mix with Opus. It goes on producing silence while there is nothing to
+// mix
+func CreateConferenceMix(
+	mic bool,
+	opts OpusOptions,
+	handler SampleHandler,
+) (*SendPipeline, error) {
+	pipelineStr := "audiomixer name=mix ! " + opusEncoder(opts) +
+		" ! appsink name=sink " +
+		"audiotestsrc wave=silence is-live=true ! mix."
+	if mic {
+		pipelineStr += " " + audioSource(false) +
+			" ! audioconvert ! audioresample ! mix."
+	}
+	pipelineStrUnsafe := C.CString(pipelineStr)
+	defer C.free(unsafe.Pointer(pipelineStrUnsafe))
+	pipeline := C.gstreamer_create_pipeline(pipelineStrUnsafe)
+	if pipeline == nil {
+		return nil, errors.New("couldn't create conference mix pipeline")
+	}
+	p := registerSendPipeline(pipeline, handler)
+	p.bitrateProp = "bitrate"
+	return p, nil
+}
+
+// AddInput adds an RTP Opus stream to a conference mix, pushed with
+// PushInput under name
+func (p *SendPipeline) AddInput(name string) {
+	desc := fmt.Sprintf(
+		"appsrc format=time is-live=true do-timestamp=true name=%s ! "+
+			"application/x-rtp, media=audio, clock-rate=48000, encoding-name=OPUS ! "+
+			"rtpjitterbuffer latency=%d do-lost=true ! rtpopusdepay ! "+
+			"opusdec plc=true ! audioconvert ! audioresample",
+		name, AudioJitterLatency,
+	)
+	addToMix(p.Pipeline, name+"-in", desc)
+}
+
+// RemoveInput takes the stream added as name out of a conference mix
+func (p *SendPipeline) RemoveInput(name string) {
+	removeFromMix(p.Pipeline, name+"-in")
+}
+
+// PushInput pushes an RTP packet of the stream added as name
+func (p *SendPipeline) PushInput(name string, buffer []byte) {
+	nameUnsafe := C.CString(name)
+	defer C.free(unsafe.Pointer(nameUnsafe))
+	b := C.CBytes(buffer)
+	defer C.free(b)
+	C.gstreamer_push_buffer_to(p.Pipeline, nameUnsafe, b, C.int(len(buffer)))
+}
+
+// createSendPipeline creates a pipeline starting from device, or from the
+// element autosrc if there is no device, followed by the elements in
+// pipelineStr
+func createSendPipeline(
+	class string,
+	device string,
+	autosrc string,
+	pipelineStr string,
+	handler SampleHandler,
+) (*SendPipeline, error) {
+	var pipeline *C.GstElement
+	if device == "" {
+		pipelineStrUnsafe := C.CString(autosrc + " ! " + pipelineStr)
+		defer C.free(unsafe.Pointer(pipelineStrUnsafe))
+		pipeline = C.gstreamer_create_pipeline(pipelineStrUnsafe)
+	} else {
+		classUnsafe := C.CString(class)
+		defer C.free(unsafe.Pointer(classUnsafe))
+		deviceUnsafe := C.CString(device)
+		defer C.free(unsafe.Pointer(deviceUnsafe))
+		pipelineStrUnsafe := C.CString(pipelineStr)
+		defer C.free(unsafe.Pointer(pipelineStrUnsafe))
+		pipeline = C.gstreamer_create_device_pipeline(
+			classUnsafe,
+			deviceUnsafe,
+			pipelineStrUnsafe,
+		)
+		if pipeline == nil {
+			return nil, ErrNoDevice
+		}
+	}
+
+	return registerSendPipeline(pipeline, handler), nil
+}
+
+// registerSendPipeline wraps pipeline so that the buffers of its appsink
+// named sink are given to handler
+func registerSendPipeline(
+	pipeline *C.GstElement,
+	handler SampleHandler,
+) *SendPipeline {
+	sendPipelinesLock.Lock()
+	defer sendPipelinesLock.Unlock()
+
+	p := &SendPipeline{
+		Pipeline: pipeline,
+		id:       sendPipelinesCount,
+		handler:  handler,
+	}
+	sendPipelines[p.id] = p
+	sendPipelinesCount++
+	return p
+}
+
+// Start starts the GStreamer Pipeline
+func (p *SendPipeline) Start() {
+	C.gstreamer_send_start_pipeline(p.Pipeline, C.int(p.id))
+}
+
+// SetBitrate changes the target bitrate of the encoder while the pipeline is
+// running
+func (p *SendPipeline) SetBitrate(bitrate int) {
+	propUnsafe := C.CString(p.bitrateProp)
+	defer C.free(unsafe.Pointer(propUnsafe))
+	C.gstreamer_send_set_bitrate(p.Pipeline, propUnsafe, C.int(bitrate))
+}
+
+// ForceKeyframe makes the encoder of a video pipeline produce a keyframe as
+// soon as possible
+func (p *SendPipeline) ForceKeyframe() {
+	C.gstreamer_send_force_keyframe(p.Pipeline)
+}
+
+// Stop stops the GStreamer Pipeline
+func (p *SendPipeline) Stop() {
+	C.gstreamer_send_stop_pipeline(p.Pipeline)
+	sendPipelinesLock.Lock()
+	delete(sendPipelines, p.id)
+	sendPipelinesLock.Unlock()
+}
+
+//export goHandlePipelineBuffer
+func goHandlePipelineBuffer(
+	buffer unsafe.Pointer,
+	bufferLen C.int,
+	duration C.int,
+	id C.int,
+) {
+	sendPipelinesLock.Lock()
+	p, ok := sendPipelines[int(id)]
+	sendPipelinesLock.Unlock()
+
+	if ok {
+		p.handler(C.GoBytes(buffer, bufferLen), time.Duration(duration))
+	}
+	C.free(buffer)
+}
+
+// Recorder is a GStreamer Pipeline that muxes the RTP streams received in a
+// call into a single file: WebM for video calls, Ogg Opus for voice calls.
+// Each stream is pushed to its own appsrc, timestamped on arrival, so that
+// audio and video stay in sync
+type Recorder struct {
+	Pipeline *C.GstElement
+}
+
+// CreateRecorder creates a Recorder writing to path. The caller picks the
+// extension, .webm if video is set and .opus otherwise. The path is set on
+// the filesink after parsing, it may come from the peer and must never be
+// read as part of the pipeline description
+func CreateRecorder(path string, video bool) (*Recorder, error) {
+	audioStr := "appsrc format=time is-live=true do-timestamp=true name=audio ! " +
+		"application/x-rtp, media=audio, clock-rate=48000, encoding-name=OPUS ! " +
+		"rtpjitterbuffer ! rtpopusdepay ! opusparse"
+	var pipelineStr string
+	if video {
+		pipelineStr = fmt.Sprintf(
+			"webmmux name=mux ! filesink name=file "+
+				"%s ! queue ! mux. "+
+				"appsrc format=time is-live=true do-timestamp=true name=video ! "+
+				"application/x-rtp, media=video, clock-rate=90000, encoding-name=VP8-DRAFT-IETF-01 ! "+
+				"rtpjitterbuffer latency=%d ! rtpvp8depay ! queue ! mux.",
+			audioStr, JitterLatency,
+		)
+	} else {
+		pipelineStr = audioStr + " ! oggmux ! filesink name=file"
+	}
+
+	pipelineStrUnsafe := C.CString(pipelineStr)
+	defer C.free(unsafe.Pointer(pipelineStrUnsafe))
+	pipeline := C.gstreamer_create_pipeline(pipelineStrUnsafe)
+	if pipeline == nil {
+		return nil, errors.New("couldn't create recording pipeline")
+	}
+	pathUnsafe := C.CString(path)
+	defer C.free(unsafe.Pointer(pathUnsafe))
+	if C.gstreamer_set_location(pipeline, pathUnsafe) == 0 {
+		C.gst_object_unref(C.gpointer(unsafe.Pointer(pipeline)))
+		return nil, errors.New("recording pipeline has no file sink")
+	}
+	return &Recorder{Pipeline: pipeline}, nil
+}
+
+// Start starts recording
+func (r *Recorder) Start() {
+	C.gstreamer_recorder_start(r.Pipeline)
+}
+
+func (r *Recorder) push(src string, buffer []byte) {
+	srcUnsafe := C.CString(src)
+	defer C.free(unsafe.Pointer(srcUnsafe))
+	b := C.CBytes(buffer)
+	defer C.free(b)
+	C.gstreamer_push_buffer_to(r.Pipeline, srcUnsafe, b, C.int(len(buffer)))
+}
+
+// PushAudio pushes an RTP packet of the audio track
+func (r *Recorder) PushAudio(buffer []byte) {
+	r.push("audio", buffer)
+}
+
+// PushVideo pushes an RTP packet of the video track
+func (r *Recorder) PushVideo(buffer []byte) {
+	r.push("video", buffer)
+}
+
+// Stop ends the streams and waits for the file to be finalized before
+// stopping the pipeline, otherwise the file would be left without its index
+func (r *Recorder) Stop() {
+	C.gstreamer_recorder_stop(r.Pipeline)
+}
+
+// Mixer plays the audio of several calls through a single output device.
+// Each call's Pipeline sends its audio to a channel of the Mixer
+type Mixer struct {
+	Pipeline *C.GstElement
+}
+
+// CreateMixer creates a Mixer with no channels
+func CreateMixer() *Mixer {
+	pipelineStr := "audiomixer name=mix ! audioconvert ! " + audioSink("calls")
+	pipelineStrUnsafe := C.CString(pipelineStr)
+	defer C.free(unsafe.Pointer(pipelineStrUnsafe))
+	return &Mixer{Pipeline: C.gstreamer_create_pipeline(pipelineStrUnsafe)}
+}
+
+// Start starts playing the mixed audio
+func (m *Mixer) Start() {
+	C.gstreamer_receive_start_pipeline(m.Pipeline)
+}
+
+// Add adds channel to the mix
+func (m *Mixer) Add(channel string) {
+	addToMix(m.Pipeline, channel, fmt.Sprintf(
+		"interaudiosrc channel=%s ! audioconvert ! audioresample", channel))
+}
+
+// Remove takes channel out of the mix
+func (m *Mixer) Remove(channel string) {
+	removeFromMix(m.Pipeline, channel)
+}
+
+// addToMix links a bin made from desc, named name, to the audiomixer named
+// mix of pipeline
+func addToMix(pipeline *C.GstElement, name, desc string) {
+	nameUnsafe := C.CString(name)
+	defer C.free(unsafe.Pointer(nameUnsafe))
+	descUnsafe := C.CString(desc)
+	defer C.free(unsafe.Pointer(descUnsafe))
+	C.gstreamer_mixer_add(pipeline, nameUnsafe, descUnsafe)
+}
+
+func removeFromMix(pipeline *C.GstElement, name string) {
+	nameUnsafe := C.CString(name)
+	defer C.free(unsafe.Pointer(nameUnsafe))
+	C.gstreamer_mixer_remove(pipeline, nameUnsafe)
+}
+
+// Player plays an audio file in a loop, e.g. a ringtone
+type Player struct {
+	Pipeline *C.GstElement
+}
+
+// CreatePlayer creates a Player for the audio file at path, in any format
+// GStreamer can decode. Like the path of a Recorder, it is set on the
+// filesrc after parsing
+func CreatePlayer(path string) (*Player, error) {
+	pipelineStr := "filesrc name=file ! decodebin ! audioconvert ! " +
+		"audioresample ! " + audioSink("tone")
+	pipelineStrUnsafe := C.CString(pipelineStr)
+	defer C.free(unsafe.Pointer(pipelineStrUnsafe))
+	pipeline := C.gstreamer_create_pipeline(pipelineStrUnsafe)
+	if pipeline == nil {
+		return nil, errors.New("couldn't create player pipeline")
+	}
+	pathUnsafe := C.CString(path)
+	defer C.free(unsafe.Pointer(pathUnsafe))
+	if C.gstreamer_set_location(pipeline, pathUnsafe) == 0 {
+		C.gst_object_unref(C.gpointer(unsafe.Pointer(pipeline)))
+		return nil, errors.New("player pipeline has no file source")
+	}
+	return &Player{Pipeline: pipeline}, nil
+}
+
+// Start starts playing, from the beginning again every time the end of the
+// file is reached
+func (p *Player) Start() {
+	C.gstreamer_player_start(p.Pipeline)
+}
+
+// Stop stops playing
+func (p *Player) Stop() {
+	C.gstreamer_player_stop(p.Pipeline)
+}
@@ -3,16 +3,56 @@
 
 #include <glib.h>
 #include <gst/gst.h>
+#include <gst/video/video.h>
 #include <stdint.h>
 #include <stdlib.h>
 
 void gstreamer_start_mainloop(void);
 GstElement *gstreamer_create_pipeline(char *pipeline);
+int gstreamer_set_location(GstElement *pipeline, const char *location);
+
+/* Devices */
+
+char *gstreamer_list_devices(const char *klass);
+GstElement *gstreamer_create_device_pipeline(const char *klass,
+	const char *name, char *rest);
 
 /* Receive */
 
 void gstreamer_receive_start_pipeline(GstElement *pipeline);
 void gstreamer_receive_stop_pipeline(GstElement *pipeline);
 void gstreamer_receive_push_buffer(GstElement *pipeline, void *buffer, int len);
+void gstreamer_receive_set_volume(GstElement *pipeline, double volume);
+void gstreamer_receive_set_latency(GstElement *pipeline, int latency);
+void gstreamer_receive_get_jitter_stats(GstElement *pipeline, guint64 *pushed,
+	guint64 *lost);
+
+/* Send */
+
+extern void goHandlePipelineBuffer(void *buffer, int len, int duration, int id);
+
+void gstreamer_send_start_pipeline(GstElement *pipeline, int id);
+void gstreamer_send_stop_pipeline(GstElement *pipeline);
+void gstreamer_send_set_bitrate(GstElement *pipeline, const char *property,
+	int bitrate);
+void gstreamer_send_force_keyframe(GstElement *pipeline);
+
+/* Record */
+
+void gstreamer_push_buffer_to(GstElement *pipeline, const char *name,
+	void *buffer, int len);
+void gstreamer_recorder_start(GstElement *pipeline);
+void gstreamer_recorder_stop(GstElement *pipeline);
+
+/* Mix */
+
+void gstreamer_mixer_add(GstElement *mixer, const char *name,
+	const char *desc);
+void gstreamer_mixer_remove(GstElement *mixer, const char *name);
+
+/* Play */
+
+void gstreamer_player_start(GstElement *pipeline);
+void gstreamer_player_stop(GstElement *pipeline);
 
 #endif
@@ -0,0 +1,58 @@
+//go:build jack
+// +build jack
+
+package gst
+
+/*
+#cgo pkg-config: jack
+
+#include <jack/jack.h>
+
+static jack_client_t *jack_monitor;
+static volatile int jack_xruns;
+
+static int
+jack_count_xrun(void *arg)
+{
+	jack_xruns++;
+	return 0;
+}
+
+static int
+jack_monitor_start(void)
+{
+	jack_monitor = jack_client_open("wrtcion-monitor", JackNoStartServer,
+		NULL);
+	if (jack_monitor == NULL)
+		return -1;
+	jack_set_xrun_callback(jack_monitor, jack_count_xrun, NULL);
+	return jack_activate(jack_monitor);
+}
+*/
+import "C"
+import "errors"
+
+// JACK tells whether xruns can be reported, i.e. whether the jack tag was
+// given when building
+const JACK = true
+
+// StartJACKMonitor connects a client without ports to the JACK server to
+// learn its sample rate and count its xruns
+func StartJACKMonitor() error {
+	if C.jack_monitor_start() != 0 {
+		return errors.New("couldn't connect to the JACK server")
+	}
+	return nil
+}
+
+// JACKStats returns the state of the JACK server, and false if the monitor
+// isn't running
+func JACKStats() (JACKStatus, bool) {
+	if C.jack_monitor == nil {
+		return JACKStatus{}, false
+	}
+	return JACKStatus{
+		SampleRate: int(C.jack_get_sample_rate(C.jack_monitor)),
+		Xruns:      int(C.jack_xruns),
+	}, true
+}
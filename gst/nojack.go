@@ -0,0 +1,21 @@
+//go:build !jack
+// +build !jack
+
+package gst
+
+import "errors"
+
+// JACK tells whether xruns can be reported, i.e. whether the jack tag was
+// given when building
+const JACK = false
+
+// StartJACKMonitor needs libjack, which is only linked in when building with
+// the jack tag
+func StartJACKMonitor() error {
+	return errors.New("built without JACK support, use -tags jack")
+}
+
+// JACKStats always returns false without JACK support
+func JACKStats() (JACKStatus, bool) {
+	return JACKStatus{}, false
+}
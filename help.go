@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// commandHelp documents a command for /help
+type commandHelp struct {
+	Name        string
+	Synopsis    string
+	Description string
+	Examples    []string
+}
+
+// commands are listed by /help in this order
+var commands = []commandHelp{
+	{"/chat", "/chat <address>",
+		"Connects to the peer at address for chat and files only, without audio.",
+		[]string{"/chat localhost:8002"}},
+	{"/call", "/call <address> [bitrate=<bps>] [stereo=<bool>] [frame=<ms>] [music=<bool>]",
+		"Calls the peer at address. The settings override the Opus encoder " +
+			"flags for this call only; music=true tunes it for music.",
+		[]string{"/call localhost:8002", "/call host:8002 bitrate=64000 stereo=true"}},
+	{"/video", "/video <address> [size=<w>x<h>] [fps=<n>] [vbitrate=<bps>]",
+		"Calls the peer at address and sends it video from the camera too. " +
+			"It takes the settings of /call as well.",
+		[]string{"/video localhost:8002 size=1280x720 fps=30"}},
+	{"/accept", "/accept <address>",
+		"Answers the incoming call from address.",
+		[]string{"/accept localhost:8001"}},
+	{"/reject", "/reject <address>",
+		"Turns down the incoming call from address.",
+		[]string{"/reject localhost:8001"}},
+	{"/end", "/end <address>",
+		"Hangs up the call or closes the chat with address.",
+		[]string{"/end localhost:8002"}},
+	{"/msg", "/msg <address> <message>",
+		"Sends a message to a single peer. Text that isn't a command goes to " +
+			"every connected peer.",
+		[]string{"/msg localhost:8002 see you at five"}},
+	{"/me", "/me <action>",
+		"Tells every connected peer what you are doing, in the third person.",
+		[]string{"/me waves"}},
+	{"/mute", "/mute <address>",
+		"Stops sending the microphone to address.", nil},
+	{"/unmute", "/unmute <address>",
+		"Sends the microphone to address again.", nil},
+	{"/volume", "/volume <address> <0-150>",
+		"Sets the volume the call with address is played at, in percent.",
+		[]string{"/volume localhost:8002 80"}},
+	{"/debuglog", "/debuglog",
+		"Shows or hides the debug pane, with the technical log: ICE, " +
+			"data channel and media events, and what pion reports.", nil},
+	{"/loglevel", "/loglevel [debug|info|warn|error]",
+		"Shows only the log lines of level and above in the full screen " +
+			"interface, info by default. The log file has them all. " +
+			"Without a level, tells the one shown.",
+		[]string{"/loglevel debug"}},
+	{"/devices", "/devices",
+		"Lists the audio capture devices.", nil},
+	{"/mic", "/mic [device]",
+		"Captures the next calls from device, as listed by /devices, or from " +
+			"the default microphone without one.", nil},
+	{"/cameras", "/cameras",
+		"Lists the video capture devices.", nil},
+	{"/camera", "/camera [device]",
+		"Captures the next video calls from device, as listed by /cameras, or " +
+			"from the default camera without one.", nil},
+	{"/hold", "/hold <address>",
+		"Puts the call with address on hold, playing the hold music to it if " +
+			"there is any.", nil},
+	{"/resume", "/resume <address>",
+		"Takes the call with address off hold.", nil},
+	{"/send", "/send <address> <file>",
+		"Sends a file to address.",
+		[]string{"/send localhost:8002 notes.txt"}},
+	{"/acceptfile", "/acceptfile <address> [id]",
+		"Accepts a file offered by address. The id is the one in the offer, " +
+			"it can be left out if there is only one.",
+		[]string{"/acceptfile localhost:8002 1"}},
+	{"/rejectfile", "/rejectfile <address> [id]",
+		"Turns down a file offered by address.", nil},
+	{"/files", "/files <address>",
+		"Shows the file transfers with address.", nil},
+	{"/watch", "/watch <address> <directory>",
+		"Sends every file dropped into directory to address, whenever we are " +
+			"connected to it. Files are sent again when they change, and " +
+			"those the peer rejects on the next connection. The directory " +
+			"of a contact is watched again on the next start.",
+		[]string{"/watch localhost:8002 ~/outbox", "/watch alice ~/outbox"}},
+	{"/unwatch", "/unwatch <address>",
+		"Stops watching the directory of address, and forgets it for a contact.",
+		nil},
+	{"/diag", "/diag <address>",
+		"Shows diagnostic information about the connection to address.", nil},
+	{"/caps", "/caps <address>",
+		"Shows what address supports, and how it was built.", nil},
+	{"/stats", "/stats <address>",
+		"Shows the encryption and the quality of the call with address.", nil},
+	{"/identity", "/identity",
+		"Shows the fingerprint of our certificate, for peers to /trust.", nil},
+	{"/trust", "/trust <address> [fingerprint]",
+		"Pins the certificate of address, the one it is using now if no " +
+			"fingerprint is given.", nil},
+	{"/relay", "/relay <address> <on|off>",
+		"Sends the media of the next connections with address through the " +
+			"TURN server, so that it never learns our address.", nil},
+	{"/continue", "/continue",
+		"Calls a peer waiting for consent directly, revealing our address.", nil},
+	{"/userelay", "/userelay",
+		"Calls a peer waiting for consent through the TURN server.", nil},
+	{"/cancel", "/cancel",
+		"Gives up on a call waiting for consent.", nil},
+	{"/record", "/record <start|stop> <address>",
+		"Starts or stops recording the call with address.", nil},
+	{"/focus", "/focus <address>",
+		"Sends the microphone to the call with address only.", nil},
+	{"/sink", "/sink <address> [device]",
+		"Plays the next calls with address to the output device, or to the " +
+			"default one without it.",
+		[]string{"/sink localhost:8002 obs_sink"}},
+	{"/dtmf", "/dtmf <address> <digits>",
+		"Sends digits to address as telephone events.",
+		[]string{"/dtmf localhost:8002 1234#"}},
+	{"/channel", "/channel <address> <label> [unordered] [retransmits=<n>]",
+		"Opens an extra data channel with address.",
+		[]string{"/channel localhost:8002 game unordered retransmits=0"}},
+	{"/chsend", "/chsend <address> <label> <text>",
+		"Sends text on the data channel label.", nil},
+	{"/chclose", "/chclose <address> <label>",
+		"Closes the data channel label.", nil},
+	{"/rate", "/rate <1-5> [note]",
+		"Rates the last call, when asked to.",
+		[]string{"/rate 4 some echo"}},
+	{"/skip", "/skip",
+		"Skips rating the last call.", nil},
+	{"/history", "/history [address | outcome]",
+		"Shows the latest calls, who they were with, when and for how long, " +
+			"or how they ended if nobody answered. They can be narrowed down " +
+			"to the calls with address, or to those answered, missed, " +
+			"unanswered, rejected, busy, unavailable, failed or voicemail.",
+		[]string{"/history alice", "/history missed"}},
+	{"/search", "/search [-here] <text>",
+		"Finds text in the chat history, with every peer or only the current " +
+			"one with -here, and jumps to the latest match in the pane of " +
+			"its peer. End follows new lines again.",
+		[]string{"/search address", "/search -here tomorrow"}},
+	{"/forget", "/forget <address>",
+		"Forgets the video cap and the loss learned in the calls with address.",
+		nil},
+	{"/paste", "/paste <address>",
+		"Shares the text in our clipboard with address.", nil},
+	{"/copy", "/copy",
+		"Copies the clipboard a peer shared last into ours.", nil},
+	{"/dnd", "/dnd <on|off>",
+		"Do not disturb: calls are refused without ringing, telling the " +
+			"caller we are unavailable, and messages are kept quiet until " +
+			"it is turned off.", nil},
+	{"/datasaver", "/datasaver <on|off>",
+		"Keeps the data used by calls down, for metered connections.", nil},
+	{"/conference", "/conference [start|stop]",
+		"Starts or ends hosting a conference, or shows who is in it. Every " +
+			"member hears the others and our microphone, mixed here.",
+		[]string{"/conference start"}},
+	{"/invite", "/invite <address>",
+		"Calls address into the conference we are hosting.",
+		[]string{"/invite localhost:8003"}},
+	{"/add", "/add <name> <address>",
+		"Adds a contact to the address book. Its name can then be given to " +
+			"any command instead of its address, and is shown with it.",
+		[]string{"/add alice 192.168.1.5:8001", "/call alice"}},
+	{"/contacts", "/contacts",
+		"Lists the address book. With -presence, contacts are checked " +
+			"every 30 seconds and shown with whether they are online.", nil},
+	{"/autoanswer", "/autoanswer <contact> <on|off>",
+		"Answers the calls of a contact without asking, as long as its " +
+			"certificate is the trusted one.",
+		[]string{"/autoanswer alice on"}},
+	{"/redial", "/redial",
+		"Calls the last peer we called again, the same way. Anywhere in a " +
+			"command, !$ stands for the address of the last peer we called " +
+			"or got a call from, and a line of just !! repeats the last " +
+			"command.",
+		[]string{"/msg !$ sorry, got cut off", "!!"}},
+	{"/callback", "/callback",
+		"Calls back the last peer that called us.", nil},
+	{"/transfer", "/transfer <address> <new address>",
+		"Asks address to call new address instead, hanging up on us once " +
+			"that call connects.",
+		[]string{"/transfer localhost:8002 localhost:8003"}},
+	{"/sfu", "/sfu <on|off>",
+		"Forwards the audio and video of every call to all the others as " +
+			"they are, to host a small group call.", nil},
+	{"/version", "/version",
+		"Shows how this binary was built.", nil},
+	{"/help", "/help [command | search <text>]",
+		"Lists the commands, shows the details of one, or finds the ones " +
+			"about text.",
+		[]string{"/help call", "/help search video"}},
+	{"/exit", "/exit",
+		"Hangs up every call and quits.", nil},
+}
+
+// helpText returns the answer to /help followed by query: every command
+// without one, the details of the command named by it, or the commands
+// matching a search
+func helpText(query string) string {
+	var b strings.Builder
+	query = strings.TrimSpace(query)
+	switch {
+	case query == "":
+		b.WriteString("enter a command or send a message to all connected peers\n")
+		b.WriteString("Tab and Shift-Tab switch to the chat with a single peer\n")
+		b.WriteString("Tab after / completes commands, and the peer after /call, /msg or /end\n")
+		b.WriteString("PgUp, PgDn, Home and End scroll through it\n")
+		b.WriteString("Up and Down recall what was entered before\n")
+		b.WriteString("Alt+Up and Alt+Down pick the connection /msg, /end and /mute act on\n")
+		b.WriteString("commands available, /help <command> for more:\n")
+		for _, c := range commands {
+			fmt.Fprintln(&b, c.Synopsis)
+		}
+	case strings.HasPrefix(query, "search "):
+		term := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(query, "search")))
+		found := false
+		for _, c := range commands {
+			text := strings.ToLower(c.Synopsis + " " + c.Description)
+			if strings.Contains(text, term) {
+				fmt.Fprintf(&b, "%s\n  %s\n", c.Synopsis, c.Description)
+				found = true
+			}
+		}
+		if !found {
+			fmt.Fprintf(&b, "no commands about %s\n", term)
+		}
+	default:
+		name := "/" + strings.TrimPrefix(query, "/")
+		for _, c := range commands {
+			if c.Name != name {
+				continue
+			}
+			fmt.Fprintf(&b, "%s\n\n%s\n", c.Synopsis, c.Description)
+			if len(c.Examples) > 0 {
+				b.WriteString("\nexamples:\n")
+				for _, example := range c.Examples {
+					fmt.Fprintln(&b, " ", example)
+				}
+			}
+			return b.String()
+		}
+		fmt.Fprintf(&b, "no command called %s, try /help search %s\n",
+			name, strings.TrimPrefix(query, "/"))
+	}
+	return b.String()
+}
+
+// helpQuery tells whether cmd asks for help, and what about
+func helpQuery(cmd string) (string, bool) {
+	if cmd != "/help" && !strings.HasPrefix(cmd, "/help ") {
+		return "", false
+	}
+	return strings.TrimPrefix(cmd, "/help"), true
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/oggreader"
+)
+
+// SetHeld puts the call with remote on hold or resumes it. While on hold no
+// audio is sent nor played, except for the hold music if there is any
+func (peer *RTCPeer) SetHeld(remote string, held bool) {
+	conn, ok := peer.Connections[remote]
+	if !ok {
+		log.Println("not connected to", remote)
+		return
+	}
+	if conn.state != InCall {
+		log.Println("not in a call with", remote)
+		return
+	}
+	if conn.held == held {
+		return
+	}
+	conn.held = held
+
+	action := Resumed
+	if held {
+		action = Held
+		log.Println("call with", remote, "on hold")
+		if peer.HoldMusic != "" && conn.audioSndr != nil {
+			go conn.playHoldMusic(peer.HoldMusic)
+		}
+	} else {
+		log.Println("call with", remote, "resumed")
+	}
+	if err := conn.sendControl(ControlMessage{Action: action}); err != nil {
+		logError("couldn't notify", remote, "of hold state:", err)
+	}
+}
+
+// playHoldMusic streams the ogg file at fname in a loop for as long as the
+// call is on hold
+func (conn *Connection) playHoldMusic(fname string) {
+	file, err := os.Open(fname)
+	if err != nil {
+		logError("couldn't open hold music:", err)
+		return
+	}
+	defer file.Close()
+	ogg, _, err := oggreader.NewWith(file)
+	if err != nil {
+		logError("couldn't read hold music:", err)
+		return
+	}
+
+	var lastGranule uint64
+	ticker := time.NewTicker(oggPageDuration)
+	defer ticker.Stop()
+	for ; conn.held && conn.state == InCall; <-ticker.C {
+		pageData, pageHeader, err := ogg.ParseNextPage()
+		if err == io.EOF {
+			// Start over
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				logError("couldn't rewind hold music:", err)
+				return
+			}
+			ogg, _, err = oggreader.NewWith(file)
+			if err != nil {
+				logError("couldn't read hold music:", err)
+				return
+			}
+			lastGranule = 0
+			continue
+		} else if err != nil {
+			logError("error reading hold music:", err)
+			return
+		}
+
+		duration := granuleDuration(pageHeader.GranulePosition, lastGranule)
+		lastGranule = pageHeader.GranulePosition
+		err = conn.audioSndr.track.WriteSample(media.Sample{
+			Data:     pageData,
+			Duration: duration,
+		})
+		if err != nil {
+			logError("error writing hold music:", err)
+			return
+		}
+	}
+}
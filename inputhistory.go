@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+const (
+	inputHistoryPath = outputPath + "input_history"
+	// How many of the lines entered last are kept for recalling
+	maxInputHistory = 1000
+)
+
+// inputHistory are the commands and messages entered in the full screen
+// interface, recalled with Up and Down like in a shell. Every line is also
+// appended to inputHistoryPath, so that the history outlives the session
+type inputHistory struct {
+	lines []string
+	// pos is the index of the line recalled, len(lines) when none is
+	pos int
+	// draft is what was being entered before recalling a line
+	draft string
+}
+
+// loadInputHistory reads the lines entered in earlier sessions
+func loadInputHistory() *inputHistory {
+	h := &inputHistory{}
+	f, err := os.Open(inputHistoryPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logError("couldn't read input history:", err)
+		}
+		return h
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		h.lines = append(h.lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		logError("couldn't read input history:", err)
+	}
+	if len(h.lines) > maxInputHistory {
+		h.lines = h.lines[len(h.lines)-maxInputHistory:]
+	}
+	h.pos = len(h.lines)
+	return h
+}
+
+// add records line as the latest one entered, unless it is blank or the
+// same as the one before
+func (h *inputHistory) add(line string) {
+	h.draft = ""
+	if strings.TrimSpace(line) == "" ||
+		(len(h.lines) > 0 && h.lines[len(h.lines)-1] == line) {
+		h.pos = len(h.lines)
+		return
+	}
+	h.lines = append(h.lines, line)
+	if len(h.lines) > maxInputHistory {
+		h.lines = h.lines[1:]
+	}
+	h.pos = len(h.lines)
+	if err := os.MkdirAll(outputPath, 0755); err != nil {
+		logError("couldn't save input history:", err)
+		return
+	}
+	f, err := os.OpenFile(inputHistoryPath,
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		logError("couldn't save input history:", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		logError("couldn't save input history:", err)
+	}
+}
+
+// prev returns the line entered before the one recalled, keeping current
+// as the draft when starting to recall. It returns false when there are no
+// older lines
+func (h *inputHistory) prev(current string) (string, bool) {
+	if h.pos == 0 {
+		return "", false
+	}
+	if h.pos == len(h.lines) {
+		h.draft = current
+	}
+	h.pos--
+	return h.lines[h.pos], true
+}
+
+// next returns the line entered after the one recalled, or the draft past
+// the latest one. It returns false when nothing is being recalled
+func (h *inputHistory) next() (string, bool) {
+	if h.pos >= len(h.lines) {
+		return "", false
+	}
+	h.pos++
+	if h.pos == len(h.lines) {
+		return h.draft, true
+	}
+	return h.lines[h.pos], true
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/pion/rtp"
+)
+
+const (
+	// How many packets are held waiting for a missing one before giving it
+	// up for lost
+	reorderWindow = 64
+	// How many packets in a row may be too old before the sender is taken
+	// to have jumped to other sequence numbers, like after a restart
+	resyncAfter = reorderWindow
+)
+
+// reorderBuffer puts RTP packets that arrive out of order back in order of
+// sequence number. Packets arriving after their place has been given up are
+// dropped
+type reorderBuffer struct {
+	packets map[uint16]*rtp.Packet
+	next    uint16
+	started bool
+	// dropped counts the packets dropped in a row for being too old
+	dropped int
+}
+
+func newReorderBuffer() *reorderBuffer {
+	return &reorderBuffer{packets: make(map[uint16]*rtp.Packet)}
+}
+
+// push adds pkt to the buffer and returns the packets that are now ready,
+// in order
+func (b *reorderBuffer) push(pkt *rtp.Packet) []*rtp.Packet {
+	if !b.started {
+		b.next = pkt.SequenceNumber
+		b.started = true
+	}
+	// Sequence numbers wrap around, so compare their distance
+	if int16(pkt.SequenceNumber-b.next) < 0 {
+		b.dropped++
+		if b.dropped < resyncAfter {
+			return nil
+		}
+		// Nothing would ever be played again otherwise
+		b.packets = make(map[uint16]*rtp.Packet)
+		b.next = pkt.SequenceNumber
+	}
+	b.dropped = 0
+	b.packets[pkt.SequenceNumber] = pkt
+
+	var ready []*rtp.Packet
+	for len(b.packets) > 0 {
+		p, ok := b.packets[b.next]
+		if !ok {
+			if len(b.packets) < reorderWindow {
+				break
+			}
+			// Waited long enough, the packet is lost
+			b.next++
+			continue
+		}
+		delete(b.packets, b.next)
+		ready = append(ready, p)
+		b.next++
+	}
+	return ready
+}
+
+// flush returns every packet still held, in order
+func (b *reorderBuffer) flush() []*rtp.Packet {
+	ready := make([]*rtp.Packet, 0, len(b.packets))
+	for _, p := range b.packets {
+		ready = append(ready, p)
+	}
+	sort.Slice(ready, func(i, j int) bool {
+		return ready[i].SequenceNumber-b.next < ready[j].SequenceNumber-b.next
+	})
+	b.packets = make(map[uint16]*rtp.Packet)
+	return ready
+}
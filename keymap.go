@@ -0,0 +1,114 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// defaultKeys are bound unless the config file says otherwise. Commands run
+// by keys act on the connection highlighted in the sidebar
+var defaultKeys = map[string]string{
+	"F2":  "/accept",
+	"F3":  "/end",
+	"F4":  "/mute",
+	"F5":  "/unmute",
+	"F12": "/debuglog",
+}
+
+// keyBinding is a key as pressed, and the command it runs
+type keyBinding struct {
+	key tcell.Key
+	ch  rune
+	alt bool
+	cmd string
+}
+
+// keymap are the keys that run commands in the full screen interface
+type keymap []keyBinding
+
+// newKeymap binds defaultKeys with keys over them, leaving out the ones
+// that can't be told apart from the keys the input field needs
+func newKeymap(keys map[string]string) keymap {
+	merged := make(map[string]string)
+	for name, cmd := range defaultKeys {
+		merged[name] = cmd
+	}
+	for name, cmd := range keys {
+		merged[name] = cmd
+	}
+	names := make([]string, 0, len(merged))
+	for name := range merged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var km keymap
+	for _, name := range names {
+		if merged[name] == "" {
+			continue
+		}
+		b, ok := parseKey(name)
+		if !ok {
+			logWarn("unknown key in config:", name)
+			continue
+		}
+		switch b.key {
+		case tcell.KeyEnter, tcell.KeyTab, tcell.KeyEscape, tcell.KeyBackspace:
+			// Ctrl+M is Enter, Ctrl+I is Tab and so on in a terminal
+			logWarn("key", name, "is needed for the input, not binding it")
+			continue
+		}
+		b.cmd = merged[name]
+		km = append(km, b)
+	}
+	return km
+}
+
+// parseKey reads key names as tcell writes them, like F2, PgUp or Ctrl-A,
+// optionally after Alt+. Ctrl+A is taken as Ctrl-A too
+func parseKey(name string) (keyBinding, bool) {
+	var b keyBinding
+	if strings.HasPrefix(name, "Alt+") {
+		b.alt = true
+		name = strings.TrimPrefix(name, "Alt+")
+	}
+	name = strings.Replace(name, "Ctrl+", "Ctrl-", 1)
+	if len(name) == 6 && strings.HasPrefix(strings.ToLower(name), "ctrl-") {
+		// Ctrl-M and others share their codes with named keys
+		letter := strings.ToUpper(name[5:])[0]
+		if letter >= 'A' && letter <= 'Z' {
+			b.key = tcell.KeyCtrlA + tcell.Key(letter-'A')
+			return b, true
+		}
+	}
+	for key, keyName := range tcell.KeyNames {
+		if strings.EqualFold(keyName, name) {
+			b.key = key
+			return b, true
+		}
+	}
+	r, size := utf8.DecodeRuneInString(name)
+	if size > 0 && size == len(name) && r != utf8.RuneError && b.alt {
+		b.key = tcell.KeyRune
+		b.ch = r
+		return b, true
+	}
+	return b, false
+}
+
+// lookup returns the command bound to the key of ev, if there is one
+func (km keymap) lookup(ev *tcell.EventKey) (string, bool) {
+	alt := ev.Modifiers()&tcell.ModAlt != 0
+	for _, b := range km {
+		if b.key != ev.Key() || b.alt != alt {
+			continue
+		}
+		if b.key == tcell.KeyRune && b.ch != ev.Rune() {
+			continue
+		}
+		return b.cmd, true
+	}
+	return "", false
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name string
+		want keyBinding
+		ok   bool
+	}{
+		{name: "F2", want: keyBinding{key: tcell.KeyF2}, ok: true},
+		{name: "f12", want: keyBinding{key: tcell.KeyF12}, ok: true},
+		{name: "PgUp", want: keyBinding{key: tcell.KeyPgUp}, ok: true},
+		{name: "Ctrl-A", want: keyBinding{key: tcell.KeyCtrlA}, ok: true},
+		{name: "Ctrl+b", want: keyBinding{key: tcell.KeyCtrlB}, ok: true},
+		{name: "Ctrl-Z", want: keyBinding{key: tcell.KeyCtrlZ}, ok: true},
+		{
+			name: "Alt+F3",
+			want: keyBinding{key: tcell.KeyF3, alt: true},
+			ok:   true,
+		},
+		{
+			name: "Alt+x",
+			want: keyBinding{key: tcell.KeyRune, ch: 'x', alt: true},
+			ok:   true,
+		},
+		{
+			name: "Alt+é",
+			want: keyBinding{key: tcell.KeyRune, ch: 'é', alt: true},
+			ok:   true,
+		},
+		// Letters alone are typed into the input
+		{name: "x"},
+		{name: ""},
+		{name: "Alt+"},
+		{name: "Alt+xy"},
+		{name: "Alt+\xff"},
+		{name: "Ctrl-1"},
+		{name: "Ctrl-"},
+		{name: "F99"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseKey(tt.name)
+			if ok != tt.ok || (ok && got != tt.want) {
+				t.Errorf("got %+v %v, want %+v %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
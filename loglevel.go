@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// logLevel is how much a logged line matters, lines below the one set with
+// /loglevel aren't shown in the full screen interface. The log file always
+// has them all
+type logLevel int32
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = map[logLevel]string{
+	levelDebug: "debug",
+	levelInfo:  "info",
+	levelWarn:  "warn",
+	levelError: "error",
+}
+
+// Prefixes of the lines logged with logDebug, logWarn and logError
+const (
+	debugPrefix = "debug: "
+	warnPrefix  = "warning: "
+	errorPrefix = "error: "
+)
+
+// shownLevel is the lowest level shown, info unless set with /loglevel
+var shownLevel = int32(levelInfo)
+
+// logDebug logs the technical details only wanted when something is wrong,
+// like ICE and signaling events
+func logDebug(v ...interface{}) {
+	log.Output(2, debugPrefix+fmt.Sprintln(v...))
+}
+
+// logWarn logs what may go wrong, but hasn't yet
+func logWarn(v ...interface{}) {
+	log.Output(2, warnPrefix+fmt.Sprintln(v...))
+}
+
+// logError logs what went wrong
+func logError(v ...interface{}) {
+	log.Output(2, errorPrefix+fmt.Sprintln(v...))
+}
+
+// lineLevel tells the level of a logged line. Lines logged with log itself
+// are informative
+func lineLevel(line string) logLevel {
+	switch {
+	case strings.HasPrefix(line, debugPrefix),
+		strings.HasPrefix(line, "[Pion Debug]"),
+		strings.HasPrefix(line, "[Pion Info]"):
+		return levelDebug
+	case strings.HasPrefix(line, warnPrefix),
+		strings.HasPrefix(line, "[Pion Warn]"):
+		return levelWarn
+	case strings.HasPrefix(line, errorPrefix),
+		strings.HasPrefix(line, "[Pion Error]"):
+		return levelError
+	}
+	return levelInfo
+}
+
+// setLogLevel shows the lines of level name and above, or tells the level
+// shown without a name
+func setLogLevel(name string) {
+	if name == "" {
+		log.Println("showing", levelNames[logLevel(atomic.LoadInt32(&shownLevel))],
+			"and above")
+		return
+	}
+	for level, n := range levelNames {
+		if n == name {
+			atomic.StoreInt32(&shownLevel, int32(level))
+			log.Println("showing", name, "and above")
+			return
+		}
+	}
+	log.Println("log level must be debug, info, warn or error")
+}
+
+// levelFilter writes to w only the lines whose level keep takes
+type levelFilter struct {
+	w    io.Writer
+	keep func(level logLevel) bool
+}
+
+// shownLevels takes the levels set with /loglevel
+func shownLevels(level logLevel) bool {
+	return level >= logLevel(atomic.LoadInt32(&shownLevel))
+}
+
+// debugLevel takes only the debug lines
+func debugLevel(level logLevel) bool {
+	return level == levelDebug
+}
+
+func (f levelFilter) Write(p []byte) (int, error) {
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(string(p), "\n") {
+		if line != "" && f.keep(lineLevel(line)) {
+			b.WriteString(line)
+		}
+	}
+	if b.Len() > 0 {
+		if _, err := io.WriteString(f.w, b.String()); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/Yaroslav-95/wrtcion/gst"
+)
+
+// addToMixer gives a call its own channel in the mixer, creating the mixer
+// the first time
+func (peer *RTCPeer) addToMixer() string {
+	peer.mixerMu.Lock()
+	defer peer.mixerMu.Unlock()
+	if peer.mixer == nil {
+		peer.mixer = gst.CreateMixer()
+		peer.mixer.Start()
+	}
+	peer.mixerChannels++
+	channel := fmt.Sprintf("call%d", peer.mixerChannels)
+	peer.mixer.Add(channel)
+	return channel
+}
+
+func (peer *RTCPeer) removeFromMixer(channel string) {
+	peer.mixerMu.Lock()
+	defer peer.mixerMu.Unlock()
+	if peer.mixer != nil {
+		peer.mixer.Remove(channel)
+	}
+}
+
+// sendingAudio tells whether the audio captured should be sent to the
+// remote right now. With several calls at once, only the focused one gets it
+// unless the microphone is broadcast to all of them
+func (conn *Connection) sendingAudio() bool {
+	if conn.muted || conn.held || conn.asleep {
+		return false
+	}
+	return conn.local.BroadcastMic || conn.local.focus == conn.remoteAddr
+}
+
+// takeFocus gives conn the focus if no other call has it
+func (conn *Connection) takeFocus() {
+	if conn.mode == TextConnection {
+		return
+	}
+	if _, ok := conn.local.Connections[conn.local.focus]; ok {
+		if conn.local.focus != conn.remoteAddr && !conn.local.BroadcastMic {
+			log.Println("your microphone stays with", conn.local.focus,
+				"use /focus", conn, "to talk to", conn)
+		}
+		return
+	}
+	conn.local.focus = conn.remoteAddr
+}
+
+// passFocus hands the focus of a call that is ending over to another one
+func (conn *Connection) passFocus() {
+	peer := conn.local
+	if peer.focus != conn.remoteAddr {
+		return
+	}
+	peer.focus = ""
+	var remotes []string
+	for remote, other := range peer.Connections {
+		if other != conn && other.mode != TextConnection &&
+			other.state == InCall {
+			remotes = append(remotes, remote)
+		}
+	}
+	if len(remotes) == 0 {
+		return
+	}
+	sort.Strings(remotes)
+	peer.focus = remotes[0]
+	log.Println("your microphone now goes to", peer.focus)
+}
+
+// Focus sends the microphone to the call with remote only
+func (peer *RTCPeer) Focus(remote string) {
+	conn, ok := peer.Connections[remote]
+	if !ok {
+		log.Println("not connected to", remote)
+		return
+	}
+	if conn.mode == TextConnection {
+		log.Println("there is no call with", remote)
+		return
+	}
+	peer.focus = remote
+	log.Println("your microphone now goes to", remote)
+	if peer.BroadcastMic {
+		log.Println("but it is still broadcast to every call, see -broadcast-mic")
+	}
+}
+
+// SetSink plays the audio of the calls with remote to the output device
+// named sink, or to the default output if sink is empty. It applies from the
+// next call on
+func (peer *RTCPeer) SetSink(remote, sink string) {
+	if sink == "" {
+		delete(peer.Sinks, remote)
+		log.Println("calls with", remote, "play to the default output")
+		return
+	}
+	peer.Sinks[remote] = sink
+	log.Println("calls with", remote, "play to", sink)
+}
@@ -0,0 +1,26 @@
+package main
+
+import "github.com/Yaroslav-95/wrtcion/gst"
+
+const (
+	// Bitrate per channel of music calls, in bits per second
+	musicBitrate = 96000
+	// Frame size of music calls in milliseconds, shorter frames mean less
+	// latency at the cost of some bandwidth
+	musicFrameSize = 10
+)
+
+// musicAudio adapts opts for music, for remote jamming or lessons: no DTX,
+// which cuts quiet passages, a higher bitrate and shorter frames. Explicitly
+// higher bitrates and shorter frames are kept
+func musicAudio(opts gst.OpusOptions) gst.OpusOptions {
+	opts.Music = true
+	opts.DTX = false
+	if opts.Bitrate < musicBitrate*opts.Channels() {
+		opts.Bitrate = musicBitrate * opts.Channels()
+	}
+	if opts.FrameSize > musicFrameSize {
+		opts.FrameSize = musicFrameSize
+	}
+	return opts
+}
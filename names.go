@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"unicode"
+)
+
+// Longest display name taken from a peer, in runes
+const maxNameLength = 64
+
+// peerName cleans up a display name sent by a peer, dropping what could mess
+// up the screen and cutting it to maxNameLength
+func peerName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if runes := []rune(name); len(runes) > maxNameLength {
+		name = string(runes[:maxNameLength])
+	}
+	return name
+}
+
+// selfDeclared marks a name a peer gave itself, so that it can't pass for
+// the name of a contact
+func selfDeclared(name string) string {
+	return "~" + name
+}
+
+// setRemoteName records the display name the remote goes by, telling when
+// it changes
+func (conn *Connection) setRemoteName(name string) {
+	name = peerName(name)
+	if name == "" || name == conn.remoteName {
+		return
+	}
+	if conn.remoteName != "" {
+		log.Println(selfDeclared(conn.remoteName), "is now known as",
+			selfDeclared(name))
+	}
+	conn.remoteName = name
+	refreshStatus()
+}
+
+// sendHello tells the remote our display name once the data channel is
+// open. The signal already carries it, but not through a transfer or to
+// those who called before it was set
+func (conn *Connection) sendHello() {
+	if conn.local.Name == "" {
+		return
+	}
+	err := conn.sendControl(ControlMessage{
+		Action: Hello,
+		Name:   conn.local.Name,
+	})
+	if err != nil && err != errUnsupported {
+		logError("couldn't tell", conn, "our name:", err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How often the addresses of the interfaces are checked
+const netCheckInterval = 3 * time.Second
+
+// localAddrs returns the addresses of the interfaces that are up, except
+// the loopback ones
+func localAddrs() map[string]bool {
+	addrs := make(map[string]bool)
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return addrs
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		ifaddrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range ifaddrs {
+			addrs[iface.Name+" "+addr.String()] = true
+		}
+	}
+	return addrs
+}
+
+// addrsDiff returns the addresses in a that aren't in b, sorted
+func addrsDiff(a, b map[string]bool) []string {
+	var diff []string
+	for addr := range a {
+		if !b[addr] {
+			diff = append(diff, addr)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+// watchNetwork restarts ICE on every call whenever the addresses of the
+// interfaces change, e.g. moving from Wi-Fi to Ethernet or bringing a VPN
+// up, so that the calls move over to the new paths instead of dying with
+// the old ones
+func (peer *RTCPeer) watchNetwork() {
+	last := localAddrs()
+	ticker := time.NewTicker(netCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		addrs := localAddrs()
+		added, removed := addrsDiff(addrs, last), addrsDiff(last, addrs)
+		last = addrs
+		if len(added) == 0 && len(removed) == 0 {
+			continue
+		}
+		if len(added) > 0 {
+			logDebug("new addresses:", strings.Join(added, ", "))
+		}
+		if len(removed) > 0 {
+			logDebug("lost addresses:", strings.Join(removed, ", "))
+		}
+		peer.roam()
+	}
+}
+
+// roam restarts ICE on the calls that aren't already reconnecting
+func (peer *RTCPeer) roam() {
+	for _, conn := range peer.Connections {
+		if conn.state != InCall || conn.restarting || conn.asleep {
+			continue
+		}
+		go conn.restartICE("the network changed")
+	}
+}
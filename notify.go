@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// The events announced, as named in the config file
+const (
+	eventCall    = "call"
+	eventMessage = "message"
+)
+
+// How events are announced
+const (
+	notifyBell    = "bell"
+	notifyDesktop = "desktop"
+	notifyBoth    = "both"
+	notifyNone    = "none"
+)
+
+// The terminal can't tell us whether it has the focus, so nobody having
+// pressed a key for this long is taken as nobody looking
+const awayAfter = time.Minute
+
+// defaultNotify is how events are announced unless the config file says
+// otherwise
+var defaultNotify = map[string]string{
+	eventCall:    notifyBoth,
+	eventMessage: notifyBell,
+}
+
+// notifier announces incoming calls and messages in the full screen
+// interface, nil elsewhere
+var notifier *attention
+
+// attention rings the terminal bell and sends desktop notifications, when
+// the input doesn't have the focus or nobody has used the keyboard for a
+// while
+type attention struct {
+	tapp  *tview.Application
+	input tview.Primitive
+	modes map[string]string
+
+	mu      sync.Mutex
+	screen  tcell.Screen
+	lastKey time.Time
+}
+
+// newAttention announces events as set by modes over defaultNotify
+func newAttention(
+	tapp *tview.Application,
+	input tview.Primitive,
+	modes map[string]string,
+) *attention {
+	a := &attention{
+		tapp:    tapp,
+		input:   input,
+		modes:   make(map[string]string),
+		lastKey: time.Now(),
+	}
+	for event, mode := range defaultNotify {
+		a.modes[event] = mode
+	}
+	for event, mode := range modes {
+		switch mode {
+		case notifyBell, notifyDesktop, notifyBoth, notifyNone:
+			a.modes[event] = mode
+		default:
+			logWarn("unknown notification", mode, "for", event)
+		}
+	}
+	tapp.SetBeforeDrawFunc(func(screen tcell.Screen) bool {
+		a.mu.Lock()
+		a.screen = screen
+		a.mu.Unlock()
+		return false
+	})
+	return a
+}
+
+// touch records that a key was pressed
+func (a *attention) touch() {
+	a.mu.Lock()
+	a.lastKey = time.Now()
+	a.mu.Unlock()
+}
+
+// away tells whether the user seems to be looking elsewhere
+func (a *attention) away() bool {
+	a.mu.Lock()
+	idle := time.Since(a.lastKey)
+	a.mu.Unlock()
+	return idle > awayAfter || a.tapp.GetFocus() != a.input
+}
+
+// notify announces event, described by title and body, if nobody seems to
+// be looking
+func notify(event, title, body string) {
+	a := notifier
+	if a == nil || !a.away() {
+		return
+	}
+	mode := a.modes[event]
+	if mode == notifyBell || mode == notifyBoth {
+		a.mu.Lock()
+		screen := a.screen
+		a.mu.Unlock()
+		if screen != nil {
+			screen.Beep()
+		}
+	}
+	if mode == notifyDesktop || mode == notifyBoth {
+		cmd := exec.Command("notify-send", "--app-name=wrtcion", "--", title, body)
+		if err := cmd.Start(); err != nil {
+			logError("couldn't send desktop notification:", err)
+			return
+		}
+		go cmd.Wait()
+	}
+}
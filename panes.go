@@ -0,0 +1,469 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// The pane of everything that isn't a chat with a peer, and the one of the
+// technical log toggled with /debuglog
+const (
+	systemPane = "system"
+	debugPane  = "debug"
+)
+
+// chatPanes are the conversation panes of the full screen interface, nil
+// elsewhere
+var chatPanes *panes
+
+// panes shows the chat with every peer in its own page, and the log in the
+// system one. Tab and Shift-Tab switch between them
+type panes struct {
+	tapp   *tview.Application
+	flog   io.Writer
+	pages  *tview.Pages
+	tabbar *tview.TextView
+	theme  theme
+	// input gets the focus back when a pane is clicked
+	input tview.Primitive
+	// timeFmt and dayFmt stamp the lines of the chats
+	timeFmt string
+	dayFmt  string
+	// debug is the pane of the technical log, in order only while shown
+	debug *pane
+
+	mu    sync.Mutex
+	panes map[string]*pane
+	order []string
+	// current is the index in order of the pane shown
+	current int
+}
+
+type pane struct {
+	view *tview.TextView
+	out  *log.Logger
+	// unread counts the messages that came while the pane wasn't shown
+	unread int
+	// scrolled is set while the pane doesn't follow new lines
+	scrolled bool
+	// footer tells whether the peer is writing a message, in the panes of
+	// peers only
+	footer *tview.TextView
+}
+
+// newPanes creates the panes with only the system one, showing system. The
+// chats are colored by th, and clicking the panes leaves the focus on input
+func newPanes(
+	tapp *tview.Application,
+	flog io.Writer,
+	system *tview.TextView,
+	th theme,
+	input tview.Primitive,
+) *panes {
+	p := &panes{
+		tapp:  tapp,
+		flog:  flog,
+		pages: tview.NewPages(),
+		tabbar: tview.NewTextView().
+			SetDynamicColors(true).
+			SetRegions(true),
+		theme: th,
+		input: input,
+		panes: map[string]*pane{systemPane: {view: system}},
+		order: []string{systemPane},
+	}
+	system.SetMaxLines(maxScrollback)
+	p.keepFocus(system)
+	p.debug = &pane{view: tview.NewTextView().SetMaxLines(maxScrollback)}
+	p.debug.view.SetTitle(debugPane)
+	p.keepFocus(p.debug.view)
+	p.panes[debugPane] = p.debug
+	p.keepFocus(p.tabbar)
+	// Clicking the name of a pane shows it
+	p.tabbar.SetHighlightedFunc(func(added, _, _ []string) {
+		if len(added) == 0 {
+			return
+		}
+		var i int
+		if _, err := fmt.Sscanf(added[0], "tab%d", &i); err == nil {
+			p.show(i)
+		}
+		p.tabbar.Highlight()
+	})
+	// The wheel scrolls the pane shown
+	p.pages.SetMouseCapture(func(
+		action tview.MouseAction,
+		event *tcell.EventMouse,
+	) (tview.MouseAction, *tcell.EventMouse) {
+		switch action {
+		case tview.MouseScrollUp:
+			p.wheel(-wheelLines)
+		case tview.MouseScrollDown:
+			p.wheel(wheelLines)
+		default:
+			return action, event
+		}
+		return action, nil
+	})
+	p.pages.AddPage(systemPane, system, true, true)
+	p.refreshTabs()
+	return p
+}
+
+// keepFocus gives the focus back to the input whenever view gets it from a
+// click
+func (p *panes) keepFocus(view *tview.TextView) {
+	view.SetFocusFunc(func() {
+		p.tapp.SetFocus(p.input)
+	})
+}
+
+// viewWriter returns a writer that shows what is written in view, redrawing
+// as set by -slow-tty and -reduced-motion
+func viewWriter(tapp *tview.Application, view *tview.TextView) io.Writer {
+	if isSlowTTY(*slow) {
+		// Lines are written in batches, each followed by a single redraw
+		return newBatchWriter(tapp, view, slowTTYInterval)
+	}
+	if *calm {
+		view.SetChangedFunc(throttledDraw(tapp, reducedMotionInterval))
+	} else {
+		view.SetChangedFunc(func() {
+			tapp.Draw()
+		})
+	}
+	return view
+}
+
+// write shows line in the pane of remote, creating it the first time. A
+// message is counted as unread until the pane is shown
+func (p *panes) write(remote, line string, message bool) {
+	p.mu.Lock()
+	pn, ok := p.panes[remote]
+	if !ok {
+		view := tview.NewTextView().
+			SetMaxLines(maxScrollback).
+			SetDynamicColors(true).
+			SetRegions(true)
+		view.SetTitle(remote)
+		p.keepFocus(view)
+		footer := tview.NewTextView()
+		p.keepFocus(footer)
+		layout := tview.NewFlex().
+			SetDirection(tview.FlexRow).
+			AddItem(view, 0, 1, false).
+			AddItem(footer, 1, 0, false)
+		pn = &pane{
+			view:   view,
+			footer: footer,
+			out: log.New(
+				io.MultiWriter(p.flog, styledWriter{
+					w: newStampedWriter(viewWriter(p.tapp, view),
+						p.timeFmt, p.dayFmt),
+					color: p.theme.chatColor(remote),
+				}),
+				"",
+				0,
+			),
+		}
+		p.panes[remote] = pn
+		p.order = append(p.order, remote)
+		// Our own messages are written from the event loop, which can't
+		// wait for itself
+		go p.tapp.QueueUpdateDraw(func() {
+			p.pages.AddPage(remote, layout, true, false)
+		})
+	}
+	if message && p.order[p.current] != remote {
+		pn.unread++
+	}
+	p.mu.Unlock()
+	pn.out.Println(line)
+	go p.tapp.QueueUpdateDraw(p.refreshTabs)
+}
+
+// refreshTabs shows the name of every pane, the current one highlighted and
+// those with new lines marked
+func (p *panes) refreshTabs() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var b strings.Builder
+	for i, name := range p.order {
+		label := tview.Escape(name)
+		if n := p.panes[name].unread; n > 0 {
+			label += fmt.Sprintf(" (%d)", n)
+		}
+		if i == p.current {
+			fmt.Fprintf(&b, `["tab%d"][::r] %s [::-][""] `, i, label)
+		} else {
+			fmt.Fprintf(&b, `["tab%d"] %s [""]  `, i, label)
+		}
+	}
+	if pn := p.panes[p.order[p.current]]; pn.scrolled {
+		row, _ := pn.view.GetScrollOffset()
+		fmt.Fprintf(&b, "  [yellow]line %d, End to follow[-]", row+1)
+	}
+	p.tabbar.SetText(b.String())
+}
+
+// scroll moves the current pane through its scrollback for PgUp, PgDn, Home
+// and End, telling whether key was one of them. It has to be called from the
+// event loop
+func (p *panes) scroll(key tcell.Key) bool {
+	p.mu.Lock()
+	pn := p.panes[p.order[p.current]]
+	p.mu.Unlock()
+	view := pn.view
+	_, _, _, height := view.GetInnerRect()
+	switch key {
+	case tcell.KeyPgUp:
+		pn.scrollBy(-height)
+	case tcell.KeyPgDn:
+		pn.scrollBy(height)
+	case tcell.KeyHome:
+		view.ScrollToBeginning()
+		pn.scrolled = true
+	case tcell.KeyEnd:
+		view.ScrollToEnd()
+		pn.scrolled = false
+	default:
+		return false
+	}
+	p.refreshTabs()
+	return true
+}
+
+// wheel scrolls the current pane by lines, back if negative. It has to be
+// called from the event loop
+func (p *panes) wheel(lines int) {
+	p.mu.Lock()
+	pn := p.panes[p.order[p.current]]
+	p.mu.Unlock()
+	pn.scrollBy(lines)
+	p.refreshTabs()
+}
+
+// scrollBy scrolls the pane by lines, back if negative, following new lines
+// again once it reaches the end
+func (pn *pane) scrollBy(lines int) {
+	view := pn.view
+	_, _, _, height := view.GetInnerRect()
+	row, _ := view.GetScrollOffset()
+	row += lines
+	if lines > 0 && row+height >= strings.Count(view.GetText(false), "\n") {
+		view.ScrollToEnd()
+		pn.scrolled = false
+		return
+	}
+	if row < 0 {
+		row = 0
+	}
+	view.ScrollTo(row, 0)
+	pn.scrolled = true
+}
+
+// cycle shows the pane delta places after the current one. It has to be
+// called from the event loop
+func (p *panes) cycle(delta int) {
+	p.mu.Lock()
+	i := (p.current + delta + len(p.order)) % len(p.order)
+	p.mu.Unlock()
+	p.show(i)
+}
+
+// show shows the pane at index i of order. It has to be called from the
+// event loop
+func (p *panes) show(i int) {
+	p.mu.Lock()
+	if i < 0 || i >= len(p.order) {
+		p.mu.Unlock()
+		return
+	}
+	p.current = i
+	name := p.order[p.current]
+	p.panes[name].unread = 0
+	p.mu.Unlock()
+	p.pages.SwitchToPage(name)
+	p.refreshTabs()
+}
+
+// toggleDebug shows the debug pane after the system one, or takes it away
+func (p *panes) toggleDebug() {
+	// It is run as a command, often from the event loop itself
+	go p.tapp.QueueUpdateDraw(func() {
+		p.mu.Lock()
+		for i, name := range p.order {
+			if name != debugPane {
+				continue
+			}
+			p.order = append(p.order[:i], p.order[i+1:]...)
+			if p.current >= i && p.current > 0 {
+				p.current--
+			}
+			current := p.order[p.current]
+			p.mu.Unlock()
+			p.pages.RemovePage(debugPane)
+			p.pages.SwitchToPage(current)
+			p.refreshTabs()
+			return
+		}
+		p.order = append(p.order[:1],
+			append([]string{debugPane}, p.order[1:]...)...)
+		p.mu.Unlock()
+		p.pages.AddPage(debugPane, p.debug.view, true, false)
+		p.show(1)
+	})
+}
+
+// Region of the line a search jumped to
+const matchRegion = "match"
+
+// jump shows the pane of the latest of matches still in the scrollback,
+// scrolled to it with the message highlighted
+func (p *panes) jump(matches []ChatEntry) {
+	// Commands may run in the event loop, which can't wait for itself
+	go p.tapp.QueueUpdateDraw(func() {
+		for i := len(matches) - 1; i >= 0; i-- {
+			body := strings.SplitN(matches[i].Body, "\n", 2)[0]
+			if p.mark(matches[i].Peer, body) {
+				return
+			}
+		}
+		log.Println("those messages are no longer in the scrollback")
+	})
+}
+
+// mark highlights the last line of the pane of remote that has text and
+// shows it there, telling whether there is one. It has to be called from
+// the event loop
+func (p *panes) mark(remote, text string) bool {
+	p.mu.Lock()
+	pn, ok := p.panes[remote]
+	index := -1
+	for i, name := range p.order {
+		if name == remote {
+			index = i
+		}
+	}
+	p.mu.Unlock()
+	if !ok || pn.footer == nil || index < 0 {
+		return false
+	}
+	// Lines were escaped when written, and an earlier match is unmarked
+	content := strings.TrimRight(pn.view.GetText(false), "\n")
+	if start := strings.Index(content, `["`+matchRegion+`"]`); start >= 0 {
+		content = content[:start] + content[start+len(matchRegion)+4:]
+		if end := strings.Index(content[start:], `[""]`); end >= 0 {
+			content = content[:start+end] + content[start+end+4:]
+		}
+	}
+	needle := tview.Escape(text)
+	lines := strings.Split(content, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if !strings.Contains(lines[i], needle) {
+			continue
+		}
+		lines[i] = `["` + matchRegion + `"]` + lines[i] + `[""]`
+		pn.view.SetText(strings.Join(lines, "\n") + "\n")
+		pn.view.Highlight(matchRegion).ScrollToHighlight()
+		pn.scrolled = true
+		p.show(index)
+		return true
+	}
+	return false
+}
+
+// currentPeer returns the peer whose pane is shown, empty for the system and
+// debug ones
+func (p *panes) currentPeer() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if name := p.order[p.current]; name != systemPane && name != debugPane {
+		return name
+	}
+	return ""
+}
+
+// chatLine shows a line of the chat with remote, in its pane in the full
+// screen interface and in the log elsewhere
+func chatLine(remote, line string) {
+	if chatPanes == nil {
+		log.Println(line)
+		return
+	}
+	chatPanes.write(remote, line, false)
+}
+
+// messageLine shows a message from remote like chatLine, counting it as
+// unread until its pane is shown
+func messageLine(remote, line string) {
+	if chatPanes == nil {
+		log.Println(line)
+		return
+	}
+	chatPanes.write(remote, line, true)
+}
+
+// unreadCount is how many messages from remote haven't been seen in its
+// pane
+func (p *panes) unreadCount(remote string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pn, ok := p.panes[remote]; ok {
+		return pn.unread
+	}
+	return 0
+}
+
+// updateTyping keeps the footer of the pane of every peer telling whether
+// typing says it is writing a message, checking every interval
+func updateTyping(
+	tapp *tview.Application,
+	p *panes,
+	typing func(remote string) bool,
+	interval time.Duration,
+) {
+	shown := make(map[string]bool)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		footers := make(map[string]*tview.TextView)
+		p.mu.Lock()
+		for remote, pn := range p.panes {
+			if pn.footer != nil {
+				footers[remote] = pn.footer
+			}
+		}
+		p.mu.Unlock()
+		for remote, footer := range footers {
+			now := typing(remote)
+			if now == shown[remote] {
+				continue
+			}
+			shown[remote] = now
+			text := ""
+			if now {
+				text = typingIcon
+			}
+			footer := footer
+			tapp.QueueUpdateDraw(func() {
+				footer.SetText(text)
+			})
+		}
+	}
+}
+
+// echoLine shows a message we sent to remote in its pane. Elsewhere what we
+// enter is already echoed
+func echoLine(remote, line string) {
+	if chatPanes != nil {
+		chatPanes.write(remote, line, false)
+	}
+}
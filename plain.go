@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// plainMain runs wrtcion without the TUI: commands are read line by line from
+// stdin and every event is written to stdout as a single line of plain text,
+// without timestamps or any other decoration. Screen readers and braille
+// displays cope with this far better than with a full screen interface
+func plainMain(rtcpeer *RTCPeer, flog io.Writer) {
+	log.SetOutput(io.MultiWriter(flog, os.Stdout))
+	log.SetFlags(0)
+	go rtcpeer.Listen()
+	log.Println("wrtcion ready, type /help for a list of commands")
+
+	// Commands from the socket run one at a time with the typed ones
+	var mu sync.Mutex
+	go serveCommands(func(cmd string) {
+		mu.Lock()
+		defer mu.Unlock()
+		parseCommand(cmd, rtcpeer, func() { os.Exit(0) })
+	})
+
+	quit := false
+	scanner := bufio.NewScanner(os.Stdin)
+	for !quit && scanner.Scan() {
+		if scanner.Text() == "" {
+			continue
+		}
+		mu.Lock()
+		parseCommand(scanner.Text(), rtcpeer, func() { quit = true })
+		mu.Unlock()
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+)
+
+const prefsPath = outputPath + "links.json"
+
+// Calls losing at least this share of the audio make the encoder expect
+// loss with the same peer next time
+const lossyLink = 0.05
+
+// LinkPrefs are the settings that worked with a peer in the previous calls,
+// applied to the next ones unless others are given explicitly. There is a
+// single audio and a single video codec, so only their quality is learned
+type LinkPrefs struct {
+	// VideoBitrate caps the video sent, the link couldn't take more
+	VideoBitrate int `json:",omitempty"`
+	// PacketLoss is the loss percentage the Opus encoder is told to expect,
+	// with FEC enabled to make up for it
+	PacketLoss int `json:",omitempty"`
+	Updated    time.Time
+}
+
+func (peer *RTCPeer) loadPrefs() {
+	data, err := os.ReadFile(prefsPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logError("couldn't read link preferences:", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &peer.prefs); err != nil {
+		logError("couldn't parse link preferences:", err)
+	}
+}
+
+func (peer *RTCPeer) savePrefs() {
+	data, err := json.MarshalIndent(peer.prefs, "", "\t")
+	if err == nil {
+		err = os.MkdirAll(outputPath, 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(prefsPath, data, 0644)
+	}
+	if err != nil {
+		logError("couldn't save link preferences:", err)
+	}
+}
+
+// applyPrefs returns settings with what was learned about the link to
+// remote, for whatever wasn't set explicitly
+func (peer *RTCPeer) applyPrefs(remote string, settings CallSettings) CallSettings {
+	prefs, ok := peer.prefs[remote]
+	if !ok {
+		return settings
+	}
+	if prefs.VideoBitrate > 0 && settings.Video.Bitrate == 0 {
+		settings.Video.Bitrate = prefs.VideoBitrate
+		log.Printf("capping video to %s at %d kbps, as in the last calls\n",
+			remote, prefs.VideoBitrate/1000)
+	}
+	if prefs.PacketLoss > settings.Audio.PacketLoss {
+		settings.Audio.InbandFEC = true
+		settings.Audio.PacketLoss = prefs.PacketLoss
+		log.Printf("expecting %d%% loss to %s, as in the last calls\n",
+			prefs.PacketLoss, remote)
+	}
+	return settings
+}
+
+// learnPrefs remembers how the call that is ending coped with the link, so
+// that the next call to the same peer starts off from there
+func (conn *Connection) learnPrefs() {
+	if conn.started.IsZero() {
+		return
+	}
+	prefs := conn.local.prefs[conn.remoteAddr]
+	learned := false
+	if conn.videoSndr != nil && conn.autoVideo {
+		bitrate, policy := conn.priority.settled()
+		if policy == VideoPaused {
+			bitrate = minVideoBitrate
+		}
+		if policy != VideoFull && (prefs.VideoBitrate == 0 ||
+			bitrate < prefs.VideoBitrate) {
+			prefs.VideoBitrate = bitrate
+			learned = true
+		}
+	}
+	stats := conn.Stats()
+	if conn.audioSndr != nil && stats.FractionLost >= lossyLink {
+		loss := int(stats.FractionLost * 100)
+		if loss > prefs.PacketLoss {
+			prefs.PacketLoss = loss
+			learned = true
+		}
+	}
+	if !learned {
+		return
+	}
+	prefs.Updated = time.Now().UTC()
+	conn.local.prefs[conn.remoteAddr] = prefs
+	conn.local.savePrefs()
+}
+
+// Forget drops what was learned about the link to remote
+func (peer *RTCPeer) Forget(remote string) {
+	if _, ok := peer.prefs[remote]; !ok {
+		log.Println("nothing learned about", remote)
+		return
+	}
+	delete(peer.prefs, remote)
+	peer.savePrefs()
+	log.Println("forgot the settings learned with", remote)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// How often contacts are probed
+	presenceInterval = 30 * time.Second
+	// How long a contact has to answer a probe
+	presenceTimeout = 3 * time.Second
+)
+
+// presence is whether each contact answered its last probe
+type presence struct {
+	mu     sync.Mutex
+	online map[string]bool
+}
+
+func (p *presence) set(addr string, online bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.online[addr] = online
+}
+
+// get returns whether addr is online, and whether it was probed at all
+func (p *presence) get(addr string) (online, probed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	online, probed = p.online[addr]
+	return online, probed
+}
+
+// httpHandlePing answers the presence probes of other peers
+func (peer *RTCPeer) httpHandlePing(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "pong")
+}
+
+// probe tells whether there is a peer listening at addr
+func probe(client *http.Client, addr string) bool {
+	resp, err := client.Get(fmt.Sprintf("http://%s/ping", addr))
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// watchPresence probes every contact on an interval, logging when they come
+// online or go offline. Contacts that only get to talk to us through the
+// TURN server are left alone, a probe would tell them our address
+func (peer *RTCPeer) watchPresence() {
+	client := &http.Client{Timeout: presenceTimeout}
+	ticker := time.NewTicker(presenceInterval)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		for _, c := range peer.contacts {
+			if peer.relayOnly(c.Address) {
+				continue
+			}
+			online := probe(client, c.Address)
+			was, probed := peer.presence.get(c.Address)
+			peer.presence.set(c.Address, online)
+			if !probed || was == online {
+				continue
+			}
+			if online {
+				log.Println(peer.displayName(c.Address), "is online")
+			} else {
+				log.Println(peer.displayName(c.Address), "went offline")
+			}
+		}
+	}
+}
+
+// presenceOf describes whether the peer at addr is reachable
+func (peer *RTCPeer) presenceOf(addr string) string {
+	if _, ok := peer.Connections[addr]; ok {
+		return "connected"
+	}
+	online, probed := peer.presence.get(addr)
+	switch {
+	case !probed:
+		return "unknown"
+	case online:
+		return "online"
+	}
+	return "offline"
+}
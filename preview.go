@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// How long callees see our video while our video calls ring
+const previewLength = 10 * time.Second
+
+// previews tells whether the video of the call coming in on conn is shown
+// before answering it. Both ends have to want it: it connects us to the
+// caller, revealing our address, before the call is answered
+func (peer *RTCPeer) previews(conn *Connection) bool {
+	return peer.VideoPreview && conn.mode == VideoConnectionSimplex &&
+		!conn.legacy && peer.supports(conn.remoteAddr, CapPreview)
+}
+
+// answerPreview answers the offer of a video call so that the video of the
+// caller can be shown while it rings. We send nothing in video calls, and
+// nothing else is played nor recorded until /accept
+func (conn *Connection) answerPreview(signal SignalSDP) {
+	conn.previewing = true
+	conn.completeSignal(signal)
+}
+
+// startPreview sends our video to the callee for previewLength, or shows the
+// one of the caller, once the peer connection is up
+func (conn *Connection) startPreview() {
+	if !conn.isInitiator {
+		log.Println("showing the video of",
+			conn.local.displayName(conn.remoteAddr), "before answering")
+		return
+	}
+	conn.previewAt = time.Now()
+	conn.sendVideo()
+}
+
+// previewSent tells whether the callee still sees our video before
+// answering
+func (conn *Connection) previewSent() bool {
+	return conn.previewing && conn.state == Ringing &&
+		time.Since(conn.previewAt) < previewLength
+}
+
+// receiving tells whether the media of the remote is taken, during the call
+// or while previewing it
+func (conn *Connection) receiving() bool {
+	return conn.state == InCall || conn.previewing && conn.state == Answering
+}
+
+// acceptPreview answers a call whose video was being previewed. The media
+// is already set up, the caller only has to be told to start the call
+func (conn *Connection) acceptPreview() {
+	conn.previewing = false
+	err := postSignal(conn.remoteAddr, SignalSDP{
+		Action:      Accepted,
+		Origin:      conn.local.listenAddr,
+		Fingerprint: conn.local.ownFingerprint(),
+		SignalStamp: newSignalStamp(),
+	})
+	if err != nil {
+		logError("couldn't answer", conn, ":", err)
+		conn.Close()
+		return
+	}
+	if conn.local.Record && !conn.voicemail {
+		conn.startRecording()
+	}
+	conn.startPreviewed()
+}
+
+// handlePreviewAccepted starts the call the callee was previewing, now that
+// it answered
+func (peer *RTCPeer) handlePreviewAccepted(r *http.Request, signal SignalSDP) {
+	conn, ok := peer.Connections[signal.Origin]
+	if !ok || !conn.previewing || conn.state != Ringing {
+		return
+	}
+	if !conn.sentByCallee(r, signal) {
+		logWarn("ignored an answer claiming to come from", conn)
+		return
+	}
+	conn.previewing = false
+	conn.startPreviewed()
+}
+
+// startPreviewed starts a call that was answered after its preview, right
+// away if the peer connection is already up, or once it is
+func (conn *Connection) startPreviewed() {
+	if conn.peer.ConnectionState() == webrtc.PeerConnectionStateConnected {
+		conn.connected()
+		return
+	}
+	conn.setState(InCall)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPreviews(t *testing.T) {
+	tests := []struct {
+		name   string
+		ours   bool
+		caps   []Capability
+		mode   ConnectionMode
+		legacy bool
+		want   bool
+	}{
+		{
+			name: "both want it",
+			ours: true,
+			caps: []Capability{CapVideo, CapPreview},
+			mode: VideoConnectionSimplex,
+			want: true,
+		},
+		{
+			name: "we don't",
+			caps: []Capability{CapVideo, CapPreview},
+			mode: VideoConnectionSimplex,
+		},
+		{
+			name: "the caller doesn't",
+			ours: true,
+			caps: []Capability{CapVideo},
+			mode: VideoConnectionSimplex,
+		},
+		{
+			name: "voice call",
+			ours: true,
+			caps: []Capability{CapVideo, CapPreview},
+			mode: VoiceConnectionDuplex,
+		},
+		{
+			name:   "older client",
+			ours:   true,
+			caps:   []Capability{},
+			mode:   VideoConnectionSimplex,
+			legacy: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := testConn(t, tt.caps)
+			conn.local.VideoPreview = tt.ours
+			conn.mode = tt.mode
+			conn.legacy = tt.legacy
+			if got := conn.local.previews(conn); got != tt.want {
+				t.Errorf("previews() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreviewSent(t *testing.T) {
+	tests := []struct {
+		name       string
+		previewing bool
+		state      ConnectionState
+		since      time.Duration
+		want       bool
+	}{
+		{"ringing", true, Ringing, time.Second, true},
+		{"too long", true, Ringing, previewLength + time.Second, false},
+		{"answered", false, InCall, time.Second, false},
+		{"not previewed", false, Ringing, time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := testConn(t, nil)
+			conn.previewing = tt.previewing
+			conn.state = tt.state
+			conn.previewAt = time.Now().Add(-tt.since)
+			if got := conn.previewSent(); got != tt.want {
+				t.Errorf("previewSent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/pion/rtcp"
+)
+
+// VideoPolicy is what the video sender is left with when the bandwidth to
+// the remote can't take all the media of a call
+type VideoPolicy int
+
+const (
+	VideoFull VideoPolicy = iota
+	// VideoReduced sends video below the bitrate the call started with
+	VideoReduced
+	// VideoPaused sends no video at all, only audio
+	VideoPaused
+)
+
+const (
+	// defaultAudioBitrate is the bitrate reserved for every channel of
+	// audio when the encoder is left to pick its own
+	defaultAudioBitrate = 32000
+	// Paused video is only resumed once there is this much room for it, so
+	// that it doesn't flap around minVideoBitrate
+	resumeVideoBitrate = 2 * minVideoBitrate
+)
+
+// mediaScheduler shares the bandwidth estimated for a call between its
+// tracks by priority. Audio gets what it needs first, video gets the rest:
+// under congestion video is lowered, then paused, before audio suffers, and
+// it is restored when the headroom returns. It is fed the reports about both
+// tracks, since none come about video while it is paused
+type mediaScheduler struct {
+	mu        sync.Mutex
+	ctrl      *bitrateController
+	audioSSRC uint32
+	videoSSRC uint32
+	audio     int
+	start     int
+	video     int
+	policy    VideoPolicy
+}
+
+// begin starts scheduling, with video sent at start bitrate and up to max,
+// and audio sent at audio bitrate
+func (s *mediaScheduler) begin(audioSSRC, videoSSRC uint32, audio, start, max int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ctrl = newBitrateController(audio+start, audio+minVideoBitrate/2, audio+max)
+	s.audioSSRC = audioSSRC
+	s.videoSSRC = videoSSRC
+	s.audio = audio
+	s.start = start
+	s.video = start
+	s.policy = VideoFull
+}
+
+// update feeds pkt, about the track with ssrc, to the estimate. It returns
+// the bitrate video should now be sent at, its policy, and whether either
+// changed
+func (s *mediaScheduler) update(pkt rtcp.Packet, ssrc uint32) (int, VideoPolicy, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctrl == nil {
+		return 0, VideoFull, false
+	}
+	// Raising the estimate on the reports about every track would raise it
+	// once per track, the ones about audio only do so while video is paused
+	grow := ssrc == s.videoSSRC || s.policy == VideoPaused
+	total, changed := s.ctrl.update(pkt, ssrc, grow)
+	if !changed {
+		return s.video, s.policy, false
+	}
+
+	video := total - s.audio
+	policy := VideoReduced
+	switch {
+	case video < minVideoBitrate,
+		s.policy == VideoPaused && video < resumeVideoBitrate:
+		policy = VideoPaused
+	case video >= s.start:
+		policy = VideoFull
+	}
+	changed = policy != s.policy || (policy != VideoPaused && video != s.video)
+	s.video = video
+	s.policy = policy
+	return video, policy, changed
+}
+
+// settled returns the bitrate and the policy video is being sent with
+func (s *mediaScheduler) settled() (int, VideoPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.video, s.policy
+}
+
+// Policy returns how video is being sent
+func (s *mediaScheduler) Policy() VideoPolicy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.policy
+}
+
+// String describes the policy, for /stats
+func (s *mediaScheduler) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch s.policy {
+	case VideoReduced:
+		return fmt.Sprintf("audio first, video reduced to %d kbps",
+			s.video/1000)
+	case VideoPaused:
+		return "audio first, video paused until the bandwidth returns"
+	}
+	return fmt.Sprintf("audio first, video at %d kbps", s.video/1000)
+}
+
+// audioBitrate is the bitrate reserved for the audio of the call
+func (conn *Connection) audioBitrate() int {
+	if conn.settings.Audio.Bitrate > 0 {
+		return conn.settings.Audio.Bitrate
+	}
+	return defaultAudioBitrate * conn.settings.Audio.Channels()
+}
+
+// prioritize feeds pkt, about the track with ssrc, to the scheduler and
+// applies its decision to the video sender
+func (conn *Connection) prioritize(pkt rtcp.Packet, ssrc uint32) {
+	prev := conn.priority.Policy()
+	bitrate, policy, changed := conn.priority.update(pkt, ssrc)
+	if !changed {
+		return
+	}
+	if policy == VideoPaused {
+		if prev != VideoPaused {
+			logWarn("bandwidth to", conn, "is too low, pausing video to keep the audio")
+		}
+		return
+	}
+	conn.videoSndr.pipeline.SetBitrate(bitrate)
+	if prev == VideoPaused {
+		log.Println("bandwidth to", conn, "is back, resuming video")
+		// The remote can't decode anything until the next keyframe
+		conn.videoSndr.pipeline.ForceKeyframe()
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Page size of the query endpoints when the client doesn't ask for one, and
+// the largest it can ask for
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// Page is the body of the responses of the query endpoints. Items are
+// sorted oldest first, Total counts all the items matching the filters
+type Page struct {
+	Total  int
+	Offset int
+	Items  interface{}
+}
+
+// HistoryEntry is a call record without the stats report, for listings
+type HistoryEntry struct {
+	Peer     string
+	Outgoing bool
+	Mode     ConnectionMode
+	Start    time.Time
+	End      time.Time
+	Duration time.Duration
+	Outcome  CallOutcome
+	PeerZone *TimeZone
+	Rating   int
+	Note     string
+}
+
+// paginate reads the offset and limit parameters of r, and returns the
+// bounds of the page out of total items
+func paginate(r *http.Request, total int) (int, int, error) {
+	offset, limit := 0, defaultPageSize
+	var err error
+	if v := r.URL.Query().Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, errBadParam("offset")
+		}
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			return 0, 0, errBadParam("limit")
+		}
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	return offset, end, nil
+}
+
+type errBadParam string
+
+func (e errBadParam) Error() string {
+	return "bad value for " + string(e)
+}
+
+// callFilter builds a filter out of the peer, since, until and outgoing
+// parameters of r. Times are in RFC 3339
+func callFilter(r *http.Request) (func(rec *CallRecord) bool, error) {
+	q := r.URL.Query()
+	var since, until time.Time
+	var err error
+	if v := q.Get("since"); v != "" {
+		if since, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, errBadParam("since")
+		}
+	}
+	if v := q.Get("until"); v != "" {
+		if until, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, errBadParam("until")
+		}
+	}
+	outgoing := q.Get("outgoing")
+	if outgoing != "" && outgoing != "true" && outgoing != "false" {
+		return nil, errBadParam("outgoing")
+	}
+	peer := q.Get("peer")
+	return func(rec *CallRecord) bool {
+		switch {
+		case peer != "" && rec.Peer != peer:
+		case !since.IsZero() && rec.End.Before(since):
+		case !until.IsZero() && rec.End.After(until):
+		case outgoing != "" && strconv.FormatBool(rec.Outgoing) != outgoing:
+		default:
+			return true
+		}
+		return false
+	}, nil
+}
+
+func (ctl *controlServer) query(
+	w http.ResponseWriter,
+	r *http.Request,
+	items func() (interface{}, int, error),
+) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ctl.authorized(r) {
+		http.Error(w, errUnauthorized.Error(), http.StatusUnauthorized)
+		return
+	}
+	page, total, err := items()
+	if _, ok := err.(errBadParam); ok {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	offset, _, _ := paginate(r, total)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Page{Total: total, Offset: offset, Items: page})
+}
+
+// httpHandleCalls serves the full call detail records
+func (ctl *controlServer) httpHandleCalls(w http.ResponseWriter, r *http.Request) {
+	ctl.query(w, r, func() (interface{}, int, error) {
+		keep, err := callFilter(r)
+		if err != nil {
+			return nil, 0, err
+		}
+		recs, err := readCallRecords(keep)
+		if err != nil {
+			return nil, 0, err
+		}
+		start, end, err := paginate(r, len(recs))
+		if err != nil {
+			return nil, 0, err
+		}
+		return recs[start:end], len(recs), nil
+	})
+}
+
+// httpHandleHistory serves the call records without their stats
+func (ctl *controlServer) httpHandleHistory(w http.ResponseWriter, r *http.Request) {
+	ctl.query(w, r, func() (interface{}, int, error) {
+		keep, err := callFilter(r)
+		if err != nil {
+			return nil, 0, err
+		}
+		recs, err := readCallRecords(keep)
+		if err != nil {
+			return nil, 0, err
+		}
+		start, end, err := paginate(r, len(recs))
+		if err != nil {
+			return nil, 0, err
+		}
+		entries := make([]HistoryEntry, 0, end-start)
+		for _, rec := range recs[start:end] {
+			entry := HistoryEntry{
+				Peer:     rec.Peer,
+				Outgoing: rec.Outgoing,
+				Mode:     rec.Mode,
+				Start:    rec.Start,
+				End:      rec.End,
+				Duration: rec.Duration,
+				Outcome:  rec.outcome(),
+				PeerZone: rec.PeerZone,
+				Rating:   rec.Rating,
+				Note:     rec.Note,
+			}
+			// Records saved before the duration was kept
+			if entry.Duration == 0 && !rec.Start.IsZero() {
+				entry.Duration = rec.End.Sub(rec.Start) - rec.Interrupted
+			}
+			entries = append(entries, entry)
+		}
+		return entries, len(recs), nil
+	})
+}
+
+// httpHandleContacts serves the address book, filtered by name or address
+// with the name and address parameters
+func (ctl *controlServer) httpHandleContacts(w http.ResponseWriter, r *http.Request) {
+	ctl.query(w, r, func() (interface{}, int, error) {
+		contacts, err := loadContacts()
+		if err != nil {
+			return nil, 0, err
+		}
+		name, addr := r.URL.Query().Get("name"), r.URL.Query().Get("address")
+		matching := make([]Contact, 0, len(contacts))
+		for _, c := range contacts {
+			if (name == "" || c.Name == name) &&
+				(addr == "" || c.Address == addr) {
+				matching = append(matching, c)
+			}
+		}
+		start, end, err := paginate(r, len(matching))
+		if err != nil {
+			return nil, 0, err
+		}
+		return matching[start:end], len(matching), nil
+	})
+}
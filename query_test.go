@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHistoryQuery(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+
+	start := time.Date(2021, 12, 1, 10, 0, 0, 0, time.UTC)
+	recs := []CallRecord{
+		{
+			// Cut off for ten minutes by a suspend, older versions didn't
+			// record the duration nor the outcome
+			Peer:        "alice:8080",
+			Start:       start,
+			End:         start.Add(30 * time.Minute),
+			Interrupted: 10 * time.Minute,
+		},
+		{
+			Peer:        "alice:8080",
+			Outgoing:    true,
+			Start:       start,
+			End:         start.Add(30 * time.Minute),
+			Interrupted: 10 * time.Minute,
+			Duration:    15 * time.Minute,
+			Outcome:     OutcomeAnswered,
+		},
+		{Peer: "bob:8080", End: start},
+		{Peer: "bob:8080", End: start, Outcome: OutcomeRejected},
+	}
+	for i := range recs {
+		if err := appendJSONLine(cdrPath, &recs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctl := newControlServer(nil, "secret")
+	r := httptest.NewRequest(http.MethodGet, "/history", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	ctl.httpHandleHistory(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var page struct {
+		Total int
+		Items []HistoryEntry
+	}
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		duration time.Duration
+		outcome  CallOutcome
+	}{
+		{20 * time.Minute, OutcomeAnswered},
+		{15 * time.Minute, OutcomeAnswered},
+		{0, OutcomeMissed},
+		{0, OutcomeRejected},
+	}
+	if page.Total != len(want) || len(page.Items) != len(want) {
+		t.Fatalf("got %d of %d entries, want %d", len(page.Items),
+			page.Total, len(want))
+	}
+	for i, w := range want {
+		got := page.Items[i]
+		if got.Duration != w.duration || got.Outcome != w.outcome {
+			t.Errorf("entry %d: got %v %s, want %v %s", i, got.Duration,
+				got.Outcome, w.duration, w.outcome)
+		}
+	}
+}
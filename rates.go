@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+// How often the rates shown in the status bar are recomputed at most
+const rateInterval = time.Second
+
+// rateCounter counts the RTP bytes sent and received by a connection, to
+// tell how much data a call is using
+type rateCounter struct {
+	interceptor.NoOp
+	sent     uint64
+	received uint64
+
+	mu       sync.Mutex
+	lastAt   time.Time
+	lastSent uint64
+	lastRecv uint64
+	// up and down are in kb/s
+	up   float64
+	down float64
+}
+
+// NewInterceptor lets the counter be its own factory, like dtmfInterceptor
+func (c *rateCounter) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return c, nil
+}
+
+func (c *rateCounter) BindLocalStream(
+	_ *interceptor.StreamInfo,
+	writer interceptor.RTPWriter,
+) interceptor.RTPWriter {
+	return interceptor.RTPWriterFunc(func(
+		header *rtp.Header,
+		payload []byte,
+		attributes interceptor.Attributes,
+	) (int, error) {
+		n, err := writer.Write(header, payload, attributes)
+		if err == nil {
+			atomic.AddUint64(&c.sent, uint64(header.MarshalSize()+len(payload)))
+		}
+		return n, err
+	})
+}
+
+func (c *rateCounter) BindRemoteStream(
+	_ *interceptor.StreamInfo,
+	reader interceptor.RTPReader,
+) interceptor.RTPReader {
+	return interceptor.RTPReaderFunc(func(
+		b []byte,
+		attributes interceptor.Attributes,
+	) (int, interceptor.Attributes, error) {
+		n, attributes, err := reader.Read(b, attributes)
+		if err == nil {
+			atomic.AddUint64(&c.received, uint64(n))
+		}
+		return n, attributes, err
+	})
+}
+
+// rates returns the kb/s sent and received, averaged over the last
+// rateInterval or so
+func (c *rateCounter) rates() (up, down float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	elapsed := now.Sub(c.lastAt)
+	if elapsed < rateInterval {
+		return c.up, c.down
+	}
+	sent := atomic.LoadUint64(&c.sent)
+	received := atomic.LoadUint64(&c.received)
+	if !c.lastAt.IsZero() {
+		secs := elapsed.Seconds()
+		c.up = float64(sent-c.lastSent) * 8 / 1000 / secs
+		c.down = float64(received-c.lastRecv) * 8 / 1000 / secs
+	}
+	c.lastAt = now
+	c.lastSent = sent
+	c.lastRecv = received
+	return c.up, c.down
+}
+
+// rateStatus is shown next to calls in the status bar
+func (conn *Connection) rateStatus() string {
+	up, down := conn.rates.rates()
+	status := fmt.Sprintf("↑%.0f ↓%.0f kb/s", up, down)
+	if conn.local.DataSaver {
+		status += fmt.Sprintf(" ≈%.0f MB/h", conn.mbPerHour())
+	}
+	return status
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// Markers of the last message sent to each peer in the status bar
+const (
+	sentIcon      = "·"
+	deliveredIcon = "✓"
+	readIcon      = "✓✓"
+)
+
+// DeliveryState is how far the last message sent to a peer got
+type DeliveryState int
+
+const (
+	Sent DeliveryState = iota
+	Delivered
+	Read
+)
+
+// Receipt acknowledges the delivery of the messages IDs, or that they were
+// read if Read is set
+type Receipt struct {
+	IDs  []uint64
+	Read bool
+}
+
+func (conn *Connection) sendReceipt(receipt Receipt) {
+	payload, err := json.Marshal(receipt)
+	if err == nil {
+		err = conn.sendMessage(conn.dataChan, MsgReceipt, payload)
+	}
+	if err != nil {
+		logError("couldn't send receipt to", conn, ":", err)
+	}
+}
+
+func (conn *Connection) handleReceipt(payload []byte) {
+	var receipt Receipt
+	if err := json.Unmarshal(payload, &receipt); err != nil {
+		logError("couldn't parse receipt from", conn, ":", err)
+		return
+	}
+	for _, id := range receipt.IDs {
+		if id != conn.lastSent {
+			continue
+		}
+		if receipt.Read {
+			conn.lastSentState = Read
+		} else if conn.lastSentState == Sent {
+			conn.lastSentState = Delivered
+		}
+	}
+}
+
+// markRead sends read receipts for all the messages received since the user
+// was last seen
+func (peer *RTCPeer) markRead() {
+	for _, conn := range peer.Connections {
+		if len(conn.unread) == 0 || conn.state != InCall {
+			continue
+		}
+		conn.sendReceipt(Receipt{IDs: conn.unread, Read: true})
+		conn.unread = nil
+	}
+}
+
+// deliveryIcon marks how far the last message sent to the remote of conn
+// got, empty if nothing was sent with an ID
+func (conn *Connection) deliveryIcon() string {
+	if conn.lastSent == 0 {
+		return ""
+	}
+	switch conn.lastSentState {
+	case Delivered:
+		return deliveredIcon
+	case Read:
+		return readIcon
+	}
+	return sentIcon
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Yaroslav-95/wrtcion/gst"
+)
+
+const defaultRecordingsDir = outputPath + "recordings/"
+
+// safeFileName turns name, which comes from the peer, into something that
+// can only be a file name: letters, digits, dots, dashes and underscores,
+// without "..". Colons are left out too, some filesystems don't take them
+func safeFileName(name string) string {
+	safe := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+	for strings.Contains(safe, "..") {
+		safe = strings.ReplaceAll(safe, "..", "_")
+	}
+	if safe == "" || safe == "." {
+		return "_"
+	}
+	return safe
+}
+
+// insideDir checks that path is in dir, and not somewhere else through ".."
+func insideDir(dir, path string) error {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) ||
+		filepath.IsAbs(rel) {
+		return fmt.Errorf("%s is outside of %s", path, dir)
+	}
+	return nil
+}
+
+// newRecordingFile picks the file a call with remote is recorded to, named
+// after the peer, date and time, and creates it so that no other call can
+// pick it too
+func newRecordingFile(dir, remote string, video bool) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	ext := ".opus"
+	if video {
+		ext = ".webm"
+	}
+	base := filepath.Join(dir, fmt.Sprintf(
+		"%s-%s",
+		safeFileName(remote),
+		time.Now().Format("2006-01-02-150405"),
+	))
+	f, err := createUnique(dir, base, ext)
+	if err != nil {
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// createUnique creates the file base+ext in dir, or base-2+ext and so on if
+// it is taken, never overwriting anything. It is open for reading too, files
+// received are hashed through it
+func createUnique(dir, base, ext string) (*os.File, error) {
+	if err := insideDir(dir, base); err != nil {
+		return nil, err
+	}
+	path := base + ext
+	for n := 2; ; n++ {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
+		if err == nil {
+			return f, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		path = fmt.Sprintf("%s-%d%s", base, n, ext)
+	}
+}
+
+// startRecording sets up the recorder for the media received in the call.
+// Video calls get audio and video muxed together into a single file.
+// Messages left on voicemail go to their own directory
+func (conn *Connection) startRecording() {
+	video := conn.mode == VideoConnectionSimplex
+	dir := conn.local.RecordingsDir
+	if dir == "" {
+		dir = defaultRecordingsDir
+	}
+	if conn.voicemail {
+		dir = conn.local.VoicemailDir
+		if dir == "" {
+			dir = defaultVoicemailDir
+		}
+	}
+	path, err := newRecordingFile(dir, conn.remoteAddr, video)
+	if err != nil {
+		logError("can't record call:", err)
+		return
+	}
+	rec, err := gst.CreateRecorder(path, video)
+	if err != nil {
+		logError("can't record call:", err)
+		return
+	}
+	rec.Start()
+	conn.recorder = rec
+	refreshStatus()
+	log.Println("recording call with", conn, "to", path)
+}
+
+func (conn *Connection) stopRecording() {
+	if conn.recorder == nil {
+		return
+	}
+	conn.recorder.Stop()
+	conn.recorder = nil
+	refreshStatus()
+	log.Println("recording of call with", conn, "saved")
+}
+
+// SetRecording starts or stops recording the call with remote
+func (peer *RTCPeer) SetRecording(remote string, on bool) {
+	conn, ok := peer.Connections[remote]
+	if !ok {
+		log.Println("not connected to", remote)
+		return
+	}
+	if on == (conn.recorder != nil) {
+		if on {
+			log.Println("already recording the call with", remote)
+		} else {
+			log.Println("not recording the call with", remote)
+		}
+		return
+	}
+	if on {
+		conn.startRecording()
+	} else {
+		conn.stopRecording()
+	}
+}
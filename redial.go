@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// lastCall is the latest call made or received, for /redial and /callback
+type lastCall struct {
+	Remote string
+	Mode   ConnectionMode
+	// settings are the ones the call was made with, nil for the defaults
+	settings *CallSettings
+}
+
+// loadLastCalls picks the latest calls made and received from the call
+// history, so that they can be called again after a restart
+func (peer *RTCPeer) loadLastCalls() {
+	recs, err := readCallRecords(func(rec *CallRecord) bool {
+		return true
+	})
+	if err != nil {
+		logError("couldn't read the call history:", err)
+		return
+	}
+	for _, rec := range recs {
+		call := &lastCall{Remote: rec.Peer, Mode: rec.Mode}
+		if rec.Outgoing {
+			peer.lastDialed = call
+		} else {
+			peer.lastCaller = call
+		}
+		peer.lastPeer = rec.Peer
+	}
+}
+
+// dialed remembers a call we are making
+func (peer *RTCPeer) dialed(remote string, mode ConnectionMode,
+	settings CallSettings) {
+	peer.lastDialed = &lastCall{Remote: remote, Mode: mode, settings: &settings}
+	peer.lastPeer = remote
+}
+
+// called remembers a call we are getting
+func (peer *RTCPeer) called(remote string, mode ConnectionMode) {
+	peer.lastCaller = &lastCall{Remote: remote, Mode: mode}
+	peer.lastPeer = remote
+}
+
+// Redial calls the last peer we called again, the same way
+func (peer *RTCPeer) Redial() {
+	call := peer.lastDialed
+	if call == nil {
+		log.Println("nobody to redial yet")
+		return
+	}
+	settings := peer.DefaultSettings()
+	if call.settings != nil {
+		settings = *call.settings
+	}
+	log.Println("redialing", call.Remote)
+	peer.RingWith(call.Remote, call.Mode, settings)
+}
+
+// Callback calls the last peer that called us
+func (peer *RTCPeer) Callback() {
+	call := peer.lastCaller
+	if call == nil {
+		log.Println("nobody called yet")
+		return
+	}
+	log.Println("calling back", call.Remote)
+	peer.Ring(call.Remote, call.Mode)
+}
+
+// expandShorthands replaces a line of just !! with the last command entered,
+// and !$ in a command with the address of the last peer we called or got
+// called by. Chat is left alone, it may well contain either. It returns
+// false if there is nothing to expand them to
+func (peer *RTCPeer) expandShorthands(line string) (string, bool) {
+	if strings.TrimSpace(line) == "!!" {
+		if peer.lastLine == "" {
+			log.Println("no command to repeat yet")
+			return "", false
+		}
+		line = peer.lastLine
+		log.Println(line)
+	}
+	if !strings.HasPrefix(line, "/") {
+		return line, true
+	}
+	if strings.Contains(line, "!$") {
+		if peer.lastPeer == "" {
+			log.Println("no last peer for !$ yet")
+			return "", false
+		}
+		line = strings.ReplaceAll(line, "!$", peer.lastPeer)
+	}
+	peer.lastLine = line
+	return line, true
+}
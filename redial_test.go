@@ -0,0 +1,93 @@
+package main
+
+import "testing"
+
+func TestExpandShorthands(t *testing.T) {
+	tests := []struct {
+		name     string
+		lastLine string
+		lastPeer string
+		line     string
+		want     string
+		ok       bool
+		// stored is the line !! repeats afterwards
+		stored string
+	}{
+		{
+			name:   "command",
+			line:   "/call localhost:8002",
+			want:   "/call localhost:8002",
+			ok:     true,
+			stored: "/call localhost:8002",
+		},
+		{
+			name:     "repeat",
+			lastLine: "/mute bob",
+			line:     "!!",
+			want:     "/mute bob",
+			ok:       true,
+			stored:   "/mute bob",
+		},
+		{
+			name:     "repeat with spaces",
+			lastLine: "/mute bob",
+			line:     " !! ",
+			want:     "/mute bob",
+			ok:       true,
+			stored:   "/mute bob",
+		},
+		{
+			name: "nothing to repeat",
+			line: "!!",
+		},
+		{
+			name:     "last peer",
+			lastPeer: "localhost:8002",
+			line:     "/msg !$ hi",
+			want:     "/msg localhost:8002 hi",
+			ok:       true,
+			stored:   "/msg localhost:8002 hi",
+		},
+		{
+			name:     "last peer twice",
+			lastPeer: "bob",
+			line:     "/transfer !$ !$",
+			want:     "/transfer bob bob",
+			ok:       true,
+			stored:   "/transfer bob bob",
+		},
+		{
+			name: "no last peer",
+			line: "/call !$",
+		},
+		{
+			name:     "chat is left alone",
+			lastLine: "/end bob",
+			lastPeer: "bob",
+			line:     "what !$ and !! mean",
+			want:     "what !$ and !! mean",
+			ok:       true,
+			stored:   "/end bob",
+		},
+		{
+			name:     "repeat inside chat",
+			lastLine: "/end bob",
+			line:     "!! again",
+			want:     "!! again",
+			ok:       true,
+			stored:   "/end bob",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peer := &RTCPeer{lastLine: tt.lastLine, lastPeer: tt.lastPeer}
+			got, ok := peer.expandShorthands(tt.line)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("got %q %v, want %q %v", got, ok, tt.want, tt.ok)
+			}
+			if ok && peer.lastLine != tt.stored {
+				t.Errorf("stored %q, want %q", peer.lastLine, tt.stored)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// iceServers returns the ICE servers used by every connection, with the
+// TURN server if there is one
+func (peer *RTCPeer) iceServers() []webrtc.ICEServer {
+	servers := append([]webrtc.ICEServer{}, rtcConf.ICEServers...)
+	if peer.TURNServer != "" {
+		servers = append(servers, webrtc.ICEServer{
+			URLs:       []string{peer.TURNServer},
+			Username:   peer.TURNUser,
+			Credential: peer.TURNPass,
+		})
+	}
+	return servers
+}
+
+// relayOnly tells whether media with remote must only go through the TURN
+// server. The setting for remote, if any, takes precedence over the global
+// one
+func (peer *RTCPeer) relayOnly(remote string) bool {
+	if relay, ok := peer.relayPeers[remote]; ok {
+		return relay
+	}
+	return peer.RelayOnly
+}
+
+// SetRelay forces or stops forcing connections with remote through the TURN
+// server, so that it never learns our local address. It applies from the
+// next connection on
+func (peer *RTCPeer) SetRelay(remote string, relay bool) {
+	peer.relayPeers[remote] = relay
+	if !relay {
+		log.Println("connections with", remote, "may be direct")
+		return
+	}
+	log.Println("connections with", remote, "will only use the TURN server")
+	if peer.TURNServer == "" {
+		logWarn("but there is no TURN server set, use -turn")
+	}
+}
+
+func (conn *Connection) icePolicy() string {
+	if conn.relay {
+		return "relay only"
+	}
+	return "all candidates"
+}
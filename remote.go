@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// Number of events buffered for each subscriber before they start being
+// dropped for it
+const eventBacklog = 256
+
+var errUnauthorized = errors.New("control token rejected")
+
+// eventHub is written to by the logger and copies every line to all the
+// clients subscribed to the event stream of the control API
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan []byte]bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[chan []byte]bool)}
+}
+
+func (h *eventHub) Write(p []byte) (int, error) {
+	line := make([]byte, len(p))
+	copy(line, p)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		select {
+		case sub <- line:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+func (h *eventHub) subscribe() chan []byte {
+	sub := make(chan []byte, eventBacklog)
+	h.mu.Lock()
+	h.subs[sub] = true
+	h.mu.Unlock()
+	return sub
+}
+
+func (h *eventHub) unsubscribe(sub chan []byte) {
+	h.mu.Lock()
+	delete(h.subs, sub)
+	h.mu.Unlock()
+}
+
+// controlServer exposes the commands of a headless peer over HTTP, so that a
+// UI running somewhere else can drive it. Commands are POSTed to /command as
+// the same lines typed in the UI, and the log is streamed from /events. The
+// call records and contacts can be read from /calls, /history and /contacts.
+// Programs that would rather not parse the log use the JSON endpoints in
+// api.go instead
+type controlServer struct {
+	rtcpeer *RTCPeer
+	token   string
+	events  *eventHub
+	// Commands are run one at a time, like they would be from the UI
+	mu       sync.Mutex
+	quit     chan struct{}
+	quitOnce sync.Once
+}
+
+func newControlServer(rtcpeer *RTCPeer, token string) *controlServer {
+	return &controlServer{
+		rtcpeer: rtcpeer,
+		token:   token,
+		events:  newEventHub(),
+		quit:    make(chan struct{}),
+	}
+}
+
+// authorized checks the token of r. There is always one, a control server
+// without a token would run commands for anybody
+func (ctl *controlServer) authorized(r *http.Request) bool {
+	return ctl.validToken(r.Header.Get("Authorization"))
+}
+
+// validToken checks the value of an Authorization header
+func (ctl *controlServer) validToken(header string) bool {
+	if ctl.token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare(
+		[]byte(header),
+		[]byte("Bearer "+ctl.token),
+	) == 1
+}
+
+// stop makes headlessMain return, however many times /exit is entered
+func (ctl *controlServer) stop() {
+	ctl.quitOnce.Do(func() { close(ctl.quit) })
+}
+
+func (ctl *controlServer) httpHandleCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ctl.authorized(r) {
+		http.Error(w, errUnauthorized.Error(), http.StatusUnauthorized)
+		return
+	}
+	cmd, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	parseCommand(string(cmd), ctl.rtcpeer, ctl.stop)
+}
+
+func (ctl *controlServer) httpHandleEvents(w http.ResponseWriter, r *http.Request) {
+	if !ctl.authorized(r) {
+		http.Error(w, errUnauthorized.Error(), http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	sub := ctl.events.subscribe()
+	defer ctl.events.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case line := <-sub:
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-ctl.quit:
+			return
+		}
+	}
+}
+
+// listen serves the control API at addr. It has its own mux so that it is
+// never reachable through the signaling address
+func (ctl *controlServer) listen(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/command", ctl.httpHandleCommand)
+	mux.HandleFunc("/events", ctl.httpHandleEvents)
+	mux.HandleFunc("/calls", ctl.httpHandleCalls)
+	mux.HandleFunc("/history", ctl.httpHandleHistory)
+	mux.HandleFunc("/contacts", ctl.httpHandleContacts)
+	mux.HandleFunc("/connections", ctl.httpHandleConnections)
+	mux.HandleFunc("/stats", ctl.httpHandleStats)
+	mux.HandleFunc("/call", ctl.httpHandleCall)
+	mux.HandleFunc("/accept", ctl.httpHandleAccept)
+	mux.HandleFunc("/reject", ctl.httpHandleReject)
+	mux.HandleFunc("/hangup", ctl.httpHandleHangUp)
+	mux.HandleFunc("/msg", ctl.httpHandleMsg)
+	log.Println("control api listening at", addr)
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
+
+// headlessMain runs wrtcion as a daemon without any interface of its own,
+// driven only through the control API. Calls are placed from the network of
+// the machine it runs on. The gRPC service is served at grpcAddr too, if
+// it isn't empty
+func headlessMain(rtcpeer *RTCPeer, flog io.Writer, addr, grpcAddr, token string) {
+	ctl := newControlServer(rtcpeer, token)
+	log.SetOutput(io.MultiWriter(flog, ctl.events))
+	go rtcpeer.Listen()
+	go ctl.listen(addr)
+	if grpcAddr != "" {
+		go ctl.serveGRPC(grpcAddr)
+	}
+	go serveCommands(func(cmd string) {
+		ctl.mu.Lock()
+		defer ctl.mu.Unlock()
+		parseCommand(cmd, rtcpeer, ctl.stop)
+	})
+	<-ctl.quit
+}
+
+// controlClient is the UI side of a split deployment, it forwards the typed
+// commands to a headless peer and shows the events it streams back
+type controlClient struct {
+	addr  string
+	token string
+}
+
+func (cli *controlClient) request(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(
+		method,
+		fmt.Sprintf("http://%s%s", cli.addr, path),
+		body,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if cli.token != "" {
+		req.Header.Set("Authorization", "Bearer "+cli.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		return nil, errUnauthorized
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("control api returned %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// command sends cmd to the headless peer. /exit only closes the local UI, the
+// peer keeps running and can be picked up again later
+func (cli *controlClient) command(cmd string, quit func()) {
+	if cmd == "/exit" {
+		quit()
+		return
+	}
+	resp, err := cli.request(
+		http.MethodPost,
+		"/command",
+		bytes.NewBufferString(cmd),
+	)
+	if err != nil {
+		logError("couldn't send command to", cli.addr, ":", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// streamEvents copies the event stream of the headless peer to the log, line
+// by line, until the connection is lost
+func (cli *controlClient) streamEvents() {
+	resp, err := cli.request(http.MethodGet, "/events", nil)
+	if err != nil {
+		logError("couldn't connect to", cli.addr, ":", err)
+		return
+	}
+	defer resp.Body.Close()
+	log.Println("connected to the headless peer at", cli.addr)
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		// The lines already carry the remote's timestamps
+		log.Writer().Write(append(scanner.Bytes(), '\n'))
+	}
+	log.Println("lost connection to", cli.addr)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// How far the timestamp of a signaling message may be from our clock. Nonces
+// are remembered for twice as long, so anything still remembered can never
+// be accepted again
+const replayWindow = 2 * time.Minute
+
+var (
+	errStaleSignal    = errors.New("signal is too old or from the future")
+	errReplayedSignal = errors.New("signal was already received")
+	errUnstamped      = errors.New("signal has no stamp, and isn't from a known older client")
+)
+
+// SignalStamp makes every signaling message unique, so that a captured offer
+// or candidate can't be sent to us again later to make us ring or connect
+type SignalStamp struct {
+	Nonce string
+	Time  time.Time
+}
+
+func newSignalStamp() SignalStamp {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return SignalStamp{Nonce: hex.EncodeToString(nonce), Time: time.Now()}
+}
+
+// replayGuard keeps the nonces seen inside the sliding replay window
+type replayGuard struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newReplayGuard() *replayGuard {
+	return &replayGuard{seen: make(map[string]time.Time)}
+}
+
+// check accepts stamp only once, and only while it is inside the window.
+// Messages of older clients carry no stamp at all, and are only let through
+// if legacy says they come from one, as the stamp could otherwise just be
+// stripped from a captured message
+func (g *replayGuard) check(stamp SignalStamp, legacy bool) error {
+	if stamp.Nonce == "" {
+		if legacy {
+			return nil
+		}
+		return errUnstamped
+	}
+	now := time.Now()
+	if stamp.Time.Before(now.Add(-replayWindow)) ||
+		stamp.Time.After(now.Add(replayWindow)) {
+		return errStaleSignal
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for nonce, seen := range g.seen {
+		if now.Sub(seen) > 2*replayWindow {
+			delete(g.seen, nonce)
+		}
+	}
+	if _, ok := g.seen[stamp.Nonce]; ok {
+		return errReplayedSignal
+	}
+	g.seen[stamp.Nonce] = now
+	return nil
+}
+
+// unstampedOK tells whether signal may come without a stamp: if its sender
+// is known to be an older client, or if it answers the call we are making
+// to it. Such an answer can't be replayed to any use, the ICE credentials
+// of an older one wouldn't match our offer, and it is how we first learn
+// that the callee is an older client
+func (peer *RTCPeer) unstampedOK(signal SignalSDP) bool {
+	if peer.knownLegacy(signal.Origin) {
+		return true
+	}
+	conn, ok := peer.Connections[signal.Origin]
+	return ok && signal.Action == Answer && conn.isInitiator &&
+		conn.state == Ringing
+}
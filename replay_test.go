@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReplayGuardCheck(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		seen   []SignalStamp
+		stamp  SignalStamp
+		legacy bool
+		want   error
+	}{
+		{
+			name:  "fresh",
+			stamp: SignalStamp{Nonce: "a", Time: now},
+		},
+		{
+			name:  "replayed",
+			seen:  []SignalStamp{{Nonce: "a", Time: now}},
+			stamp: SignalStamp{Nonce: "a", Time: now},
+			want:  errReplayedSignal,
+		},
+		{
+			name:  "another nonce",
+			seen:  []SignalStamp{{Nonce: "a", Time: now}},
+			stamp: SignalStamp{Nonce: "b", Time: now},
+		},
+		{
+			name:  "too old",
+			stamp: SignalStamp{Nonce: "a", Time: now.Add(-replayWindow - time.Second)},
+			want:  errStaleSignal,
+		},
+		{
+			name:  "from the future",
+			stamp: SignalStamp{Nonce: "a", Time: now.Add(replayWindow + time.Second)},
+			want:  errStaleSignal,
+		},
+		{
+			name:  "inside the window",
+			stamp: SignalStamp{Nonce: "a", Time: now.Add(-replayWindow / 2)},
+		},
+		{
+			name:  "zero time",
+			stamp: SignalStamp{Nonce: "a"},
+			want:  errStaleSignal,
+		},
+		{
+			name:  "unstamped",
+			stamp: SignalStamp{},
+			want:  errUnstamped,
+		},
+		{
+			name:   "unstamped from an older client",
+			stamp:  SignalStamp{},
+			legacy: true,
+		},
+		{
+			name:   "replayed from an older client",
+			seen:   []SignalStamp{{Nonce: "a", Time: now}},
+			stamp:  SignalStamp{Nonce: "a", Time: now},
+			legacy: true,
+			want:   errReplayedSignal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newReplayGuard()
+			for _, stamp := range tt.seen {
+				if err := g.check(stamp, false); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := g.check(tt.stamp, tt.legacy); err != tt.want {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"crypto"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -12,6 +13,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/pion/dtls/v2"
+	"github.com/pion/interceptor"
 	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
 	"github.com/pion/webrtc/v3/pkg/media"
@@ -24,6 +27,7 @@ const (
 	videoSource     = "resources/sources/video.mp4"
 	outputPath      = "resources/results/"
 	oggPageDuration = time.Millisecond * 20
+	maxVolume       = 150
 )
 
 var (
@@ -32,8 +36,8 @@ var (
 		ClockRate:    48000,
 	}
 	videoCodec = webrtc.RTPCodecCapability{
-		MimeType:     webrtc.MimeTypeH264,
-		ClockRate:    90000,
+		MimeType:  webrtc.MimeTypeVP8,
+		ClockRate: 90000,
 	}
 )
 
@@ -72,18 +76,44 @@ const (
 	Offer SignalAction = iota
 	Answer
 	Refuse
+	// Restart offers new ICE credentials for a call, after a suspend
+	Restart
+	RestartAnswer
+	// Cancel withdraws an offer nobody answered
+	Cancel
+	// Renegotiate offers the tracks of a call after they changed, it is
+	// answered like a Restart
+	Renegotiate
+	// Accepted tells a caller whose video was being previewed that the
+	// call was answered
+	Accepted
+)
+
+// RefuseReason tells the caller why a Refuse was sent
+type RefuseReason int
+
+const (
+	// RefuseDeclined is also what older clients send, without a reason
+	RefuseDeclined RefuseReason = iota
+	RefuseBusy
+	RefuseFailed
+	// RefuseUnavailable is sent in do not disturb mode
+	RefuseUnavailable
 )
 
 type audioSender struct {
-	track *webrtc.TrackLocalStaticSample
-	rtp   *webrtc.RTPSender
-	ogg   *oggreader.OggReader
+	track    *sampleTrack
+	rtp      *webrtc.RTPSender
+	ogg      *oggreader.OggReader
+	file     *os.File
+	pipeline *gst.SendPipeline
 }
 
 type audioReceiver struct {
-	out   string
-	track *webrtc.TrackRemote
-	rtp   *webrtc.RTPReceiver
+	out      string
+	track    *webrtc.TrackRemote
+	rtp      *webrtc.RTPReceiver
+	pipeline *gst.Pipeline
 }
 
 type Connection struct {
@@ -98,63 +128,425 @@ type Connection struct {
 	dataChan          *webrtc.DataChannel
 	audioSndr         *audioSender
 	audioRcvr         *audioReceiver
+	videoSndr         *videoSender
+	settings          CallSettings
+	started           time.Time
+	muted             bool
+	remoteMuted       bool
+	held              bool
+	volume            float64
+	fileCount         int
+	filesOut          map[string]*fileTransfer
+	filesIn           map[string]*fileTransfer
+	// channels are the application data channels, by label
+	channels map[string]*webrtc.DataChannel
+	// typingAt is when the remote last told us it is typing, zero if it
+	// stopped
+	typingAt time.Time
+	// msgCount numbers the chat messages sent, lastSent is the ID of the
+	// last one and lastSentState how far it got
+	msgCount      uint64
+	lastSent      uint64
+	lastSentState DeliveryState
+	// unread are the IDs of the messages received but not yet seen
+	unread []uint64
+	// sentAudio is how much audio was sent since firstSample
+	sentAudio   time.Duration
+	firstSample time.Time
+	// legacy is set when the remote runs an older version that doesn't know
+	// about capabilities, only plain chat and calls are used with it
+	legacy   bool
+	stats    statsTracker
+	recorder *gst.Recorder
+	// relay is set when media may only go through the TURN server
+	relay bool
+	// tone is the ringtone or ringback being played
+	tone *gst.Player
+	dtmf *dtmfInterceptor
+	// rates counts the media sent and received
+	rates *rateCounter
+	// priority shares the bandwidth between audio and video
+	priority *mediaScheduler
+	// e2e are the keys of the end-to-end encryption of messages
+	e2e *e2eSession
+	// sleptAt is when the system was suspended during the call, zero unless
+	// it is interrupted. asleep is set until the system resumes
+	sleptAt time.Time
+	asleep  bool
+	// interrupted is how long the call was interrupted by suspends
+	interrupted time.Duration
+	// restarting is set while an ICE restart is under way
+	restarting bool
+	// remoteName is the display name the remote told us, if any
+	remoteName string
+	// offer is the offer of an incoming call waiting for /accept
+	offer *SignalSDP
+	// autoVideo is set when the video bitrate wasn't given explicitly, so
+	// the one the link settles at can be learned
+	autoVideo bool
+	// lastEventTS is the timestamp of the last telephone event received
+	lastEventTS uint32
+	// zone is the time zone of the remote, nil if it didn't tell
+	zone *TimeZone
+	// confMix is what a member of the conference we host hears, and
+	// confInput the name its own audio has in the mixes of the others
+	confMix   *gst.SendPipeline
+	confInput string
+	// outcome is how the call ended up if it was never answered, empty to
+	// guess it from its direction
+	outcome CallOutcome
+	// fileOffers are the files offered by the remote waiting for
+	// /acceptfile, by ID
+	fileOffers map[string]*FileInfo
+	// negotiation keeps renegotiations from overlapping
+	negotiation negotiation
+	// packetMTU is the largest RTP packet the path to the remote takes, 0
+	// until it is known
+	packetMTU int
+	// remoteSRTP are the SRTP profiles the remote offered while signaling
+	remoteSRTP []dtls.SRTPProtectionProfile
+	// voicemail is set when nobody answered and the caller is leaving a
+	// message
+	voicemail bool
+	// previewing is set while the callee sees the video of the caller
+	// before answering, since previewAt on the side of the caller
+	previewing bool
+	previewAt  time.Time
 }
 
 type RTCPeer struct {
 	listenAddr  string
 	Connections map[string]*Connection
+	// connsMu guards Connections from the status bar and the sidebar, which
+	// are drawn from their own goroutines while connections come and go
+	connsMu       sync.RWMutex
+	AskSurvey     bool
+	pendingSurvey *CallRecord
+	// Capture makes voice calls send audio from the microphone instead of
+	// the sample file
+	Capture bool
+	// MicDevice is the name of the capture device used, the default one if
+	// empty
+	MicDevice string
+	// AudioFile is the ogg file sent instead of the sample one
+	AudioFile string
+	// Camera is the name of the video capture device used in video calls,
+	// the default one if empty
+	Camera string
+	Video  gst.VideoOptions
+	// Opus are the default encoder options used when capturing audio, they
+	// are also advertised in the SDP
+	Opus gst.OpusOptions
+	// MTU is the size of the buffers used to receive packets, 0 means the
+	// default
+	MTU uint
+	// HoldMusic is an ogg file played to calls put on hold
+	HoldMusic string
+	// Ringtone is played while a call comes in, and Ringback while we call
+	Ringtone string
+	Ringback string
+	// Record saves the media received in every call into RecordingsDir
+	Record        bool
+	RecordingsDir string
+	// AcceptFiles allows peers to send us files of up to MaxFileSize bytes,
+	// 0 meaning any size
+	AcceptFiles bool
+	MaxFileSize int64
+	watches     map[string]*folderWatch
+	// caps are the capabilities advertised by every peer we have talked to
+	caps map[string][]Capability
+	// builds is what the peers we talked to told about their build
+	builds map[string]*BuildInfo
+	typing typingState
+	// Name is shown to peers instead of our address, and as the sender of
+	// our chat messages
+	Name string
+	// DataSaver keeps the data used by calls down, for metered connections
+	DataSaver bool
+	// RingTimeout is how long we call before giving up, 0 for ever
+	RingTimeout time.Duration
+	// VoicemailAfter is how long calls ring before the caller can leave a
+	// message, 0 to refuse them after answerTimeout instead. The
+	// VoicemailGreeting ogg file is played to the caller first, and the
+	// messages are recorded to VoicemailDir
+	VoicemailAfter    time.Duration
+	VoicemailGreeting string
+	VoicemailDir      string
+	// VideoPreview shows callees our video while our video calls ring, and
+	// the video of callers doing the same before we answer
+	VideoPreview bool
+	// AutoAnswer answers incoming calls without asking first, and
+	// AutoAnswerFrom only those from some addresses
+	AutoAnswer     bool
+	AutoAnswerFrom map[string]bool
+	// ChatHistory keeps the chat with every peer on disk, and shows it again
+	// when they reconnect
+	ChatHistory bool
+	// ReadReceipts tells peers when their messages have been seen, not only
+	// when they arrived
+	ReadReceipts bool
+	// Presence probes the contacts to tell which ones are online, which
+	// reveals our address to them
+	Presence bool
+	// Soak loops the sample file, for calls that last for hours
+	Soak bool
+	// Echo plays back the audio and the messages of every call, for the
+	// demo peer
+	Echo bool
+	// replay drops signaling messages we have already received
+	replay *replayGuard
+	// SRTPProfiles restricts the SRTP protection profiles offered, all the
+	// supported ones are if empty
+	SRTPProfiles []dtls.SRTPProtectionProfile
+	// KnownCertsOnly hangs up on peers whose certificate isn't pinned in
+	// trusted
+	KnownCertsOnly bool
+	trusted        map[string]string
+	// clip is the last clipboard a peer shared with us
+	clip *Clip
+	// prefs are what was learned about the links to every peer
+	prefs map[string]LinkPrefs
+	// certificate is our identity, used by every connection, identityKey
+	// its private key
+	certificate *webrtc.Certificate
+	identityKey crypto.Signer
+	// TURNServer is the URL of the TURN server used to relay media
+	TURNServer string
+	TURNUser   string
+	TURNPass   string
+	// RelayOnly sends all media through the TURN server, so that peers never
+	// learn our local address. relayPeers overrides it for single peers
+	RelayOnly  bool
+	relayPeers map[string]bool
+	// ConfirmDirect asks before calling untrusted peers directly
+	ConfirmDirect bool
+	pendingCall   *pendingCall
+	// Mix plays the audio of all calls through a single mixer
+	Mix bool
+	// mixerMu guards mixer and mixerChannels from the tracks of every call
+	mixerMu       sync.Mutex
+	mixer         *gst.Mixer
+	mixerChannels int
+	// focus is the call the microphone goes to, unless BroadcastMic sends
+	// it to every call
+	focus        string
+	BroadcastMic bool
+	// conference is the one we are hosting, nil if none
+	conference *conference
+	// sfu forwards the tracks of every call to the others, nil if off
+	sfu *sfu
+	// transfers are the calls we were transferred from, by the address we
+	// were transferred to
+	transfers map[string]string
+	// lastDialed and lastCaller are the latest calls made and received, and
+	// lastPeer the address of the latest of both
+	lastDialed *lastCall
+	lastCaller *lastCall
+	lastPeer   string
+	// lastLine is the last line entered, repeated by !!
+	lastLine string
+	// dnd refuses calls and keeps the messages received in quiet until it
+	// is turned off
+	dnd   bool
+	quiet []string
+	// contacts is the address book, presence whether they are online
+	contacts []Contact
+	presence presence
+	// Sinks are the audio devices the calls with some peers are played to,
+	// instead of the default output
+	Sinks map[string]string
+}
+
+// CallSettings are the settings of a single call
+type CallSettings struct {
+	Audio gst.OpusOptions
+	Video gst.VideoOptions
+	Route ICERoute
 }
 
 type SignalSDP struct {
 	SDP    webrtc.SessionDescription
 	Action SignalAction
+	// Reason is only set on Refuse
+	Reason RefuseReason
 	Mode   ConnectionMode
 	Origin string
+	Caps   []Capability
+	// Zone and Build are nil when sent by older clients
+	Zone  *TimeZone
+	Build *BuildInfo
+	// E2E is nil when the sender has no identity to sign it with
+	E2E *E2EKey
+	// Name is the display name of the sender, if it has one
+	Name string `json:",omitempty"`
+	// Fingerprint is the certificate of the sender on refusals, which have
+	// no SDP to carry it
+	Fingerprint string `json:",omitempty"`
+	// SRTP are the SRTP profiles the sender offers, in order of preference
+	SRTP []dtls.SRTPProtectionProfile `json:",omitempty"`
+	// Preview is set on answers that only take the video of the caller,
+	// while the call goes on ringing
+	Preview bool `json:",omitempty"`
+	SignalStamp
 }
 
 type SignalCandidate struct {
 	Candidate string
 	Origin    string
+	SignalStamp
 }
 
 func NewRTCPeer(listen string) *RTCPeer {
 	peer := &RTCPeer{
 		Connections: make(map[string]*Connection),
 		listenAddr:  listen,
+		watches:     make(map[string]*folderWatch),
+		caps:        make(map[string][]Capability),
+		builds:      make(map[string]*BuildInfo),
+		replay:      newReplayGuard(),
+		trusted:     make(map[string]string),
+		prefs:       make(map[string]LinkPrefs),
+		relayPeers:  make(map[string]bool),
+		Sinks:       make(map[string]string),
+		transfers:   make(map[string]string),
+		presence:    presence{online: make(map[string]bool)},
+	}
+	peer.loadCaps()
+	peer.loadTrusted()
+	peer.loadPrefs()
+	peer.loadLastCalls()
+	peer.loadAddressBook()
+	cert, key, err := loadIdentity()
+	if err != nil {
+		logError("couldn't load our certificate,",
+			"using a new one for every connection:", err)
+	} else {
+		peer.certificate = cert
+		peer.identityKey = key
 	}
 
 	http.HandleFunc("/candidate", peer.httpHandleCandidate)
 	http.HandleFunc("/sdp", peer.httpHandleSDP)
+	http.HandleFunc("/ping", peer.httpHandlePing)
 
 	return peer
 }
 
+// DefaultSettings returns the media settings used by calls unless told
+// otherwise
+func (peer *RTCPeer) DefaultSettings() CallSettings {
+	settings := CallSettings{Audio: peer.Opus, Video: peer.Video}
+	if peer.DataSaver {
+		settings.Audio = dataSaverAudio(settings.Audio)
+	}
+	return settings
+}
+
 func newConnection(
 	local *RTCPeer,
 	remote string,
 	mode ConnectionMode,
+	settings CallSettings,
 ) (*Connection, error) {
+	autoVideo := settings.Video.Bitrate == 0
+	settings = local.applyPrefs(remote, settings)
 	conn := &Connection{
 		local:             local,
 		state:             Standby,
 		mode:              mode,
+		settings:          settings,
+		volume:            1,
 		pendingCandidates: make([]*webrtc.ICECandidate, 0),
+		filesOut:          make(map[string]*fileTransfer),
+		filesIn:           make(map[string]*fileTransfer),
+		fileOffers:        make(map[string]*FileInfo),
+		channels:          make(map[string]*webrtc.DataChannel),
+		dtmf:              new(dtmfInterceptor),
+		rates:             new(rateCounter),
+		priority:          new(mediaScheduler),
+		autoVideo:         autoVideo,
 	}
+	conn.relay = settings.Route == RouteRelay || local.relayOnly(remote)
 
 	m := new(webrtc.MediaEngine)
-	err := m.RegisterDefaultCodecs()
+	// Our Opus codec has to be registered first, so that it takes the place
+	// of the default one. As per RFC 7587 it is always advertised as 48kHz
+	// with two channels, the actual channels are signaled in the fmtp line
+	err := m.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:    webrtc.MimeTypeOpus,
+			ClockRate:   48000,
+			Channels:    2,
+			SDPFmtpLine: opusFmtp(settings.Audio),
+		},
+		PayloadType: 111,
+	}, webrtc.RTPCodecTypeAudio)
+	if err != nil {
+		return nil, err
+	}
+	conn.e2e, err = newE2ESession()
+	if err != nil {
+		return nil, err
+	}
+	// Telephone events for DTMF, at the clock rate of Opus as RFC 4733 asks
+	err = m.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:    mimeTypeTelephoneEvent,
+			ClockRate:   48000,
+			SDPFmtpLine: "0-15",
+		},
+		PayloadType: telephoneEventPT,
+	}, webrtc.RTPCodecTypeAudio)
+	if err != nil {
+		return nil, err
+	}
+	err = m.RegisterDefaultCodecs()
 	if err != nil {
 		return nil, err
 	}
 
+	// RTCP reports and transport wide congestion control feedback, so that
+	// the sender can adapt its bitrate to the network, and NACKs so that lost
+	// video packets are retransmitted
+	i := &interceptor.Registry{}
+	// Added first so that it counts the packets as they go on the wire
+	i.Add(conn.rates)
+	if err := webrtc.ConfigureRTCPReports(i); err != nil {
+		return nil, err
+	}
+	if err := webrtc.ConfigureNack(m, i); err != nil {
+		return nil, err
+	}
+	if err := webrtc.ConfigureTWCCSender(m, i); err != nil {
+		return nil, err
+	}
+	// Added last so that it sees our audio packets before the others do
+	i.Add(conn.dtmf)
+
 	s := webrtc.SettingEngine{
 		LoggerFactory: rtcLoggerFactory{},
 	}
+	s.SetReceiveMTU(local.receiveMTU())
+	if local.DataSaver {
+		dataSaverTimeouts(&s)
+	}
+	if len(local.SRTPProfiles) > 0 {
+		s.SetSRTPProtectionProfiles(local.SRTPProfiles...)
+	}
 	api := webrtc.NewAPI(
 		webrtc.WithMediaEngine(m),
 		webrtc.WithSettingEngine(s),
+		webrtc.WithInterceptorRegistry(i),
 	)
-	conn.peer, err = api.NewPeerConnection(rtcConf)
+	conf := rtcConf
+	conf.ICEServers = local.iceServers()
+	if conn.relay {
+		conf.ICETransportPolicy = webrtc.ICETransportPolicyRelay
+	}
+	if local.certificate != nil {
+		conf.Certificates = []webrtc.Certificate{*local.certificate}
+	}
+	conn.peer, err = api.NewPeerConnection(conf)
 	if err != nil {
 		return nil, err
 	}
@@ -162,6 +554,14 @@ func newConnection(
 	conn.peer.OnConnectionStateChange(conn.handleConnectionStateChange)
 	conn.peer.OnICECandidate(conn.handleICECandidate)
 	conn.peer.OnDataChannel(func(d *webrtc.DataChannel) {
+		if strings.HasPrefix(d.Label(), fileChanPrefix) {
+			conn.handleFileChannel(d)
+			return
+		}
+		if strings.HasPrefix(d.Label(), appChanPrefix) {
+			conn.handleAppChannel(d)
+			return
+		}
 		conn.dataChan = d
 		conn.dataChan.OnOpen(conn.handleDataChanOpen)
 		conn.dataChan.OnMessage(conn.handleDataChanMsg)
@@ -171,10 +571,29 @@ func newConnection(
 	return conn, nil
 }
 
+// opusFmtp returns the SDP fmtp line matching the Opus encoder options
+func opusFmtp(opts gst.OpusOptions) string {
+	fmtp := "minptime=10"
+	if opts.InbandFEC {
+		fmtp += ";useinbandfec=1"
+	}
+	if opts.DTX {
+		fmtp += ";usedtx=1"
+	}
+	if opts.Stereo {
+		fmtp += ";stereo=1;sprop-stereo=1"
+	}
+	if opts.Bitrate > 0 {
+		fmtp += fmt.Sprintf(";maxaveragebitrate=%d", opts.Bitrate)
+	}
+	return fmtp
+}
+
 func (conn *Connection) signalCandidate(c *webrtc.ICECandidate) error {
 	signal := SignalCandidate{
-		Candidate: c.ToJSON().Candidate,
-		Origin:    conn.local.listenAddr,
+		Candidate:   c.ToJSON().Candidate,
+		Origin:      conn.local.listenAddr,
+		SignalStamp: newSignalStamp(),
 	}
 	payload, err := json.Marshal(&signal)
 	resp, err := http.Post(fmt.Sprintf("http://%s/candidate", conn.remoteAddr),
@@ -209,12 +628,19 @@ func (conn *Connection) handleICECandidate(c *webrtc.ICECandidate) {
 func (peer *RTCPeer) httpHandleCandidate(w http.ResponseWriter, r *http.Request) {
 	var signal SignalCandidate
 	if err := json.NewDecoder(r.Body).Decode(&signal); err != nil {
-		log.Println("couldn't parse candidate: ", err)
+		logError("couldn't parse candidate: ", err)
+		return
+	}
+	if err := peer.replay.check(
+		signal.SignalStamp,
+		peer.knownLegacy(signal.Origin),
+	); err != nil {
+		logDebug("dropped candidate from", signal.Origin, ":", err)
 		return
 	}
 	conn, ok := peer.Connections[signal.Origin]
 	if !ok {
-		log.Println(
+		logDebug(
 			"got a candidate from",
 			signal.Origin,
 			"but wasn't expecting one",
@@ -225,75 +651,184 @@ func (peer *RTCPeer) httpHandleCandidate(w http.ResponseWriter, r *http.Request)
 		Candidate: signal.Candidate,
 	})
 	if err != nil {
-		log.Println("couldn't initialize candidate: ", err)
+		logError("couldn't initialize candidate: ", err)
 	}
 }
 
 func (peer *RTCPeer) httpHandleSDP(w http.ResponseWriter, r *http.Request) {
 	var signal SignalSDP
 	if err := json.NewDecoder(r.Body).Decode(&signal); err != nil {
-		log.Println("couldn't parse signal message from json: ", err)
+		logError("couldn't parse signal message from json: ", err)
+		return
+	}
+	legacy := peer.unstampedOK(signal)
+	if err := peer.replay.check(signal.SignalStamp, legacy); err != nil {
+		logWarn("dropped signal from", signal.Origin, ":", err)
+		return
+	}
+	if signal.Action == Restart || signal.Action == RestartAnswer ||
+		signal.Action == Renegotiate {
+		peer.handleRestart(w, signal)
+		return
+	}
+	if signal.Action == Cancel {
+		peer.handleCancel(signal)
+		return
+	}
+	if signal.Action == Accepted {
+		peer.handlePreviewAccepted(r, signal)
 		return
 	}
 
 	var err error
 	conn, ok := peer.Connections[signal.Origin]
 	if !ok {
-		conn, err = newConnection(peer, signal.Origin, signal.Mode)
+		conn, err = newConnection(
+			peer,
+			signal.Origin,
+			signal.Mode,
+			peer.DefaultSettings(),
+		)
 		if err != nil {
-			log.Println("couldn't create new connection:", err)
+			logError("couldn't create new connection:", err)
 			return
 		}
+		peer.connsMu.Lock()
 		peer.Connections[signal.Origin] = conn
+		peer.connsMu.Unlock()
 	}
 
 	switch signal.Action {
 	case Offer:
 		if conn.state != Standby {
-			log.Println("answering incoming call from", signal.Origin,
+			log.Println("incoming call from", signal.Origin,
 				"but we are busy")
+			go conn.busy(signal.Origin)
 			return
 		}
-		conn.state = Answering
 		conn.remoteAddr = signal.Origin
-		log.Println("incoming call from ", conn.remoteAddr)
+		conn.setState(Answering)
+		peer.called(signal.Origin, conn.mode)
+		if peer.dnd && conn.mode != TextConnection {
+			conn.outcome = OutcomeMissed
+			go conn.refuse(RefuseUnavailable)
+			return
+		}
+		log.Println("incoming call from", peer.displayName(conn.remoteAddr))
+		var current *Connection
+		if conn.mode != TextConnection {
+			current = peer.activeCall(conn)
+		}
+		if conn.mode != TextConnection && current == nil {
+			conn.startTone(peer.Ringtone)
+		}
+		conn.setCaps(signal.Caps)
+		conn.setBuild(signal.Build)
+		conn.zone = signal.Zone
+		conn.remoteSRTP = signal.SRTP
+		conn.setTheirKey(signal.E2E)
+		conn.setRemoteName(signal.Name)
+		if current != nil {
+			conn.callWaiting(signal, current)
+			return
+		}
+		if !peer.autoAnswers(signal) {
+			conn.askToAnswer(signal)
+			return
+		}
 	case Answer:
 		if conn.state != Ringing {
 			log.Println("answer from", signal.Origin,
 				"but we weren't calling")
 			return
 		}
-		log.Println("answer from ", conn.remoteAddr)
+		logDebug("answer from", conn.remoteAddr)
+		conn.setCaps(signal.Caps)
+		conn.setBuild(signal.Build)
+		conn.zone = signal.Zone
+		conn.remoteSRTP = signal.SRTP
+		conn.setTheirKey(signal.E2E)
+		conn.setRemoteName(signal.Name)
+		conn.previewing = signal.Preview && peer.VideoPreview &&
+			conn.mode == VideoConnectionSimplex
+		if conn.previewing {
+			log.Println(conn, "sees your video before answering")
+		}
 	case Refuse:
 		if conn.state != Ringing {
 			log.Println("refusal from", signal.Origin,
 				"but we weren't calling")
 			return
 		}
-		log.Println(signal.Origin, "appears to be busy")
-		conn.state = Standby
+		if !conn.sentByCallee(r, signal) {
+			logWarn("ignored a refusal claiming to come from", conn)
+			return
+		}
+		switch signal.Reason {
+		case RefuseBusy:
+			log.Println(signal.Origin, "is busy")
+			conn.outcome = OutcomeBusy
+		case RefuseFailed:
+			log.Println(signal.Origin, "couldn't take the call")
+			conn.outcome = OutcomeFailed
+		case RefuseUnavailable:
+			log.Println(signal.Origin, "doesn't want to be disturbed")
+			conn.outcome = OutcomeUnavailable
+		default:
+			log.Println(signal.Origin, "declined the call")
+			conn.outcome = OutcomeRejected
+		}
+		conn.Close()
 		return
 	default:
 		log.Println(signal.Origin,
 			"appears to be having problems communicating")
 		return
 	}
+	conn.completeSignal(signal)
+}
 
-	switch conn.mode {
-	case VoiceConnectionSimplex:
+// completeSignal takes the SDP of an offer or an answer, answering offers
+func (conn *Connection) completeSignal(signal SignalSDP) {
+	peer := conn.local
+	switch {
+	case peer.Echo && signal.Action == Offer && conn.mode != TextConnection &&
+		!conn.voicemail:
+		if err := conn.echoAudio(); err != nil {
+			logError("couldn't set up the echo:", err)
+		}
+	case conn.mode == VoiceConnectionSimplex:
 		if signal.Action == Offer {
 			conn.getAudio()
 		}
-	case VoiceConnectionDuplex:
+	case conn.mode == VoiceConnectionDuplex:
+		// Both ends talk, so the answer carries our audio too, but for
+		// voicemail only the greeting is sent
+		if signal.Action == Offer && conn.audioSndr == nil && !conn.voicemail {
+			if err := conn.prepareAudio(); err != nil {
+				logError("can't answer with audio:", err)
+			}
+		}
 		conn.getAudio()
+	case conn.mode == VideoConnectionSimplex:
+		if signal.Action == Offer {
+			conn.getAudio()
+			conn.getVideo()
+		}
 	}
 
 	if err := conn.peer.SetRemoteDescription(signal.SDP); err != nil {
-		log.Println("couldn't set remote sdp: ", err)
-		answer := SignalSDP{Action: Refuse, Origin: peer.listenAddr}
+		logError("couldn't set remote sdp: ", err)
+		answer := SignalSDP{
+			Action:      Refuse,
+			Reason:      RefuseFailed,
+			Origin:      peer.listenAddr,
+			Fingerprint: peer.ownFingerprint(),
+			SignalStamp: newSignalStamp(),
+		}
 		payload, err := json.Marshal(answer)
 		if err != nil {
-			log.Println("unable to marshal sdp answer: ", err)
+			logError("unable to marshal sdp answer: ", err)
 			return
 		}
 		resp, err := http.Post(
@@ -302,10 +837,10 @@ func (peer *RTCPeer) httpHandleSDP(w http.ResponseWriter, r *http.Request) {
 			bytes.NewReader(payload),
 		)
 		if err != nil {
-			log.Println("unable to send sdp answer: ", err)
+			logError("unable to send sdp answer: ", err)
 			return
 		} else if err := resp.Body.Close(); err != nil {
-			log.Println("http error on close: ", err)
+			logError("http error on close: ", err)
 			return
 		}
 		return
@@ -314,16 +849,27 @@ func (peer *RTCPeer) httpHandleSDP(w http.ResponseWriter, r *http.Request) {
 	// We are answering the call, so we need to create an SDP answer
 	if conn.state == Answering {
 		var err error
-		answer := SignalSDP{Action: Answer, Origin: peer.listenAddr}
+		answer := SignalSDP{
+			Action:      Answer,
+			Origin:      peer.listenAddr,
+			Caps:        peer.localCaps(),
+			Zone:        localTimeZone(),
+			Build:       localBuildInfo(),
+			E2E:         conn.e2eKey(),
+			SRTP:        peer.srtpProfiles(),
+			Name:        peer.Name,
+			Preview:     conn.previewing,
+			SignalStamp: newSignalStamp(),
+		}
 		answer.SDP, err = conn.peer.CreateAnswer(nil)
 		if err != nil {
-			log.Println("unable to create sdp answer: ", err)
+			logError("unable to create sdp answer: ", err)
 			return
 		}
 
 		payload, err := json.Marshal(answer)
 		if err != nil {
-			log.Println("unable to marshal sdp answer: ", err)
+			logError("unable to marshal sdp answer: ", err)
 			return
 		}
 		resp, err := http.Post(
@@ -332,16 +878,16 @@ func (peer *RTCPeer) httpHandleSDP(w http.ResponseWriter, r *http.Request) {
 			bytes.NewReader(payload),
 		)
 		if err != nil {
-			log.Println("unable to send sdp answer: ", err)
+			logError("unable to send sdp answer: ", err)
 			return
 		} else if err := resp.Body.Close(); err != nil {
-			log.Println("http error on close: ", err)
+			logError("http error on close: ", err)
 			return
 		}
 
 		err = conn.peer.SetLocalDescription(answer.SDP)
 		if err != nil {
-			log.Println("unable to set local sdp", err)
+			logError("unable to set local sdp", err)
 			return
 		}
 	}
@@ -351,159 +897,306 @@ func (peer *RTCPeer) httpHandleSDP(w http.ResponseWriter, r *http.Request) {
 
 	for _, c := range conn.pendingCandidates {
 		if err := conn.signalCandidate(c); err != nil {
-			log.Println("unable to signal remote conn: ", err)
+			logError("unable to signal remote conn: ", err)
 			return
 		}
 	}
-	conn.state = InCall
+	// A previewed call goes on ringing until it is answered
+	if conn.previewing {
+		return
+	}
+	conn.setState(InCall)
+}
+
+// setState moves conn to state s, and tells the status bar and the clients
+// following the events of the calls
+func (conn *Connection) setState(s ConnectionState) {
+	changed := conn.state != s
+	conn.state = s
+	refreshStatus()
+	if changed {
+		peerEvents.stateChanged(conn)
+	}
 }
 
 func (conn *Connection) handleConnectionStateChange(s webrtc.PeerConnectionState) {
-	log.Println("peer connection state has changed: ", s.String())
+	logDebug("peer connection state has changed:", s.String())
 
 	switch s {
 	case webrtc.PeerConnectionStateConnected:
-		conn.state = InCall
-		switch conn.mode {
-		case VoiceConnectionSimplex:
-			if conn.isInitiator {
-				go conn.sendAudio()
-			}
-		case VoiceConnectionDuplex:
-			go conn.sendAudio()
+		if conn.restarting || !conn.sleptAt.IsZero() {
+			conn.reconnected()
+			return
+		}
+		if !conn.checkPeerCert() {
+			return
 		}
+		if conn.previewing {
+			conn.startPreview()
+			return
+		}
+		conn.connected()
 	case webrtc.PeerConnectionStateFailed:
 		fallthrough
 	case webrtc.PeerConnectionStateDisconnected:
+		if conn.restarting || !conn.sleptAt.IsZero() {
+			// The ICE restart decides
+			return
+		}
 		conn.Close()
 		fallthrough
 	case webrtc.PeerConnectionStateClosed:
-		conn.state = Closed
+		conn.setState(Closed)
+	}
+}
+
+// connected starts the call once the peer connection is up, and answered
+func (conn *Connection) connected() {
+	conn.stopTone()
+	conn.started = time.Now()
+	conn.setState(InCall)
+	log.Println("connected to", conn.local.displayName(conn.remoteAddr),
+		"at", formatTimes(conn.started, conn.zone))
+	if conn.voicemail {
+		conn.recordMessage()
+		return
+	}
+	conn.takeFocus()
+	conn.completeTransfer()
+	conn.checkPathMTU()
+	conn.showChatHistory()
+	conn.joinSFU()
+	if conn.joinConference() {
+		return
+	}
+	switch conn.mode {
+	case VoiceConnectionSimplex:
+		if conn.isInitiator {
+			go conn.sendAudio()
+		}
+	case VoiceConnectionDuplex:
+		// The echo of the demo peer sends no audio of its own
+		if conn.audioSndr != nil {
+			go conn.sendAudio()
+		}
+	case VideoConnectionSimplex:
+		if conn.isInitiator {
+			go conn.sendAudio()
+			// The video is already on its way if it was previewed
+			if conn.previewAt.IsZero() {
+				conn.sendVideo()
+			}
+		}
 	}
 }
 
 func (conn *Connection) handleDataChanOpen() {
-	log.Printf(
-		"data channel %s@%s — %d open\n",
+	logDebug(fmt.Sprintf(
+		"data channel %s@%s — %d open",
 		conn.dataChan.Label(),
 		conn,
 		conn.dataChan.ID(),
-	)
+	))
+	conn.sendHello()
 }
 
 func (conn *Connection) handleDataChanClose() {
-	log.Printf(
-		"data channel %s@%s — %d closed\n",
+	logDebug(fmt.Sprintf(
+		"data channel %s@%s — %d closed",
 		conn.dataChan.Label(),
 		conn,
 		conn.dataChan.ID(),
-	)
+	))
 	conn.dataChan = nil
 	if err := conn.Close(); err != nil {
-		log.Println("something happened while attempting to close connection:", err)
+		logError("something happened while attempting to close connection:", err)
 	}
 }
 
-func (conn *Connection) handleDataChanMsg(msg webrtc.DataChannelMessage) {
-	log.Printf(
-		"channel %s@%s: %s\n",
-		conn.dataChan.Label(),
-		conn,
-		string(msg.Data),
-	)
-}
-
 func (conn *Connection) saveToDisk(i media.Writer, track *webrtc.TrackRemote) {
+	reorder := newReorderBuffer()
 	defer func() {
+		for _, packet := range reorder.flush() {
+			if err := i.WriteRTP(packet); err != nil {
+				logError("error writing to disk:", err)
+				break
+			}
+		}
 		if err := i.Close(); err != nil {
-			log.Println("error closing file:", err)
+			logError("error closing file:", err)
 		}
 	}()
 
 	for conn.state == InCall {
 		packet, _, err := track.ReadRTP()
 		if err != nil {
-			log.Println("error reading rtp stream:", err)
+			logError("error reading rtp stream:", err)
 			conn.Close()
 			return
 		}
-		if err := i.WriteRTP(packet); err != nil {
-			log.Println("error writing to disk:", err)
-			conn.Close()
-			return
+		for _, packet := range reorder.push(packet) {
+			if err := i.WriteRTP(packet); err != nil {
+				logError("error writing to disk:", err)
+				conn.Close()
+				return
+			}
 		}
 	}
 }
 
 func (conn *Connection) getAudio() error {
-	_, err := conn.peer.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio)
-	if err != nil {
-		return err
+	var err error
+	// The track of the audio we send, if any, takes the audio transceiver
+	if conn.audioSndr == nil {
+		_, err = conn.peer.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio)
+		if err != nil {
+			return err
+		}
 	}
-	if err != nil {
-		return err
+
+	// Previews aren't recorded, the call is once it is answered
+	if conn.local.Record && !conn.previewing || conn.voicemail {
+		conn.startRecording()
 	}
 
-	conn.peer.OnTrack(func(
-		track *webrtc.TrackRemote,
-		recvr *webrtc.RTPReceiver,
-	) {
-		// Send a PLI on an interval so that the publisher is pushing a keyframe
-		// every rtcpPLIInterval
-		go func() {
-			ticker := time.NewTicker(time.Second * 3)
-			for range ticker.C {
-				if conn.state != InCall {
-					return
-				}
-				err := conn.peer.WriteRTCP(
-					[]rtcp.Packet{
-						&rtcp.PictureLossIndication{
-							MediaSSRC: uint32(track.SSRC()),
-						},
+	conn.peer.OnTrack(conn.handleTrack)
+
+	return err
+}
+
+// handleTrack plays a track received from the remote, and records it
+func (conn *Connection) handleTrack(
+	track *webrtc.TrackRemote,
+	recvr *webrtc.RTPReceiver,
+) {
+	// Send a PLI on an interval so that the publisher is pushing a keyframe
+	// every rtcpPLIInterval. Single lost packets are recovered through
+	// NACKs, this is only for losses too large to be retransmitted in time
+	go func() {
+		ticker := time.NewTicker(time.Second * 3)
+		for range ticker.C {
+			if !conn.receiving() {
+				return
+			}
+			err := conn.peer.WriteRTCP(
+				[]rtcp.Packet{
+					&rtcp.PictureLossIndication{
+						MediaSSRC: uint32(track.SSRC()),
 					},
-				)
-				if err != nil {
-					log.Println("RTCP error:", err)
-				}
+				},
+			)
+			if err != nil {
+				logDebug("RTCP error:", err)
 			}
-		}()
+		}
+	}()
 
-		codecName := strings.Split(
-			track.Codec().RTPCodecCapability.MimeType,
-			"/",
-		)[1]
-		pipeline := gst.CreatePipeline(
+	codecName := strings.Split(
+		track.Codec().RTPCodecCapability.MimeType,
+		"/",
+	)[1]
+	var pipeline *gst.Pipeline
+	sink, routed := conn.local.Sinks[conn.remoteAddr]
+	if track.Kind() == webrtc.RTPCodecTypeAudio && routed {
+		logDebug("playing audio from", conn, "to", sink)
+		pipeline = gst.CreateRoutedPipeline(
 			track.PayloadType(),
 			strings.ToLower(codecName),
+			sink,
 		)
-		pipeline.Start()
-		defer pipeline.Stop()
-		buf := make([]byte, 1500)
-		for conn.state == InCall {
-			i, _, err := track.Read(buf)
-			if err == io.EOF {
-				log.Println("end of track")
-				return
-			} else if err != nil {
-				log.Println("track read error:", err)
-				conn.Close()
-				return
+	} else if track.Kind() == webrtc.RTPCodecTypeAudio && conn.local.Mix {
+		channel := conn.local.addToMixer()
+		defer conn.local.removeFromMixer(channel)
+		pipeline = gst.CreateMixedPipeline(
+			track.PayloadType(),
+			strings.ToLower(codecName),
+			channel,
+		)
+	} else {
+		pipeline = gst.CreatePipeline(
+			track.PayloadType(),
+			strings.ToLower(codecName),
+		)
+	}
+	if track.Kind() == webrtc.RTPCodecTypeAudio {
+		conn.audioRcvr = &audioReceiver{
+			track:    track,
+			rtp:      recvr,
+			pipeline: pipeline,
+		}
+		pipeline.SetVolume(conn.volume)
+		if conn.settings.Audio.Music {
+			pipeline.SetLatency(gst.MusicJitterLatency)
+		}
+	}
+	pipeline.Start()
+	defer pipeline.Stop()
+	fwd := conn.forwardTrack(track)
+	defer fwd.stop()
+	buf := make([]byte, conn.local.receiveMTU())
+	for conn.receiving() {
+		i, _, err := track.Read(buf)
+		if err == io.EOF {
+			logDebug("end of track")
+			return
+		} else if err != nil {
+			logError("track read error:", err)
+			conn.Close()
+			return
+		}
+		// The track switches codec with the payload type of each packet
+		if strings.EqualFold(track.Codec().MimeType, mimeTypeTelephoneEvent) {
+			conn.handleTelephoneEvent(buf[:i])
+			continue
+		}
+		if conn.held {
+			continue
+		}
+		// Only the video is shown while previewing a call
+		if conn.previewing {
+			if track.Kind() == webrtc.RTPCodecTypeVideo {
+				pipeline.Push(buf[:i])
 			}
+			continue
+		}
+		// Messages left on voicemail are recorded without being played
+		if !conn.voicemail {
 			pipeline.Push(buf[:i])
 		}
-	})
+		fwd.write(buf[:i])
+		if track.Kind() == webrtc.RTPCodecTypeAudio {
+			conn.relayToConference(buf[:i])
+		}
+		if rec := conn.recorder; rec != nil {
+			if track.Kind() == webrtc.RTPCodecTypeAudio {
+				rec.PushAudio(buf[:i])
+			} else {
+				rec.PushVideo(buf[:i])
+			}
+		}
+	}
+}
 
-	return err
+// prepareAudio sets up the audio we send, from the microphone or the sample
+// file
+func (conn *Connection) prepareAudio() error {
+	if conn.local.Capture {
+		return conn.captureAudio()
+	}
+	if conn.local.AudioFile != "" {
+		return conn.loadAudio(conn.local.AudioFile)
+	}
+	return conn.loadAudio(audioSource)
 }
 
 func (conn *Connection) loadAudio(fname string) error {
 	var err error
 	conn.audioSndr = new(audioSender)
-	conn.audioSndr.track, err = webrtc.NewTrackLocalStaticSample(
+	conn.audioSndr.track, err = newSampleTrack(
 		audioCodec,
 		"audio",
 		conn.String(),
+		conn.packetSize(),
 	)
 	if err != nil {
 		return err
@@ -513,42 +1206,117 @@ func (conn *Connection) loadAudio(fname string) error {
 		return err
 	}
 
-	file, err := os.Open(fname)
+	conn.audioSndr.file, err = os.Open(fname)
 	if err != nil {
 		return err
 	}
-	conn.audioSndr.ogg, _, err = oggreader.NewWith(file)
+	conn.audioSndr.ogg, _, err = oggreader.NewWith(conn.audioSndr.file)
 
 	return err
 }
 
+func (conn *Connection) captureAudio() error {
+	var err error
+	conn.audioSndr = new(audioSender)
+	conn.audioSndr.track, err = newSampleTrack(
+		audioCodec,
+		"audio",
+		conn.String(),
+		conn.packetSize(),
+	)
+	if err != nil {
+		return err
+	}
+	conn.audioSndr.rtp, err = conn.peer.AddTrack(conn.audioSndr.track)
+	if err != nil {
+		return err
+	}
+
+	conn.audioSndr.pipeline, err = gst.CreateAudioSendPipeline(
+		conn.local.MicDevice,
+		conn.settings.Audio,
+		func(data []byte, duration time.Duration) {
+			if conn.state != InCall {
+				return
+			}
+			conn.countSample(duration)
+			if !conn.sendingAudio() {
+				return
+			}
+			err := conn.audioSndr.track.WriteSample(media.Sample{
+				Data:     data,
+				Duration: duration,
+			})
+			if err != nil {
+				logError("error writing samples:", err)
+			}
+		},
+	)
+
+	return err
+}
+
+// granuleDuration returns how long the audio between two ogg granule
+// positions lasts
+func granuleDuration(granule, last uint64) time.Duration {
+	sampleCount := float64(granule - last)
+	return time.Duration((sampleCount/float64(audioCodec.ClockRate))*1000) *
+		time.Millisecond
+}
+
 func (conn *Connection) sendAudio() {
+	go conn.handleAudioRTCP()
+	if conn.audioSndr.pipeline != nil {
+		logDebug("sending audio")
+		conn.audioSndr.pipeline.Start()
+		return
+	}
+
 	var lastGranule uint64
 	ticker := time.NewTicker(oggPageDuration)
-	log.Println("sending audio")
+	logDebug("sending audio")
 	for ; conn.state == InCall; <-ticker.C {
 		pageData, pageHeader, err := conn.audioSndr.ogg.ParseNextPage()
+		if err == io.EOF && conn.local.Soak {
+			// Soak tests need the audio to go on for hours
+			_, err = conn.audioSndr.file.Seek(0, io.SeekStart)
+			conn.audioSndr.ogg.ResetReader(func(int64) io.Reader {
+				return conn.audioSndr.file
+			})
+			lastGranule = 0
+			// Skip the ID header, which the reader only parses when created
+			if err == nil {
+				_, _, err = conn.audioSndr.ogg.ParseNextPage()
+			}
+			if err == nil {
+				continue
+			}
+		}
 		if err == io.EOF {
-			log.Println("end of audio")
+			logDebug("end of audio")
 			conn.Close()
 			return
 		} else if err != nil {
-			log.Println("error reading audio pages:", err)
+			logError("error reading audio pages:", err)
 			conn.Close()
 			return
 		}
 
-		sampleCount := float64(pageHeader.GranulePosition - lastGranule)
+		sampleDuration := granuleDuration(
+			pageHeader.GranulePosition,
+			lastGranule,
+		)
 		lastGranule = pageHeader.GranulePosition
-		sampleDuration :=
-			time.Duration((sampleCount/float64(audioCodec.ClockRate))*1000) *
-			time.Millisecond
+		conn.countSample(sampleDuration)
+		if !conn.sendingAudio() {
+			continue
+		}
 		err = conn.audioSndr.track.WriteSample(media.Sample{
 			Data:     pageData,
 			Duration: sampleDuration,
 		})
 		if err != nil {
-			log.Println("error writing samples:", err)
+			logError("error writing samples:", err)
 			conn.Close()
 			return
 		}
@@ -556,14 +1324,38 @@ func (conn *Connection) sendAudio() {
 }
 
 func (peer *RTCPeer) Ring(remote string, mode ConnectionMode) *Connection {
+	return peer.RingWith(remote, mode, peer.DefaultSettings())
+}
+
+// RingWith is like Ring, but uses settings instead of the default media
+// settings for this call
+func (peer *RTCPeer) RingWith(
+	remote string,
+	mode ConnectionMode,
+	settings CallSettings,
+) *Connection {
 	if _, ok := peer.Connections[remote]; ok {
 		log.Println("you are already connected to", remote)
 		return nil
 	}
+	if online, probed := peer.presence.get(remote); probed && !online {
+		log.Println(remote, "was offline when last checked, calling anyway")
+	}
+	if mode == VideoConnectionSimplex && peer.DataSaver {
+		log.Println("data saver is on, calling without video")
+		mode = VoiceConnectionSimplex
+	}
+	if mode == VideoConnectionSimplex && !peer.requireCap(remote, CapVideo) {
+		return nil
+	}
+	if peer.needsConsent(remote, settings) {
+		peer.askConsent(remote, mode, settings)
+		return nil
+	}
 
-	conn, err := newConnection(peer, remote, mode)
+	conn, err := newConnection(peer, remote, mode, settings)
 	if err != nil {
-		log.Println("couldn't create new connection:", err)
+		logError("couldn't create new connection:", err)
 		return nil
 	}
 	conn.isInitiator = true
@@ -573,9 +1365,11 @@ func (peer *RTCPeer) Ring(remote string, mode ConnectionMode) *Connection {
 	var resp *http.Response
 	// A data channel will always be created
 	conn.dataChan, err = conn.peer.CreateDataChannel("data", nil)
+	peer.connsMu.Lock()
 	peer.Connections[remote] = conn
+	peer.connsMu.Unlock()
 	if err != nil {
-		log.Println("unable to create data channel: ", err)
+		logError("unable to create data channel: ", err)
 		goto fail
 	}
 	conn.dataChan.OnOpen(conn.handleDataChanOpen)
@@ -583,47 +1377,70 @@ func (peer *RTCPeer) Ring(remote string, mode ConnectionMode) *Connection {
 	conn.dataChan.OnClose(conn.handleDataChanClose)
 
 	switch mode {
+	case VideoConnectionSimplex:
+		if err = conn.captureVideo(); err != nil {
+			logError("can't start video call, problem setting up camera:",
+				err)
+			goto fail
+		}
+		fallthrough
 	case VoiceConnectionSimplex:
 		fallthrough
 	case VoiceConnectionDuplex:
-		if err := conn.loadAudio(audioSource); err != nil {
-			log.Println(
-				"can't start voice call, problem loading audio file:",
+		if err = conn.prepareAudio(); err != nil {
+			logError(
+				"can't start voice call, problem setting up audio:",
 				err,
 			)
 			goto fail
 		}
 	}
 
-	offer = SignalSDP{Action: Offer, Mode: mode, Origin: peer.listenAddr}
+	offer = SignalSDP{
+		Action:      Offer,
+		Mode:        mode,
+		Origin:      peer.listenAddr,
+		Caps:        peer.localCaps(),
+		Zone:        localTimeZone(),
+		Build:       localBuildInfo(),
+		E2E:         conn.e2eKey(),
+		SRTP:        peer.srtpProfiles(),
+		Name:        peer.Name,
+		SignalStamp: newSignalStamp(),
+	}
 	offer.SDP, err = conn.peer.CreateOffer(nil)
 	if err != nil {
-		log.Println("unable to create offer: ", err)
+		logError("unable to create offer: ", err)
 		goto fail
 	}
 	if err = conn.peer.SetLocalDescription(offer.SDP); err != nil {
-		log.Println("unable to set local description: ", err)
+		logError("unable to set local description: ", err)
 		goto fail
 	}
 	payload, err = json.Marshal(&offer)
 	if err != nil {
-		log.Println("unable to marshal offer into json: ", err)
+		logError("unable to marshal offer into json: ", err)
 		goto fail
 	}
 	conn.remoteAddr = remote
-	conn.state = Ringing
+	conn.setState(Ringing)
+	peer.dialed(remote, mode, settings)
+	conn.ringUntil(peer.RingTimeout)
 	log.Println("dialing", remote)
+	if mode != TextConnection {
+		conn.startTone(peer.Ringback)
+	}
 	resp, err = http.Post(
 		fmt.Sprintf("http://%s/sdp", remote),
 		"application/json; charset=utf-8",
 		bytes.NewReader(payload),
 	)
 	if err != nil {
-		log.Println("unable to dial", remote, "conn: ", err)
+		logError("unable to dial", remote, "conn: ", err)
 		goto fail
 	}
 	if err := resp.Body.Close(); err != nil {
-		log.Println("unable to close response: ", err)
+		logError("unable to close response: ", err)
 		goto fail
 	}
 	return conn
@@ -632,22 +1449,41 @@ fail:
 	return nil
 }
 
-func (conn *Connection) SendMsg(msg string) {
+func (conn *Connection) SendMsg(t ChatType, msg string) {
 	if conn.state != InCall {
 		log.Println("but there was nobody listening...")
 		return
 	}
-	if err := conn.dataChan.SendText(msg); err != nil {
-		log.Println("couldn't send message to ", conn, ": ", err)
+	if err := conn.sendChat(t, msg); err != nil {
+		logError("couldn't send message to ", conn, ": ", err)
 	}
 }
 
-func (peer *RTCPeer) SendMsgToAll(msg string) {
+func (peer *RTCPeer) SendMsgToAll(t ChatType, msg string) {
 	for _, conn := range peer.Connections {
-		conn.SendMsg(msg)
+		conn.SendMsg(t, msg)
 	}
 }
 
+// SetVolume sets the playback volume of the audio received from remote, in
+// percent of the original volume
+func (peer *RTCPeer) SetVolume(remote string, percent int) {
+	conn, ok := peer.Connections[remote]
+	if !ok {
+		log.Println("not connected to", remote)
+		return
+	}
+	if percent < 0 || percent > maxVolume {
+		log.Println("volume must be between 0 and", maxVolume)
+		return
+	}
+	conn.volume = float64(percent) / 100
+	if conn.audioRcvr != nil {
+		conn.audioRcvr.pipeline.SetVolume(conn.volume)
+	}
+	log.Printf("volume of %s set to %d%%\n", remote, percent)
+}
+
 func (peer *RTCPeer) HangUp(remote string) {
 	conn, ok := peer.Connections[remote]
 	if !ok {
@@ -656,7 +1492,7 @@ func (peer *RTCPeer) HangUp(remote string) {
 	}
 	err := conn.Close()
 	if err != nil {
-		log.Println("unable to close peer connection: ", err)
+		logError("unable to close peer connection: ", err)
 	}
 }
 
@@ -664,13 +1500,40 @@ func (conn *Connection) Close() error {
 	if conn.state == Closed {
 		return nil
 	}
-	conn.state = Closed
+	conn.setState(Closed)
+	if callPrompts != nil {
+		callPrompts.dismiss(conn.remoteAddr)
+	}
 	if conn.dataChan != nil {
 		conn.dataChan.Close()
 	}
+	if conn.audioSndr != nil && conn.audioSndr.pipeline != nil {
+		conn.audioSndr.pipeline.Stop()
+	}
+	if conn.videoSndr != nil {
+		conn.videoSndr.pipeline.Stop()
+	}
+	conn.closeFiles()
+	conn.stopTone()
+	conn.stopRecording()
+	conn.passFocus()
+	conn.leaveConference()
+	if conn.started.IsZero() {
+		conn.failTransfer(conn.remoteAddr)
+	}
+	conn.learnPrefs()
+	rec := conn.callRecord()
 	err := conn.peer.Close()
-	log.Printf("connection to %s closed\n", conn)
+	if conn.started.IsZero() || conn.mode == TextConnection {
+		log.Printf("connection to %s closed\n", conn)
+	} else {
+		log.Printf("connection to %s closed after %s\n", conn,
+			formatDuration(rec.Duration))
+	}
+	conn.local.connsMu.Lock()
 	delete(conn.local.Connections, conn.remoteAddr)
+	conn.local.connsMu.Unlock()
+	conn.local.endCall(rec)
 	return err
 }
 
@@ -681,12 +1544,19 @@ func (conn *Connection) String() string {
 func (peer *RTCPeer) CloseAll() {
 	for k, conn := range peer.Connections {
 		if err := conn.Close(); err != nil {
-			log.Println("unable to close peer", k, "connection: ", err)
+			logError("unable to close peer", k, "connection: ", err)
 		}
 	}
+	peer.SkipSurvey()
 }
 
 func (peer *RTCPeer) Listen() {
 	log.Println("listening at", peer.listenAddr)
+	peer.watchSleep()
+	peer.watchContacts()
+	go peer.watchNetwork()
+	if peer.Presence {
+		go peer.watchPresence()
+	}
 	log.Fatal(http.ListenAndServe(peer.listenAddr, nil))
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// How often a scripted run checks on its connection
+	scriptPoll = 250 * time.Millisecond
+	// How long wrtcion msg waits for the message to be delivered
+	deliveryTimeout = 5 * time.Second
+)
+
+// script is what a non-interactive subcommand was asked to do
+type script struct {
+	command string
+	remote  string
+	// audio is the ogg file a call sends, and video makes it a video call
+	audio string
+	video bool
+	// length hangs up a call after it, 0 waits for the audio to end or the
+	// remote to hang up
+	length time.Duration
+	text   string
+}
+
+// parseScript reads the arguments of the call, msg and listen subcommands.
+// A nil script with 0 means listen without -headless, which runs the
+// interface as usual
+func parseScript(args []string) (*script, int) {
+	s := &script{command: args[0]}
+	fs := flag.NewFlagSet(s.command, flag.ContinueOnError)
+	var headless bool
+	switch s.command {
+	case "call":
+		fs.StringVar(&s.audio, "audio", "", "ogg file to send instead of the sample one")
+		fs.BoolVar(&s.video, "video", false, "send video from the camera too")
+		fs.DurationVar(&s.length, "for", 0, "hang up after this long, 0 to wait for the audio to end")
+	case "listen":
+		fs.BoolVar(&headless, "headless", false, "log to the standard output instead of running the interface")
+	}
+	// The address comes first, as in the usage, and the flag package stops
+	// at the first argument that isn't a flag
+	args = args[1:]
+	var operands []string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		operands, args = args[:1], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, 2
+	}
+	operands = append(operands, fs.Args()...)
+	switch s.command {
+	case "call":
+		if len(operands) != 1 {
+			fmt.Fprintln(os.Stderr, "usage: wrtcion call <address> [-audio file.ogg] [-video] [-for duration]")
+			return nil, 2
+		}
+		s.remote = operands[0]
+	case "msg":
+		if len(operands) != 2 {
+			fmt.Fprintln(os.Stderr, "usage: wrtcion msg <address> <text>")
+			return nil, 2
+		}
+		s.remote, s.text = operands[0], operands[1]
+	case "listen":
+		if !headless {
+			return nil, 0
+		}
+	}
+	return s, 0
+}
+
+// scriptMain does what s says without the interface, logging to the
+// standard output, and returns the exit status: 0 if the call was answered
+// or the message sent, 1 if not
+func scriptMain(rtcpeer *RTCPeer, flog io.Writer, s *script) int {
+	log.SetOutput(io.MultiWriter(flog, os.Stdout))
+	if s.command == "listen" {
+		rtcpeer.Listen()
+		return 0
+	}
+	go rtcpeer.Listen()
+	remote := rtcpeer.contactAddress(s.remote)
+	switch s.command {
+	case "call":
+		if s.audio != "" {
+			rtcpeer.AudioFile = s.audio
+		}
+		mode := VoiceConnectionSimplex
+		if s.video {
+			mode = VideoConnectionSimplex
+		}
+		conn := rtcpeer.RingWith(remote, mode, rtcpeer.DefaultSettings())
+		if conn == nil {
+			return 1
+		}
+		return scriptCall(conn, s.length)
+	case "msg":
+		conn := rtcpeer.Ring(remote, TextConnection)
+		if conn == nil {
+			return 1
+		}
+		return scriptMsg(conn, s.text)
+	}
+	return 2
+}
+
+// scriptCall waits for the call on conn to end, hanging up after length if
+// it isn't 0
+func scriptCall(conn *Connection, length time.Duration) int {
+	ticker := time.NewTicker(scriptPoll)
+	defer ticker.Stop()
+	for ; conn.state != Closed; <-ticker.C {
+		if length > 0 && conn.state == InCall &&
+			time.Since(conn.started) >= length {
+			conn.Close()
+		}
+	}
+	if conn.started.IsZero() {
+		return 1
+	}
+	return 0
+}
+
+// scriptMsg sends text once the data channel of conn is open, and hangs up
+// once it is delivered or deliveryTimeout has passed
+func scriptMsg(conn *Connection, text string) int {
+	ticker := time.NewTicker(scriptPoll)
+	defer ticker.Stop()
+	for ; conn.dataChan == nil ||
+		conn.dataChan.ReadyState() != webrtc.DataChannelStateOpen; <-ticker.C {
+		if conn.state == Closed {
+			return 1
+		}
+	}
+	conn.SendMsg(ChatText, text)
+	if conn.local.supports(conn.remoteAddr, CapReceipts) {
+		deadline := time.Now().Add(deliveryTimeout)
+		for conn.lastSentState == Sent && time.Now().Before(deadline) {
+			<-ticker.C
+		}
+	}
+	conn.Close()
+	return 0
+}
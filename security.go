@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/pion/dtls/v2"
+	"github.com/pion/webrtc/v3"
+)
+
+// Pion only implements DTLS 1.2
+const dtlsVersion = "DTLS 1.2"
+
+const (
+	lockIcon   = "🔒"
+	recordIcon = "●REC"
+	focusIcon  = "[mic]"
+	dndIcon    = "⛔"
+)
+
+var srtpProfileNames = map[dtls.SRTPProtectionProfile]string{
+	dtls.SRTP_AES128_CM_HMAC_SHA1_80: "SRTP_AES128_CM_HMAC_SHA1_80",
+	dtls.SRTP_AES128_CM_HMAC_SHA1_32: "SRTP_AES128_CM_HMAC_SHA1_32",
+	dtls.SRTP_AEAD_AES_128_GCM:       "SRTP_AEAD_AES_128_GCM",
+	dtls.SRTP_AEAD_AES_256_GCM:       "SRTP_AEAD_AES_256_GCM",
+}
+
+// The profiles Pion can actually use for SRTP
+var supportedSRTPProfiles = []dtls.SRTPProtectionProfile{
+	dtls.SRTP_AEAD_AES_128_GCM,
+	dtls.SRTP_AES128_CM_HMAC_SHA1_80,
+}
+
+// parseSRTPProfiles parses a comma separated list of SRTP protection profile
+// names, in order of preference
+func parseSRTPProfiles(list string) ([]dtls.SRTPProtectionProfile, error) {
+	var profiles []dtls.SRTPProtectionProfile
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		found := false
+		for _, p := range supportedSRTPProfiles {
+			if srtpProfileNames[p] == name {
+				profiles = append(profiles, p)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unsupported srtp profile %s", name)
+		}
+	}
+	if len(profiles) == 0 {
+		return nil, errors.New("no srtp profiles given")
+	}
+	return profiles, nil
+}
+
+// CryptoInfo describes the encryption actually in use by a connection
+type CryptoInfo struct {
+	// Secure is set once the DTLS handshake has completed, the other fields
+	// are only meaningful then
+	Secure            bool
+	DTLSVersion       string
+	SRTPProfile       string
+	LocalFingerprint  string
+	RemoteFingerprint string
+}
+
+func (conn *Connection) dtlsTransport() *webrtc.DTLSTransport {
+	sctp := conn.peer.SCTP()
+	if sctp == nil {
+		return nil
+	}
+	return sctp.Transport()
+}
+
+// srtpProfiles are the SRTP profiles we offer, in order of preference.
+// Pion offers supportedSRTPProfiles unless told otherwise
+func (peer *RTCPeer) srtpProfiles() []dtls.SRTPProtectionProfile {
+	if len(peer.SRTPProfiles) > 0 {
+		return peer.SRTPProfiles
+	}
+	return supportedSRTPProfiles
+}
+
+// dtlsClient tells whether we are the client of the DTLS handshake of conn,
+// from the roles the descriptions settled on
+func (conn *Connection) dtlsClient() (client, ok bool) {
+	local, remote := conn.peer.LocalDescription(), conn.peer.RemoteDescription()
+	if local == nil || remote == nil {
+		return false, false
+	}
+	switch sdpSetup(local.SDP) {
+	case "active":
+		return true, true
+	case "passive":
+		return false, true
+	}
+	// We offered to take either role, the answer picked
+	switch sdpSetup(remote.SDP) {
+	case "active":
+		return false, true
+	case "passive":
+		return true, true
+	}
+	return false, false
+}
+
+// sdpSetup returns the DTLS role in the a=setup line of an SDP
+func sdpSetup(sdp string) string {
+	for _, line := range strings.Split(sdp, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "a=setup:") {
+			return strings.TrimPrefix(line, "a=setup:")
+		}
+	}
+	return ""
+}
+
+// srtpProfile returns the SRTP protection profile the DTLS handshake of conn
+// settled on: the first of the client's that the server also has, as RFC
+// 5764 has it. Both lists were sent while signaling, older clients don't
+// send theirs and then we can't tell
+func (conn *Connection) srtpProfile() string {
+	client, ok := conn.dtlsClient()
+	if !ok || len(conn.remoteSRTP) == 0 {
+		return "unknown"
+	}
+	offered, server := conn.remoteSRTP, conn.local.srtpProfiles()
+	if client {
+		offered, server = server, offered
+	}
+	for _, p := range offered {
+		for _, q := range server {
+			if p == q {
+				if name, ok := srtpProfileNames[p]; ok {
+					return name
+				}
+				return "unknown"
+			}
+		}
+	}
+	return "unknown"
+}
+
+// fingerprint formats the SHA-256 fingerprint of a DER certificate the way it
+// appears in the SDP
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	hexes := make([]string, len(sum))
+	for i, b := range sum {
+		hexes[i] = fmt.Sprintf("%02X", b)
+	}
+	return "sha-256 " + strings.Join(hexes, ":")
+}
+
+// Crypto returns the encryption details of the connection
+func (conn *Connection) Crypto() CryptoInfo {
+	var info CryptoInfo
+	t := conn.dtlsTransport()
+	if t == nil || t.State() != webrtc.DTLSTransportStateConnected {
+		return info
+	}
+	info.Secure = true
+	info.DTLSVersion = dtlsVersion
+	info.SRTPProfile = conn.srtpProfile()
+	if params, err := t.GetLocalParameters(); err == nil &&
+		len(params.Fingerprints) > 0 {
+		fp := params.Fingerprints[0]
+		info.LocalFingerprint = fp.Algorithm + " " + strings.ToUpper(fp.Value)
+	}
+	if cert := t.GetRemoteCertificate(); len(cert) > 0 {
+		info.RemoteFingerprint = fingerprint(cert)
+	}
+	return info
+}
+
+func (conn *Connection) logCrypto() {
+	info := conn.Crypto()
+	if !info.Secure {
+		log.Println("  encryption: not established yet")
+		return
+	}
+	log.Println("  encryption:", lockIcon, info.DTLSVersion)
+	log.Println("  srtp profile:", info.SRTPProfile)
+	log.Println("  local fingerprint:", info.LocalFingerprint)
+	log.Println("  remote fingerprint:", info.RemoteFingerprint)
+	if conn.e2eEstablished() {
+		log.Println("  chat and files: encrypted end-to-end")
+	}
+}
+
+// statusLine is shown in the status bar: the state of the calls and the
+// connections, followed by the update notice if there is one
+func (peer *RTCPeer) statusLine() string {
+	line := peer.callStatus() + "  |  " + peer.connectionsStatus()
+	if peer.dnd {
+		line = dndIcon + " do not disturb  |  " + line
+	}
+	if notice, _ := updateNotice.Load().(string); notice != "" {
+		line += "  |  " + notice
+	}
+	return line
+}
+
+// connectionsStatus summarizes the connections, with a lock next to the ones
+// that are encrypted and a mark on the ones being recorded
+func (peer *RTCPeer) connectionsStatus() string {
+	conns := peer.sortedConnections()
+	if len(conns) == 0 {
+		return "no connections"
+	}
+	parts := make([]string, len(conns))
+	for i, conn := range conns {
+		remote := conn.remoteAddr
+		parts[i] = remote
+		if conn.Crypto().Secure {
+			parts[i] = lockIcon + " " + parts[i]
+		}
+		if conn.recorder != nil {
+			parts[i] += " " + recordIcon
+		}
+		if remote == peer.focus && len(conns) > 1 {
+			parts[i] += " " + focusIcon
+		}
+		if icon := conn.deliveryIcon(); icon != "" {
+			parts[i] += " " + icon
+		}
+		if conn.state == InCall && conn.mode != TextConnection {
+			parts[i] += " " + formatDuration(conn.duration()) +
+				" " + conn.rateStatus()
+		}
+	}
+	return strings.Join(parts, "  ")
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// sfu forwards the tracks received in each call to all the other calls as
+// they are, without decoding them, so that a small group call can be hosted
+// by a single well connected peer
+type sfu struct {
+	// mu guards tracks and the senders of each of them, which the goroutines
+	// of every track change
+	mu     sync.Mutex
+	tracks []*forwardedTrack
+}
+
+// negotiation makes the renegotiations of a call wait for the answer to the
+// one before, offers crossing each other would fail both
+type negotiation struct {
+	mu sync.Mutex
+	// since is when the offer waiting for an answer was sent, zero if none
+	// is. again is set when the tracks changed again in the meantime
+	since time.Time
+	again bool
+}
+
+// forwardedTrack is a track received from one call and sent on to the others
+type forwardedTrack struct {
+	from  *Connection
+	ssrc  webrtc.SSRC
+	local *webrtc.TrackLocalStaticRTP
+	// senders send local in the other calls, by remote
+	senders map[string]*webrtc.RTPSender
+}
+
+// SetSFU starts or stops forwarding the tracks of every call to the others
+func (peer *RTCPeer) SetSFU(on bool) {
+	if on == (peer.sfu != nil) {
+		return
+	}
+	if on {
+		peer.sfu = &sfu{}
+		log.Println("forwarding the media of every call to the others")
+		return
+	}
+	s := peer.sfu
+	s.mu.Lock()
+	tracks := append([]*forwardedTrack(nil), s.tracks...)
+	s.mu.Unlock()
+	for _, fwd := range tracks {
+		fwd.stop()
+	}
+	peer.sfu = nil
+	log.Println("stopped forwarding media between calls")
+}
+
+// forwardTrack starts forwarding track, received from the remote of conn, to
+// the other calls. It returns nil when we aren't forwarding
+func (conn *Connection) forwardTrack(track *webrtc.TrackRemote) *forwardedTrack {
+	s := conn.local.sfu
+	if s == nil || conn.mode == TextConnection {
+		return nil
+	}
+	local, err := webrtc.NewTrackLocalStaticRTP(
+		track.Codec().RTPCodecCapability,
+		track.ID(),
+		conn.remoteAddr,
+	)
+	if err != nil {
+		logError("couldn't forward a track of", conn, ":", err)
+		return nil
+	}
+	fwd := &forwardedTrack{
+		from:    conn,
+		ssrc:    track.SSRC(),
+		local:   local,
+		senders: make(map[string]*webrtc.RTPSender),
+	}
+	var changed []*Connection
+	s.mu.Lock()
+	s.tracks = append(s.tracks, fwd)
+	for _, other := range conn.local.Connections {
+		if fwd.sendTo(other) {
+			changed = append(changed, other)
+		}
+	}
+	s.mu.Unlock()
+	for _, other := range changed {
+		other.renegotiate()
+	}
+	logDebug("forwarding", track.Kind(), "of", conn, "to the other calls")
+	return fwd
+}
+
+// sendTo adds the forwarded track to the call with conn, telling whether it
+// needs a renegotiation. The lock of the sfu has to be held
+func (fwd *forwardedTrack) sendTo(conn *Connection) bool {
+	if conn == fwd.from || conn.state != InCall ||
+		conn.mode == TextConnection {
+		return false
+	}
+	if _, ok := fwd.senders[conn.remoteAddr]; ok {
+		return false
+	}
+	sender, err := conn.peer.AddTrack(fwd.local)
+	if err != nil {
+		logError("couldn't forward", fwd.from, "to", conn, ":", err)
+		return false
+	}
+	fwd.senders[conn.remoteAddr] = sender
+	// Keyframes are asked to the one who sends the video
+	go func() {
+		for {
+			packets, _, err := sender.ReadRTCP()
+			if err != nil {
+				return
+			}
+			for _, packet := range packets {
+				if _, ok := packet.(*rtcp.PictureLossIndication); !ok {
+					continue
+				}
+				err := fwd.from.peer.WriteRTCP([]rtcp.Packet{
+					&rtcp.PictureLossIndication{MediaSSRC: uint32(fwd.ssrc)},
+				})
+				if err != nil {
+					logError("RTCP error:", err)
+				}
+			}
+		}
+	}()
+	return true
+}
+
+// write forwards an RTP packet of the track
+func (fwd *forwardedTrack) write(packet []byte) {
+	if fwd == nil {
+		return
+	}
+	if _, err := fwd.local.Write(packet); err != nil {
+		logError("couldn't forward a packet of", fwd.from, ":", err)
+	}
+}
+
+// stop removes the forwarded track from the calls it is sent in
+func (fwd *forwardedTrack) stop() {
+	if fwd == nil {
+		return
+	}
+	s := fwd.from.local.sfu
+	if s == nil {
+		return
+	}
+	var changed []*Connection
+	s.mu.Lock()
+	for remote, sender := range fwd.senders {
+		conn, ok := fwd.from.local.Connections[remote]
+		if !ok || conn.state != InCall {
+			continue
+		}
+		if err := conn.peer.RemoveTrack(sender); err != nil {
+			logError("couldn't stop forwarding", fwd.from, "to", conn,
+				":", err)
+			continue
+		}
+		changed = append(changed, conn)
+	}
+	fwd.senders = nil
+	for i, other := range s.tracks {
+		if other == fwd {
+			s.tracks = append(s.tracks[:i], s.tracks[i+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+	for _, conn := range changed {
+		conn.renegotiate()
+	}
+}
+
+// joinSFU sends conn the tracks forwarded from the calls made before it
+func (conn *Connection) joinSFU() {
+	s := conn.local.sfu
+	if s == nil {
+		return
+	}
+	added := false
+	s.mu.Lock()
+	for _, fwd := range s.tracks {
+		if fwd.sendTo(conn) {
+			added = true
+		}
+	}
+	s.mu.Unlock()
+	if added {
+		conn.renegotiate()
+	}
+}
+
+// renegotiate offers the remote the tracks of the call after they changed.
+// While an offer waits for its answer, the next one is sent once it comes,
+// or after restartTimeout if it never does
+func (conn *Connection) renegotiate() {
+	n := &conn.negotiation
+	n.mu.Lock()
+	if !n.since.IsZero() && time.Since(n.since) < restartTimeout {
+		n.again = true
+		n.mu.Unlock()
+		return
+	}
+	n.since = time.Now()
+	n.again = false
+	n.mu.Unlock()
+
+	offer, err := conn.peer.CreateOffer(nil)
+	if err == nil {
+		err = conn.peer.SetLocalDescription(offer)
+	}
+	if err == nil {
+		err = postSignal(conn.remoteAddr, SignalSDP{
+			SDP:         offer,
+			Action:      Renegotiate,
+			Origin:      conn.local.listenAddr,
+			SignalStamp: newSignalStamp(),
+		})
+	}
+	if err != nil {
+		logError("couldn't renegotiate the call with", conn, ":", err)
+		conn.negotiated()
+	}
+}
+
+// negotiated is called when the answer to an offer of ours comes, and sends
+// the next renegotiation if the tracks changed while waiting for it
+func (conn *Connection) negotiated() {
+	n := &conn.negotiation
+	n.mu.Lock()
+	again := n.again
+	n.since = time.Time{}
+	n.again = false
+	n.mu.Unlock()
+	if again {
+		conn.renegotiate()
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+)
+
+// Width of the connections sidebar
+const sidebarWidth = 28
+
+var stateNames = map[ConnectionState]string{
+	Standby:   "standby",
+	Ringing:   "ringing",
+	Answering: "answering",
+	InCall:    "in call",
+	Closed:    "closed",
+}
+
+// sidebarEntry is a connection as listed in the sidebar
+type sidebarEntry struct {
+	remote string
+	// name is the name of the contact, or else the one the peer told
+	name   string
+	detail string
+}
+
+// sidebarEntries lists the connections with their state, mode and how long
+// the call has been going on
+func (peer *RTCPeer) sidebarEntries() []sidebarEntry {
+	var entries []sidebarEntry
+	for _, conn := range peer.sortedConnections() {
+		remote := conn.remoteAddr
+		detail := stateNames[conn.state] + ", " + modeNames[conn.mode]
+		if conn.state == InCall && conn.mode != TextConnection {
+			detail += " " + formatDuration(conn.duration())
+		}
+		if conn.muted {
+			detail += ", muted"
+		}
+		var name string
+		if conn.remoteName != "" {
+			name = selfDeclared(conn.remoteName)
+		}
+		if c, ok := peer.contact(remote); ok && c.Name != "" {
+			name = c.Name
+		}
+		entries = append(entries, sidebarEntry{
+			remote: remote,
+			name:   name,
+			detail: detail,
+		})
+	}
+	return entries
+}
+
+// sidebar lists the connections in the full screen interface. The one
+// highlighted with the arrow keys is the target of /msg, /end and /mute
+// when they are given no address
+type sidebar struct {
+	list    *tview.List
+	entries []sidebarEntry
+	// unread, if set, counts the messages from a remote not seen yet
+	unread func(remote string) int
+	// known, if set, lists the names of the contacts and the connections
+	known func() []string
+}
+
+func newSidebar() *sidebar {
+	return &sidebar{
+		list: tview.NewList().
+			SetHighlightFullLine(true),
+	}
+}
+
+// update lists entries, keeping the same connection highlighted while it is
+// still there. It has to be called from the event loop
+func (s *sidebar) update(entries []sidebarEntry) {
+	selected := s.selected()
+	s.entries = entries
+	s.list.Clear()
+	for i, entry := range entries {
+		label := entry.remote
+		if entry.name != "" {
+			label = entry.name
+		}
+		label = tview.Escape(label)
+		if s.unread != nil {
+			if n := s.unread(entry.remote); n > 0 {
+				label += fmt.Sprintf(" [::b](%d)[::-]", n)
+			}
+		}
+		s.list.AddItem(label, entry.detail, 0, nil)
+		if entry.remote == selected {
+			s.list.SetCurrentItem(i)
+		}
+	}
+}
+
+// move highlights the connection delta places after the current one. It
+// has to be called from the event loop
+func (s *sidebar) move(delta int) {
+	if len(s.entries) == 0 {
+		return
+	}
+	i := s.list.GetCurrentItem() + delta
+	if i < 0 || i >= len(s.entries) {
+		return
+	}
+	s.list.SetCurrentItem(i)
+}
+
+// selected is the address of the highlighted connection, empty if there are
+// none
+func (s *sidebar) selected() string {
+	i := s.list.GetCurrentItem()
+	if i < 0 || i >= len(s.entries) {
+		return ""
+	}
+	return s.entries[i].remote
+}
+
+// target adds the highlighted connection to /accept, /reject, /end, /mute,
+// /unmute, /hold and /resume when they are given no address, and to /msg
+// when its first word isn't the name of a contact or an address
+func (s *sidebar) target(cmd string) string {
+	remote := s.selected()
+	if remote == "" {
+		return cmd
+	}
+	args := strings.SplitN(cmd, " ", 3)
+	switch args[0] {
+	case "/accept", "/reject", "/end", "/mute", "/unmute", "/hold", "/resume":
+		if len(args) == 1 {
+			return args[0] + " " + remote
+		}
+	case "/msg":
+		if len(args) == 1 || s.addressed(args[1]) {
+			return cmd
+		}
+		return "/msg " + remote + strings.TrimPrefix(cmd, "/msg")
+	}
+	return cmd
+}
+
+// addressed tells whether word says who a message is for: an address, or
+// one of the contacts or connections known to s
+func (s *sidebar) addressed(word string) bool {
+	if _, port, err := net.SplitHostPort(word); err == nil && port != "" {
+		return true
+	}
+	for _, entry := range s.entries {
+		if entry.remote == word {
+			return true
+		}
+	}
+	if s.known == nil {
+		return false
+	}
+	for _, name := range s.known() {
+		if strings.EqualFold(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// updateSidebar refreshes s with the output of entries every interval
+func updateSidebar(
+	tapp *tview.Application,
+	s *sidebar,
+	entries func() []sidebarEntry,
+	interval time.Duration,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		list := entries()
+		tapp.QueueUpdateDraw(func() {
+			s.update(list)
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	sleepCheckInterval = 5 * time.Second
+	// The wall clock getting this much ahead of the monotonic one, which
+	// stands still while the system is suspended, means it was
+	sleepThreshold = 10 * time.Second
+	// How long a call has to reconnect after an ICE restart before it is
+	// hung up
+	restartTimeout = 15 * time.Second
+)
+
+// watchSleep pauses the calls before the system is suspended and reconnects
+// them after it resumes. logind announces suspends over D-Bus, where it isn't
+// available resumes are still noticed by the jump of the wall clock
+func (peer *RTCPeer) watchSleep() {
+	go peer.watchPrepareForSleep()
+	go peer.watchClockJumps()
+}
+
+// watchPrepareForSleep follows logind's PrepareForSleep signal through
+// dbus-monitor, true is sent before suspending and false after resuming
+func (peer *RTCPeer) watchPrepareForSleep() {
+	path, err := exec.LookPath("dbus-monitor")
+	if err != nil {
+		return
+	}
+	cmd := exec.Command(path, "--system",
+		"type='signal',interface='org.freedesktop.login1.Manager',"+
+			"member='PrepareForSleep'")
+	out, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		logError("couldn't watch for suspends:", err)
+		return
+	}
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		switch strings.TrimSpace(scanner.Text()) {
+		case "boolean true":
+			peer.suspend(time.Now())
+		case "boolean false":
+			peer.resume(time.Time{})
+		}
+	}
+	cmd.Wait()
+}
+
+func (peer *RTCPeer) watchClockJumps() {
+	last := time.Now()
+	ticker := time.NewTicker(sleepCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		// Round(0) strips the monotonic reading
+		slept := now.Round(0).Sub(last.Round(0)) - now.Sub(last)
+		last = now
+		if slept > sleepThreshold {
+			peer.resume(now.Round(0).Add(-slept))
+		}
+	}
+}
+
+// suspend stops sending media to every call, they are interrupted since at
+func (peer *RTCPeer) suspend(at time.Time) {
+	for _, conn := range peer.Connections {
+		if conn.state != InCall || !conn.sleptAt.IsZero() {
+			continue
+		}
+		conn.sleptAt = at
+		conn.asleep = true
+		log.Println("suspending, the call with", conn, "is interrupted")
+	}
+}
+
+// resume reconnects the calls interrupted by a suspend. at is when the
+// system went to sleep if it was noticed only now, zero if the calls were
+// told beforehand
+func (peer *RTCPeer) resume(at time.Time) {
+	for _, conn := range peer.Connections {
+		if conn.state != InCall {
+			continue
+		}
+		if conn.sleptAt.IsZero() {
+			if at.IsZero() {
+				continue
+			}
+			conn.sleptAt = at
+		} else if !conn.asleep {
+			// Already reconnecting
+			continue
+		}
+		conn.asleep = false
+		go conn.restartICE("woke up")
+	}
+}
+
+// restartICE sends the remote an offer with new ICE credentials, so that
+// both ends gather candidates again on whatever network we are now in. The
+// call is hung up if it doesn't come back within restartTimeout
+func (conn *Connection) restartICE(reason string) {
+	log.Println(reason+", reconnecting to", conn)
+	conn.restarting = true
+	offer, err := conn.peer.CreateOffer(&webrtc.OfferOptions{ICERestart: true})
+	if err == nil {
+		err = conn.peer.SetLocalDescription(offer)
+	}
+	if err == nil {
+		err = postSignal(conn.remoteAddr, SignalSDP{
+			SDP:         offer,
+			Action:      Restart,
+			Origin:      conn.local.listenAddr,
+			SignalStamp: newSignalStamp(),
+		})
+	}
+	if err != nil {
+		logError("couldn't reconnect to", conn, ":", err)
+		conn.Close()
+		return
+	}
+	time.AfterFunc(restartTimeout, func() {
+		if conn.state != InCall || !conn.restarting {
+			return
+		}
+		if conn.peer.ConnectionState() == webrtc.PeerConnectionStateConnected {
+			conn.reconnected()
+			return
+		}
+		log.Println(conn, "didn't come back, hanging up")
+		conn.Close()
+	})
+}
+
+// reconnected resumes a call after an ICE restart, or after a suspend
+func (conn *Connection) reconnected() {
+	if !conn.restarting && conn.sleptAt.IsZero() {
+		return
+	}
+	conn.restarting = false
+	if conn.sleptAt.IsZero() {
+		log.Println("reconnected to", conn)
+		return
+	}
+	lost := time.Now().Round(0).Sub(conn.sleptAt.Round(0))
+	conn.interrupted += lost
+	conn.sleptAt = time.Time{}
+	log.Println("reconnected to", conn, "after", lost.Round(time.Second))
+}
+
+// handleRestart answers the ICE restarts of a remote that woke up, and its
+// renegotiations, and takes the answers to ours. Restarts for calls we no
+// longer have are refused with an HTTP error, for the remote to hang up.
+// Those whose SDP has another certificate than the call are ignored, they
+// don't come from the remote
+func (peer *RTCPeer) handleRestart(w http.ResponseWriter, signal SignalSDP) {
+	conn, ok := peer.Connections[signal.Origin]
+	if !ok || conn.state != InCall {
+		http.Error(w, "no call with "+signal.Origin, http.StatusGone)
+		return
+	}
+	if !conn.sameCertificate(signal) {
+		logWarn("ignored a restart or renegotiation claiming to come "+
+			"from", conn, "with another certificate")
+		http.Error(w, "certificate mismatch", http.StatusForbidden)
+		return
+	}
+	if signal.Action == Renegotiate {
+		// New tracks may come, even in calls we only make
+		conn.peer.OnTrack(conn.handleTrack)
+	}
+	if err := conn.peer.SetRemoteDescription(signal.SDP); err != nil {
+		logError("couldn't set the sdp of", conn, "after a restart:", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if signal.Action == RestartAnswer {
+		if conn.peer.ConnectionState() == webrtc.PeerConnectionStateConnected {
+			conn.reconnected()
+		}
+		go conn.negotiated()
+		return
+	}
+
+	if signal.Action == Restart {
+		log.Println(conn, "woke up, reconnecting")
+	}
+	answer, err := conn.peer.CreateAnswer(nil)
+	if err == nil {
+		err = conn.peer.SetLocalDescription(answer)
+	}
+	if err != nil {
+		logError("couldn't answer the restart of", conn, ":", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	go func() {
+		err := postSignal(conn.remoteAddr, SignalSDP{
+			SDP:         answer,
+			Action:      RestartAnswer,
+			Origin:      peer.listenAddr,
+			SignalStamp: newSignalStamp(),
+		})
+		if err != nil {
+			logError("couldn't answer the restart of", conn, ":", err)
+		}
+	}()
+}
+
+// postSignal sends signal to the signaling endpoint of remote
+func postSignal(remote string, signal SignalSDP) error {
+	payload, err := json.Marshal(signal)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(
+		fmt.Sprintf("http://%s/sdp", remote),
+		"application/json; charset=utf-8",
+		bytes.NewReader(payload),
+	)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("signaling failed: %s", resp.Status)
+	}
+	return nil
+}
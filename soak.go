@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"time"
+)
+
+const (
+	// How often the soak test reports
+	soakInterval = time.Minute
+	// Value of -soak for the instance that only answers
+	soakAnswer = "answer"
+)
+
+// Flags for testing wrtcion itself, left out of -help
+var hiddenFlags = map[string]bool{"soak": true}
+
+func init() {
+	flag.Usage = func() {
+		visible := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		visible.SetOutput(flag.CommandLine.Output())
+		flag.VisitAll(func(f *flag.Flag) {
+			if !hiddenFlags[f.Name] {
+				visible.Var(f.Value, f.Name, f.Usage)
+			}
+		})
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		visible.PrintDefaults()
+	}
+}
+
+// countSample adds d to the audio sent to the remote of conn, to measure how
+// far the timestamps of the audio drift from the wall clock
+func (conn *Connection) countSample(d time.Duration) {
+	if conn.firstSample.IsZero() {
+		conn.firstSample = time.Now()
+	}
+	conn.sentAudio += d
+}
+
+// audioDrift is how much more wall clock time has passed than audio has been
+// sent since the first sample. It grows when samples are sent too slowly
+func (conn *Connection) audioDrift() time.Duration {
+	if conn.firstSample.IsZero() {
+		return 0
+	}
+	return time.Since(conn.firstSample) - conn.sentAudio
+}
+
+func soakReport(rtcpeer *RTCPeer, started time.Time) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	log.Printf("soak: up %s, heap %d KiB, sys %d KiB, %d gc, %d goroutines\n",
+		time.Since(started).Round(time.Second),
+		mem.HeapAlloc/1024,
+		mem.Sys/1024,
+		mem.NumGC,
+		runtime.NumGoroutine(),
+	)
+	for _, conn := range rtcpeer.Connections {
+		if conn.state != InCall {
+			continue
+		}
+		stats := conn.Stats()
+		log.Printf(
+			"soak: %s up %s, audio drift %s, loss %.1f%%, jitter %s, rtt %s\n",
+			conn,
+			time.Since(conn.started).Round(time.Second),
+			conn.audioDrift().Round(time.Millisecond),
+			stats.FractionLost*100,
+			stats.Jitter,
+			stats.RTT,
+		)
+	}
+}
+
+// soakMain keeps a call with remote up for as long as it runs, calling again
+// whenever it drops, and periodically logs what tends to leak or drift over
+// long calls. With remote set to soakAnswer it only answers, for the other
+// end of the test. The sample file loops instead of ending the call
+func soakMain(rtcpeer *RTCPeer, flog io.Writer, remote string) {
+	log.SetOutput(io.MultiWriter(flog, os.Stdout))
+	go rtcpeer.Listen()
+	started := time.Now()
+	ticker := time.NewTicker(soakInterval)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		if _, ok := rtcpeer.Connections[remote]; !ok && remote != soakAnswer {
+			log.Println("soak: calling", remote)
+			rtcpeer.Ring(remote, VoiceConnectionDuplex)
+		}
+		soakReport(rtcpeer, started)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// defaultSocketPath is where the command socket is made, in the runtime
+// directory of the user. There is none without one
+func defaultSocketPath() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "wrtcion.sock")
+}
+
+// serveCommands listens at the -socket path for connections sending
+// commands, one per line, the same ones typed in the interface, and gives
+// them to run. It does nothing if another instance already has the socket
+func serveCommands(run func(cmd string)) {
+	path := *sockpt
+	if path == "" {
+		return
+	}
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		logWarn("command socket", path, "in use by another instance")
+		return
+	}
+	// Left behind by an instance that didn't exit cleanly
+	os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		logError("unable to make command socket:", err)
+		return
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		logError("unable to restrict command socket:", err)
+		ln.Close()
+		return
+	}
+	logDebug("command socket listening at", path)
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			logError("command socket:", err)
+			return
+		}
+		go readCommands(c, run)
+	}
+}
+
+func readCommands(c net.Conn, run func(cmd string)) {
+	defer c.Close()
+	scanner := bufio.NewScanner(c)
+	for scanner.Scan() {
+		if scanner.Text() == "" {
+			continue
+		}
+		logDebug("socket:", scanner.Text())
+		run(scanner.Text())
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Layouts of the times shown before lines and of the days between them,
+	// unless the config file sets others
+	defaultTimeFormat = "15:04"
+	defaultDayFormat  = "Monday, 2 January 2006"
+	// logFileFormat is what the log file is stamped with, like log does
+	logFileFormat = "2006/01/02 15:04:05"
+	// noFormat in the config file turns the times or the days off
+	noFormat = "none"
+)
+
+// stampedWriter writes every line to w after the time it was written at,
+// and a line with the day before the first line of every day. Either is
+// left out when its layout is empty
+type stampedWriter struct {
+	w         io.Writer
+	timeFmt   string
+	dayFmt    string
+	mu        sync.Mutex
+	lastDay   string
+	lineStart bool
+}
+
+func newStampedWriter(w io.Writer, timeFmt, dayFmt string) *stampedWriter {
+	return &stampedWriter{
+		w:         w,
+		timeFmt:   timeFmt,
+		dayFmt:    dayFmt,
+		lineStart: true,
+	}
+}
+
+// stampFormats returns the layouts set in cfg, defaults if they aren't
+func stampFormats(cfg Config) (timeFmt, dayFmt string) {
+	timeFmt, dayFmt = defaultTimeFormat, defaultDayFormat
+	if cfg.TimeFormat != "" {
+		timeFmt = cfg.TimeFormat
+	}
+	if cfg.DayFormat != "" {
+		dayFmt = cfg.DayFormat
+	}
+	if timeFmt == noFormat {
+		timeFmt = ""
+	}
+	if dayFmt == noFormat {
+		dayFmt = ""
+	}
+	return timeFmt, dayFmt
+}
+
+func (s *stampedWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(string(p), "\n") {
+		if line == "" {
+			continue
+		}
+		if s.lineStart {
+			if s.dayFmt != "" {
+				if day := now.Format(s.dayFmt); day != s.lastDay {
+					b.WriteString("── " + day + " ──\n")
+					s.lastDay = day
+				}
+			}
+			if s.timeFmt != "" {
+				b.WriteString(now.Format(s.timeFmt) + " ")
+			}
+		}
+		b.WriteString(line)
+		s.lineStart = strings.HasSuffix(line, "\n")
+	}
+	if _, err := io.WriteString(s.w, b.String()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
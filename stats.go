@@ -0,0 +1,162 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Yaroslav-95/wrtcion/gst"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Seconds between the NTP epoch (1900) and the Unix epoch
+const ntpEpochOffset = 2208988800
+
+// CallStats is the quality of the media we send, as last reported by the
+// remote in its RTCP receiver reports
+type CallStats struct {
+	// FractionLost is the share of packets lost since the previous report,
+	// between 0 and 1
+	FractionLost float64
+	Jitter       time.Duration
+	// RTT is zero until a report referring to one of our sender reports has
+	// been received
+	RTT     time.Duration
+	Updated time.Time
+}
+
+// statsTracker keeps the quality reported for each of the tracks we send,
+// by SSRC, so that the reports about audio and video don't overwrite each
+// other
+type statsTracker struct {
+	mu     sync.Mutex
+	tracks map[uint32]CallStats
+}
+
+// ntpMiddle returns the middle 32 bits of the NTP timestamp of t, the format
+// used by the LSR and DLSR fields of receiver reports
+func ntpMiddle(t time.Time) uint32 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return uint32((secs<<32 | frac) >> 16)
+}
+
+// update takes the report about ssrc out of an RTCP packet. clockRate is the
+// one of the track, needed to turn the jitter into time
+func (t *statsTracker) update(pkt rtcp.Packet, ssrc uint32, clockRate uint32) {
+	rr, ok := pkt.(*rtcp.ReceiverReport)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	for _, report := range rr.Reports {
+		if report.SSRC != ssrc {
+			continue
+		}
+		t.mu.Lock()
+		if t.tracks == nil {
+			t.tracks = make(map[uint32]CallStats)
+		}
+		stats := t.tracks[ssrc]
+		stats.FractionLost = float64(report.FractionLost) / 256
+		stats.Jitter = time.Duration(report.Jitter) * time.Second /
+			time.Duration(clockRate)
+		if report.LastSenderReport != 0 {
+			delta := ntpMiddle(now) - report.LastSenderReport - report.Delay
+			// Clocks too far off give nonsense, keep the previous value
+			if delta < 1<<31 {
+				stats.RTT = time.Duration(delta) * time.Second / 65536
+			}
+		}
+		stats.Updated = now
+		t.tracks[ssrc] = stats
+		t.mu.Unlock()
+	}
+}
+
+// get returns the stats of the track sent by sender
+func (t *statsTracker) get(sender *webrtc.RTPSender) CallStats {
+	if sender == nil {
+		return CallStats{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tracks[senderSSRC(sender)]
+}
+
+// Stats returns the latest quality figures reported by the remote for the
+// audio we send to it
+func (conn *Connection) Stats() CallStats {
+	if conn.audioSndr == nil {
+		return CallStats{}
+	}
+	return conn.stats.get(conn.audioSndr.rtp)
+}
+
+// VideoStats is like Stats, for the video we send
+func (conn *Connection) VideoStats() CallStats {
+	if conn.videoSndr == nil {
+		return CallStats{}
+	}
+	return conn.stats.get(conn.videoSndr.rtp)
+}
+
+func senderSSRC(sender *webrtc.RTPSender) uint32 {
+	return uint32(sender.GetParameters().Encodings[0].SSRC)
+}
+
+// handleAudioRTCP keeps the stats up to date with the reports about our
+// audio track
+func (conn *Connection) handleAudioRTCP() {
+	ssrc := senderSSRC(conn.audioSndr.rtp)
+	clockRate := audioCodec.ClockRate
+	readSenderRTCP(conn.audioSndr.rtp, func(pkt rtcp.Packet) {
+		conn.stats.update(pkt, ssrc, clockRate)
+		conn.prioritize(pkt, ssrc)
+	})
+}
+
+// ShowStats logs the call quality figures of the connection to remote
+func (peer *RTCPeer) ShowStats(remote string) {
+	conn, ok := peer.Connections[remote]
+	if !ok {
+		log.Println("not connected to", remote)
+		return
+	}
+	log.Println("stats for", conn)
+	conn.logCrypto()
+	log.Println("  ice policy:", conn.icePolicy())
+	if conn.videoSndr != nil {
+		log.Println("  priority:", conn.priority)
+	}
+	if jack, ok := gst.JACKStats(); ok {
+		log.Printf("  jack: %d Hz, %d xruns\n", jack.SampleRate, jack.Xruns)
+	}
+	if conn.audioRcvr != nil {
+		played, concealed := conn.audioRcvr.pipeline.Concealment()
+		if total := played + concealed; total > 0 {
+			log.Printf("  concealed audio: %.1f%% (%d of %d packets)\n",
+				float64(concealed)*100/float64(total), concealed, total)
+		}
+	}
+	logQuality("audio", conn.Stats())
+	if conn.videoSndr != nil {
+		logQuality("video", conn.VideoStats())
+	}
+}
+
+// logQuality logs the stats of the track of media kind
+func logQuality(kind string, stats CallStats) {
+	if stats.Updated.IsZero() {
+		log.Println("  no", kind, "quality reports yet")
+		return
+	}
+	log.Printf("  %s quality reported %s ago:\n", kind,
+		time.Since(stats.Updated).Round(time.Second))
+	log.Printf("    packets lost: %.1f%%\n", stats.FractionLost*100)
+	log.Println("    jitter:", stats.Jitter)
+	if stats.RTT > 0 {
+		log.Println("    round trip time:", stats.RTT)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const mutedIcon = "🔇muted"
+
+// sortedConnections returns the connections by address. The status is drawn
+// from other goroutines than the ones adding and closing connections, so
+// they are only walked this way there
+func (peer *RTCPeer) sortedConnections() []*Connection {
+	peer.connsMu.RLock()
+	conns := make([]*Connection, 0, len(peer.Connections))
+	for _, conn := range peer.Connections {
+		conns = append(conns, conn)
+	}
+	peer.connsMu.RUnlock()
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].remoteAddr < conns[j].remoteAddr
+	})
+	return conns
+}
+
+// callStatus leads the status bar: where we listen, how many calls there
+// are, the state of the current one, whether it is muted, whether any call
+// is being recorded, and the bitrate of all of them together
+func (peer *RTCPeer) callStatus() string {
+	parts := []string{"on " + peer.listenAddr}
+	calls, recording := 0, false
+	var up, down float64
+	conns := peer.sortedConnections()
+	for _, conn := range conns {
+		if conn.mode == TextConnection || conn.state == Closed {
+			continue
+		}
+		calls++
+		if conn.recorder != nil {
+			recording = true
+		}
+		if conn.state == InCall {
+			u, d := conn.rates.rates()
+			up += u
+			down += d
+		}
+	}
+	if calls == 1 {
+		parts = append(parts, "1 call")
+	} else {
+		parts = append(parts, fmt.Sprintf("%d calls", calls))
+	}
+	if conn := currentCall(conns, peer.focus); conn != nil {
+		state := stateNames[conn.state] + " " + conn.remoteAddr
+		if conn.muted {
+			state += " " + mutedIcon
+		}
+		parts = append(parts, state)
+	}
+	if recording {
+		parts = append(parts, recordIcon)
+	}
+	if calls > 0 {
+		parts = append(parts, fmt.Sprintf("↑%.0f ↓%.0f kb/s", up, down))
+	}
+	return strings.Join(parts, "  ")
+}
+
+// currentCall is the call the microphone goes to, or else the first one by
+// address, nil if there are no calls
+func (peer *RTCPeer) currentCall() *Connection {
+	return currentCall(peer.sortedConnections(), peer.focus)
+}
+
+// currentCall picks the call focused on among conns, sorted by address
+func currentCall(conns []*Connection, focus string) *Connection {
+	var first *Connection
+	for _, conn := range conns {
+		if conn.mode == TextConnection || conn.state == Closed {
+			continue
+		}
+		if conn.remoteAddr == focus {
+			return conn
+		}
+		if first == nil {
+			first = conn
+		}
+	}
+	return first
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"hash/fnv"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// theme colors the full screen interface. The message colors are tview
+// color tags, empty to leave the text in the color of the theme
+type theme struct {
+	styles tview.Theme
+	own    string
+	system string
+	errors string
+	// peers are given to the peers in turn, by their address
+	peers []string
+}
+
+// themes can be picked with Theme in the config file. The default one is
+// tview's, none leaves every color to the terminal
+var themes = map[string]theme{
+	"default": {
+		styles: tview.Styles,
+		own:    "green",
+		system: "gray",
+		errors: "red",
+		peers:  []string{"aqua", "yellow", "fuchsia", "lightskyblue", "orange"},
+	},
+	"light": {
+		styles: tview.Theme{
+			PrimitiveBackgroundColor:    tcell.ColorWhite,
+			ContrastBackgroundColor:     tcell.ColorLightGray,
+			MoreContrastBackgroundColor: tcell.ColorSilver,
+			BorderColor:                 tcell.ColorGray,
+			TitleColor:                  tcell.ColorBlack,
+			GraphicsColor:               tcell.ColorGray,
+			PrimaryTextColor:            tcell.ColorBlack,
+			SecondaryTextColor:          tcell.ColorNavy,
+			TertiaryTextColor:           tcell.ColorGreen,
+			InverseTextColor:            tcell.ColorWhite,
+			ContrastSecondaryTextColor:  tcell.ColorNavy,
+		},
+		own:    "darkgreen",
+		system: "gray",
+		errors: "darkred",
+		peers:  []string{"navy", "purple", "teal", "maroon", "olive"},
+	},
+	"high-contrast": {
+		styles: highContrastTheme,
+		own:    "white",
+		system: "white",
+		errors: "yellow",
+		peers:  []string{"yellow"},
+	},
+	"none": {
+		styles: tview.Theme{
+			PrimitiveBackgroundColor:    tcell.ColorDefault,
+			ContrastBackgroundColor:     tcell.ColorDefault,
+			MoreContrastBackgroundColor: tcell.ColorDefault,
+			BorderColor:                 tcell.ColorDefault,
+			TitleColor:                  tcell.ColorDefault,
+			GraphicsColor:               tcell.ColorDefault,
+			PrimaryTextColor:            tcell.ColorDefault,
+			SecondaryTextColor:          tcell.ColorDefault,
+			TertiaryTextColor:           tcell.ColorDefault,
+			InverseTextColor:            tcell.ColorDefault,
+			ContrastSecondaryTextColor:  tcell.ColorDefault,
+		},
+	},
+}
+
+// pickTheme returns the theme named in the config file, overridden by
+// -high-contrast and by the NO_COLOR environment variable
+func pickTheme(name string) theme {
+	if *hicon {
+		name = "high-contrast"
+	} else if os.Getenv("NO_COLOR") != "" {
+		name = "none"
+	}
+	if name == "" {
+		name = "default"
+	}
+	t, ok := themes[name]
+	if !ok {
+		logWarn("unknown theme", name, "using the default one")
+		t = themes["default"]
+	}
+	return t
+}
+
+// peerColor is the color of the lines of remote
+func (t theme) peerColor(remote string) string {
+	if len(t.peers) == 0 {
+		return ""
+	}
+	h := fnv.New32a()
+	h.Write([]byte(remote))
+	return t.peers[h.Sum32()%uint32(len(t.peers))]
+}
+
+// systemColor is the color of a line of the system pane: what we entered,
+// errors and warnings, and everything else
+func (t theme) systemColor(line string) string {
+	switch {
+	case strings.HasPrefix(line, "you:"):
+		return t.own
+	case lineLevel(line) >= levelWarn:
+		return t.errors
+	}
+	return t.system
+}
+
+// chatColor is the color of a line of the chat with remote: ours or theirs
+func (t theme) chatColor(remote string) func(line string) string {
+	return func(line string) string {
+		if strings.HasPrefix(line, "me:") {
+			return t.own
+		}
+		return t.peerColor(remote)
+	}
+}
+
+// styledWriter writes every line to w in the color picked for it, escaping
+// what would be taken for color tags. The view behind w needs dynamic
+// colors
+type styledWriter struct {
+	w     io.Writer
+	color func(line string) string
+}
+
+func (s styledWriter) Write(p []byte) (int, error) {
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(string(p), "\n") {
+		text := strings.TrimSuffix(line, "\n")
+		if text != "" {
+			if color := s.color(text); color != "" {
+				b.WriteString("[" + color + "]" + tview.Escape(text) + "[-]")
+			} else {
+				b.WriteString(tview.Escape(text))
+			}
+		}
+		if strings.HasSuffix(line, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	if _, err := io.WriteString(s.w, b.String()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
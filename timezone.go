@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeZone is the time zone a peer advertises while signaling, so that its
+// local time can be shown next to ours
+type TimeZone struct {
+	// Name is the abbreviation of the zone, e.g. CET
+	Name string
+	// Offset is in seconds east of UTC
+	Offset int
+}
+
+func localTimeZone() *TimeZone {
+	name, offset := time.Now().Zone()
+	return &TimeZone{Name: name, Offset: offset}
+}
+
+func (tz *TimeZone) location() *time.Location {
+	return time.FixedZone(tz.Name, tz.Offset)
+}
+
+// formatTimes renders t in our local time and, if their zone is known, in
+// the remote's local time too
+func formatTimes(t time.Time, theirs *TimeZone) string {
+	ours := t.Local().Format("15:04")
+	if theirs == nil {
+		return ours
+	}
+	_, offset := t.Local().Zone()
+	if offset == theirs.Offset {
+		return ours + " (same time zone)"
+	}
+	return fmt.Sprintf("%s your time / %s their time",
+		ours, t.In(theirs.location()).Format("15:04 MST"))
+}
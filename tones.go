@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/Yaroslav-95/wrtcion/gst"
+)
+
+// startTone plays the audio file at path in a loop until stopTone is
+// called, nothing is played if path is empty
+func (conn *Connection) startTone(path string) {
+	conn.stopTone()
+	if path == "" {
+		return
+	}
+	tone, err := gst.CreatePlayer(path)
+	if err != nil {
+		logError("couldn't play", path, ":", err)
+		return
+	}
+	conn.tone = tone
+	conn.tone.Start()
+}
+
+func (conn *Connection) stopTone() {
+	if conn.tone == nil {
+		return
+	}
+	conn.tone.Stop()
+	conn.tone = nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// mtuPayloader splits payloads in packets no larger than the path to the
+// remote takes, which can change during the call
+type mtuPayloader struct {
+	rtp.Payloader
+	mtu uint32
+}
+
+func (p *mtuPayloader) Payload(mtu uint16, payload []byte) [][]byte {
+	// The packetizer already took the RTP header off mtu
+	if limit := uint16(atomic.LoadUint32(&p.mtu)) - 12; limit < mtu {
+		mtu = limit
+	}
+	return p.Payloader.Payload(mtu, payload)
+}
+
+// sampleTrack sends samples like Pion's TrackLocalStaticSample, but in RTP
+// packets sized to the path MTU instead of always rtpOutboundMTU
+type sampleTrack struct {
+	*webrtc.TrackLocalStaticRTP
+	mu         sync.Mutex
+	payloader  *mtuPayloader
+	packetizer rtp.Packetizer
+	clockRate  float64
+}
+
+func newSampleTrack(
+	c webrtc.RTPCodecCapability,
+	id, streamID string,
+	mtu int,
+) (*sampleTrack, error) {
+	var payloader rtp.Payloader
+	switch strings.ToLower(c.MimeType) {
+	case strings.ToLower(webrtc.MimeTypeOpus):
+		payloader = &codecs.OpusPayloader{}
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		payloader = &codecs.VP8Payloader{EnablePictureID: true}
+	default:
+		return nil, webrtc.ErrNoPayloaderForCodec
+	}
+	track, err := webrtc.NewTrackLocalStaticRTP(c, id, streamID)
+	if err != nil {
+		return nil, err
+	}
+	t := &sampleTrack{
+		TrackLocalStaticRTP: track,
+		payloader:           &mtuPayloader{Payloader: payloader},
+		clockRate:           float64(c.ClockRate),
+	}
+	t.setMTU(mtu)
+	// The payload type and SSRC are those of each binding, set when writing
+	t.packetizer = rtp.NewPacketizer(
+		rtpOutboundMTU,
+		0,
+		0,
+		t.payloader,
+		rtp.NewRandomSequencer(),
+		c.ClockRate,
+	)
+	return t, nil
+}
+
+// setMTU makes the packets sent from now on no larger than mtu bytes of RTP
+func (t *sampleTrack) setMTU(mtu int) {
+	atomic.StoreUint32(&t.payloader.mtu, uint32(mtu))
+}
+
+// WriteSample packetizes sample and sends it to every binding of the track
+func (t *sampleTrack) WriteSample(sample media.Sample) error {
+	samples := uint32(sample.Duration.Seconds() * t.clockRate)
+	t.mu.Lock()
+	if sample.PrevDroppedPackets > 0 {
+		t.packetizer.SkipSamples(samples * uint32(sample.PrevDroppedPackets))
+	}
+	packets := t.packetizer.Packetize(sample.Data, samples)
+	t.mu.Unlock()
+	for _, p := range packets {
+		if err := t.WriteRTP(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import "log"
+
+// Transfer asks remote to call target instead of us. The call with remote is
+// hung up by remote once it is connected to target
+func (peer *RTCPeer) Transfer(remote, target string) {
+	conn, ok := peer.Connections[remote]
+	if !ok || conn.state != InCall || conn.mode == TextConnection {
+		log.Println("not in a call with", remote)
+		return
+	}
+	if !peer.requireCap(remote, CapTransfer) {
+		return
+	}
+	err := conn.sendControl(ControlMessage{Action: Refer, Target: target})
+	if err != nil {
+		logError("couldn't transfer", remote, ":", err)
+		return
+	}
+	log.Println("transferring", remote, "to", target)
+}
+
+// handleRefer calls target as asked by the remote of conn, the call with conn
+// is kept until the new one connects
+func (conn *Connection) handleRefer(target string) {
+	peer := conn.local
+	if conn.state != InCall || target == "" {
+		return
+	}
+	log.Println(conn, "is transferring you to", target)
+	peer.transfers[target] = conn.remoteAddr
+	peer.RingWith(target, conn.mode, conn.settings)
+	if _, ok := peer.Connections[target]; !ok && peer.pendingCall == nil {
+		// The call didn't even start
+		conn.failTransfer(target)
+	}
+}
+
+// completeTransfer hangs up the call conn was transferred from, now that conn
+// is connected
+func (conn *Connection) completeTransfer() {
+	peer := conn.local
+	from, ok := peer.transfers[conn.remoteAddr]
+	if !ok {
+		return
+	}
+	delete(peer.transfers, conn.remoteAddr)
+	old, ok := peer.Connections[from]
+	if !ok {
+		return
+	}
+	err := old.sendControl(ControlMessage{
+		Action: Transferred,
+		Target: conn.remoteAddr,
+	})
+	if err != nil {
+		logError("couldn't tell", old, "about the transfer:", err)
+	}
+	log.Println("transferred from", old, "to", conn)
+	old.Close()
+}
+
+// failTransfer tells the remote that transferred us to target that the call
+// to target didn't connect
+func (conn *Connection) failTransfer(target string) {
+	peer := conn.local
+	from, ok := peer.transfers[target]
+	if !ok {
+		return
+	}
+	delete(peer.transfers, target)
+	log.Println("couldn't be transferred to", target)
+	old, ok := peer.Connections[from]
+	if !ok {
+		return
+	}
+	err := old.sendControl(ControlMessage{
+		Action: TransferFailed,
+		Target: target,
+	})
+	if err != nil {
+		logError("couldn't tell", old, "the transfer failed:", err)
+	}
+}
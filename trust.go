@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	identityPath = outputPath + "identity.pem"
+	trustedPath  = outputPath + "trusted.json"
+	// Pion's own certificates only last a month, ours is our identity
+	identityLifetime = 10 * 365 * 24 * time.Hour
+)
+
+// loadIdentity reads the certificate we use for every connection, creating
+// it the first time. Pion would otherwise generate a new one for each
+// connection, and peers could never pin it
+func loadIdentity() (*webrtc.Certificate, crypto.Signer, error) {
+	if pems, err := os.ReadFile(identityPath); err == nil {
+		cert, err := webrtc.CertificateFromPEM(string(pems))
+		if err != nil {
+			return nil, nil, err
+		}
+		key, err := identityKey(pems)
+		return cert, key, err
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := webrtc.NewCertificate(key, x509.Certificate{
+		Subject:      pkix.Name{CommonName: "wrtcion"},
+		NotBefore:    time.Now().AddDate(0, 0, -1),
+		NotAfter:     time.Now().Add(identityLifetime),
+		SerialNumber: serial,
+		Version:      2,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	pems, err := cert.PEM()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := os.MkdirAll(outputPath, 0755); err != nil {
+		return nil, nil, err
+	}
+	return cert, key, os.WriteFile(identityPath, []byte(pems), 0600)
+}
+
+// normalizeFingerprint accepts fingerprints with or without the algorithm,
+// in any case
+func normalizeFingerprint(fp string) string {
+	fp = strings.ToUpper(strings.TrimSpace(fp))
+	if i := strings.LastIndex(fp, " "); i >= 0 {
+		fp = fp[i+1:]
+	}
+	return "sha-256 " + fp
+}
+
+func (peer *RTCPeer) loadTrusted() {
+	data, err := os.ReadFile(trustedPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logError("couldn't read trusted certificates:", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &peer.trusted); err != nil {
+		logError("couldn't parse trusted certificates:", err)
+	}
+}
+
+// Trust pins the certificate fingerprint of remote. Without a fingerprint,
+// the one remote is using in the current connection is pinned
+func (peer *RTCPeer) Trust(remote, fp string) {
+	if fp == "" {
+		conn, ok := peer.Connections[remote]
+		if !ok {
+			log.Println("not connected to", remote,
+				"give its fingerprint instead")
+			return
+		}
+		fp = conn.Crypto().RemoteFingerprint
+		if fp == "" {
+			log.Println("the connection to", remote, "isn't encrypted yet")
+			return
+		}
+	}
+	peer.trusted[remote] = normalizeFingerprint(fp)
+	data, err := json.MarshalIndent(peer.trusted, "", "\t")
+	if err == nil {
+		err = os.MkdirAll(outputPath, 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(trustedPath, data, 0644)
+	}
+	if err != nil {
+		logError("couldn't save trusted certificates:", err)
+		return
+	}
+	log.Println("trusting", remote, "with", peer.trusted[remote])
+}
+
+// Identity logs the fingerprint of our certificate, for remotes to pin
+func (peer *RTCPeer) Identity() {
+	if peer.certificate == nil {
+		log.Println("using a new certificate for every connection")
+		return
+	}
+	fps, err := peer.certificate.GetFingerprints()
+	if err != nil || len(fps) == 0 {
+		logError("couldn't get our fingerprint:", err)
+		return
+	}
+	log.Println("our fingerprint:", fps[0].Algorithm,
+		strings.ToUpper(fps[0].Value))
+}
+
+// sameCertificate checks that the SDP in signal carries the certificate the
+// DTLS connection of conn was made with. Signals only tell who sends them
+// with their Origin, so anybody could otherwise restart or change a call.
+// The certificate is kept by the transport even while the connection is
+// down, like after a suspend
+func (conn *Connection) sameCertificate(signal SignalSDP) bool {
+	t := conn.dtlsTransport()
+	if t == nil {
+		return false
+	}
+	der := t.GetRemoteCertificate()
+	return len(der) > 0 && offerFingerprint(signal) == fingerprint(der)
+}
+
+// ownFingerprint is the fingerprint of our certificate, empty if we use a
+// new one for every connection
+func (peer *RTCPeer) ownFingerprint() string {
+	if peer.certificate == nil {
+		return ""
+	}
+	fps, err := peer.certificate.GetFingerprints()
+	if err != nil || len(fps) == 0 {
+		return ""
+	}
+	return normalizeFingerprint(fps[0].Algorithm + " " + fps[0].Value)
+}
+
+// sentByCallee checks that a refusal or an answer of the call we are making
+// on conn was sent from the host we called, with the certificate pinned for
+// it if there is one
+func (conn *Connection) sentByCallee(r *http.Request, signal SignalSDP) bool {
+	if !conn.isInitiator || !sentFrom(r, conn.remoteAddr) {
+		return false
+	}
+	pinned, ok := conn.local.trusted[conn.remoteAddr]
+	return !ok || normalizeFingerprint(signal.Fingerprint) == pinned
+}
+
+// sentFrom checks that r came from one of the addresses of the host of
+// remote
+func sentFrom(r *http.Request, remote string) bool {
+	from, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		host = remote
+	}
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return false
+	}
+	fromIP := net.ParseIP(from)
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && ip.Equal(fromIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPeerCert closes the connection if the remote's certificate doesn't
+// match the one pinned for it, or if it has none pinned and only known
+// certificates are allowed
+func (conn *Connection) checkPeerCert() bool {
+	fp := conn.Crypto().RemoteFingerprint
+	pinned, ok := conn.local.trusted[conn.remoteAddr]
+	switch {
+	case ok && pinned != fp:
+		logError(fmt.Sprintf("the certificate of %s changed from %s to %s, hanging up",
+			conn, pinned, fp))
+	case !ok && conn.local.KnownCertsOnly:
+		logWarn(conn, "has no trusted certificate, hanging up;",
+			"its fingerprint is", fp)
+	default:
+		return true
+	}
+	conn.Close()
+	return false
+}
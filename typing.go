@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// How long after the last keystroke we tell peers we stopped typing
+	typingIdle = 5 * time.Second
+	// How often TypingStarted is sent again while the user keeps typing, in
+	// case one got lost
+	typingRefresh = 3 * time.Second
+	// How long a peer is shown as typing without hearing from it again
+	typingExpiry = 6 * time.Second
+	typingIcon   = "✎ typing…"
+)
+
+// typingState is whom we told that the user is writing to
+type typingState struct {
+	mu sync.Mutex
+	// remote is the peer told, empty if none
+	remote string
+	sent   time.Time
+	idle   *time.Timer
+}
+
+// Typing is given the text of the input field every time it changes, and
+// tells the peer the user is writing a message to whether they still are.
+// remote is the peer of the pane written in, if any, and a message written
+// elsewhere goes to the one given to /msg. Other commands aren't messages,
+// so they don't count
+func (peer *RTCPeer) Typing(remote, text string) {
+	// The user is around, so whatever arrived has been seen
+	peer.markRead()
+	remote, typing := peer.typingTo(remote, text)
+	state := &peer.typing
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.remote != "" && (!typing || state.remote != remote) {
+		peer.setTyping(state.remote, false)
+	}
+	if !typing {
+		return
+	}
+	// Data saver doesn't refresh it, the marker just expires on their side
+	refresh := !peer.DataSaver && time.Since(state.sent) > typingRefresh
+	if state.remote == "" || refresh {
+		peer.setTyping(remote, true)
+	}
+	if state.idle == nil {
+		state.idle = time.AfterFunc(typingIdle, func() {
+			state.mu.Lock()
+			defer state.mu.Unlock()
+			if state.remote != "" {
+				peer.setTyping(state.remote, false)
+			}
+		})
+	} else {
+		state.idle.Reset(typingIdle)
+	}
+}
+
+// typingTo tells whom text is a message for, remote unless it is a
+// command, and whether it is one being written at all
+func (peer *RTCPeer) typingTo(remote, text string) (string, bool) {
+	if !strings.HasPrefix(text, "/") {
+		return remote, remote != "" && text != ""
+	}
+	args := strings.SplitN(text, " ", 3)
+	if args[0] != "/msg" || len(args) < 3 || args[2] == "" {
+		return "", false
+	}
+	return peer.contactAddress(args[1]), true
+}
+
+// setTyping tells remote whether the user is writing to it, it is called
+// with peer.typing locked
+func (peer *RTCPeer) setTyping(remote string, typing bool) {
+	peer.typing.sent = time.Now()
+	action := TypingStopped
+	peer.typing.remote = ""
+	if typing {
+		action = TypingStarted
+		peer.typing.remote = remote
+	}
+	peer.connsMu.RLock()
+	conn, ok := peer.Connections[remote]
+	peer.connsMu.RUnlock()
+	if !ok || conn.state != InCall {
+		return
+	}
+	// Peers that don't understand control messages just don't know
+	conn.sendControl(ControlMessage{Action: action})
+}
+
+// remoteTyping tells whether remote is writing us a message
+func (peer *RTCPeer) remoteTyping(remote string) bool {
+	peer.connsMu.RLock()
+	conn, ok := peer.Connections[remote]
+	peer.connsMu.RUnlock()
+	return ok && conn.remoteTyping()
+}
+
+// remoteTyping tells whether the remote of conn is writing a message
+func (conn *Connection) remoteTyping() bool {
+	return !conn.typingAt.IsZero() && time.Since(conn.typingAt) < typingExpiry
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+const (
+	// How often the screen is redrawn at most when reduced motion is enabled
+	reducedMotionInterval = time.Millisecond * 500
+	// How often new lines are shown when running on a slow terminal
+	slowTTYInterval = time.Second
+	// How often the status bar is refreshed
+	statusInterval = time.Second
+	// How many lines each pane keeps, older ones are only in the log file
+	maxScrollback = 5000
+	// How many lines a turn of the mouse wheel scrolls
+	wheelLines = 3
+)
+
+// statusChanged has the status bar refreshed right away instead of at the
+// next statusInterval
+var statusChanged = make(chan struct{}, 1)
+
+// refreshStatus is called when what the status bar shows changes
+func refreshStatus() {
+	select {
+	case statusChanged <- struct{}{}:
+	default:
+	}
+}
+
+// highContrastTheme is pure white and yellow on black, with no colored
+// backgrounds. It has to be set before any primitive is created
+var highContrastTheme = tview.Theme{
+	PrimitiveBackgroundColor:    tcell.ColorBlack,
+	ContrastBackgroundColor:     tcell.ColorBlack,
+	MoreContrastBackgroundColor: tcell.ColorBlack,
+	BorderColor:                 tcell.ColorWhite,
+	TitleColor:                  tcell.ColorWhite,
+	GraphicsColor:               tcell.ColorWhite,
+	PrimaryTextColor:            tcell.ColorWhite,
+	SecondaryTextColor:          tcell.ColorYellow,
+	TertiaryTextColor:           tcell.ColorYellow,
+	InverseTextColor:            tcell.ColorBlack,
+	ContrastSecondaryTextColor:  tcell.ColorYellow,
+}
+
+// throttledDraw returns a function that schedules a redraw of tapp. Redraws
+// requested in quick succession are merged into one every interval, instead
+// of repainting the whole screen for every single change
+func throttledDraw(tapp *tview.Application, interval time.Duration) func() {
+	pending := make(chan struct{}, 1)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			select {
+			case <-pending:
+				tapp.Draw()
+			default:
+			}
+		}
+	}()
+
+	return func() {
+		select {
+		case pending <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// isSlowTTY tells whether the terminal should be treated as slow, based on
+// the value of the -slow-tty flag. In auto mode any SSH session is
+// considered slow, since every redraw has to go through the network
+func isSlowTTY(mode string) bool {
+	switch mode {
+	case "on":
+		return true
+	case "off":
+		return false
+	}
+	return os.Getenv("SSH_CONNECTION") != "" || os.Getenv("SSH_TTY") != ""
+}
+
+// batchWriter collects everything written to it and hands it over to view
+// at most once per interval, so that a burst of log lines costs a single
+// redraw instead of one each
+type batchWriter struct {
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	tapp *tview.Application
+	view *tview.TextView
+}
+
+func newBatchWriter(
+	tapp *tview.Application,
+	view *tview.TextView,
+	interval time.Duration,
+) *batchWriter {
+	w := &batchWriter{tapp: tapp, view: view}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			w.flush()
+		}
+	}()
+	return w
+}
+
+func (w *batchWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *batchWriter) flush() {
+	w.mu.Lock()
+	if w.buf.Len() == 0 {
+		w.mu.Unlock()
+		return
+	}
+	pending := make([]byte, w.buf.Len())
+	copy(pending, w.buf.Bytes())
+	w.buf.Reset()
+	w.mu.Unlock()
+
+	w.tapp.QueueUpdateDraw(func() {
+		w.view.Write(pending)
+	})
+}
+
+// updateStatus keeps view showing the output of status, redrawing only when
+// it changes
+func updateStatus(
+	tapp *tview.Application,
+	view *tview.TextView,
+	status func() string,
+	interval time.Duration,
+) {
+	var last string
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-statusChanged:
+		}
+		line := status()
+		if line == last {
+			continue
+		}
+		last = line
+		tapp.QueueUpdateDraw(func() {
+			view.SetText(line)
+		})
+	}
+}
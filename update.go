@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// version is set when building releases, with
+// -ldflags "-X main.version=v1.2.3"
+var version = "v0.0.0-dev"
+
+// releaseKey is the base64 ed25519 public key releases are signed with, set
+// when building them with -ldflags "-X main.releaseKey=...". Builds without
+// one can't update themselves
+var releaseKey = ""
+
+const (
+	releaseURL = "https://api.github.com/repos/Yaroslav-95/wrtcion/releases/latest"
+	// How long the changelog summary in the status bar can be
+	changelogSummaryLength = 60
+)
+
+var (
+	errNoUpdate   = errors.New("already running the latest version")
+	errNoKey      = errors.New("this build has no release key to check updates with, update it the way it was installed")
+	errBadRelease = errors.New("the release isn't signed with our release key")
+)
+
+// updateNotice is shown in the status bar once a newer release is found
+var updateNotice atomic.Value
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+type release struct {
+	Tag    string         `json:"tag_name"`
+	Body   string         `json:"body"`
+	Assets []releaseAsset `json:"assets"`
+}
+
+func (r *release) asset(name string) (releaseAsset, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return releaseAsset{}, false
+}
+
+func fetchLatestRelease() (*release, error) {
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(releaseURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("release endpoint returned %s", resp.Status)
+	}
+	var rel release
+	return &rel, json.NewDecoder(resp.Body).Decode(&rel)
+}
+
+// parseVersion splits a semantic version such as v1.2.3-rc1 into its
+// numbers and pre-release suffix
+func parseVersion(v string) ([3]int, string, bool) {
+	var nums [3]int
+	v = strings.TrimPrefix(v, "v")
+	pre := ""
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		if v[i] == '-' {
+			pre = strings.SplitN(v[i+1:], "+", 2)[0]
+		}
+		v = v[:i]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return nums, "", false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nums, "", false
+		}
+		nums[i] = n
+	}
+	return nums, pre, true
+}
+
+// newerVersion tells whether latest is newer than current. Pre-releases come
+// before the release they precede
+func newerVersion(latest, current string) bool {
+	l, lpre, ok := parseVersion(latest)
+	if !ok {
+		return false
+	}
+	c, cpre, ok := parseVersion(current)
+	if !ok {
+		return true
+	}
+	for i := range l {
+		if l[i] != c[i] {
+			return l[i] > c[i]
+		}
+	}
+	switch {
+	case lpre == cpre:
+		return false
+	case lpre == "":
+		return true
+	case cpre == "":
+		return false
+	}
+	return lpre > cpre
+}
+
+// changelogSummary returns the first line of the release notes, without
+// markdown
+func changelogSummary(body string) string {
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(line, "#*- \t"))
+		if line == "" {
+			continue
+		}
+		if len(line) > changelogSummaryLength {
+			line = line[:changelogSummaryLength-3] + "..."
+		}
+		return line
+	}
+	return ""
+}
+
+// checkForUpdates looks for a newer release once, and puts a notice in the
+// status bar if there is one
+func checkForUpdates() {
+	rel, err := fetchLatestRelease()
+	if err != nil {
+		logError("couldn't check for updates:", err)
+		return
+	}
+	if !newerVersion(rel.Tag, version) {
+		return
+	}
+	notice := "update " + rel.Tag + " available"
+	if summary := changelogSummary(rel.Body); summary != "" {
+		notice += ": " + summary
+	}
+	updateNotice.Store(notice)
+	log.Println(notice)
+}
+
+// binaryAssetName is the name of the static binary for this platform in the
+// assets of a release, its signature has the same name ending in .sig
+func binaryAssetName() string {
+	return fmt.Sprintf("wrtcion-%s-%s", runtime.GOOS, runtime.GOARCH)
+}
+
+func download(url string, w io.Writer) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download returned %s", resp.Status)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// releaseSigned is what is signed for the binary name of the release tag,
+// whose SHA-256 is sum. The tag and the name are signed too, so that an
+// older release or the binary of another platform can't be passed off as it
+func releaseSigned(tag, name string, sum []byte) []byte {
+	return append([]byte("wrtcion release\x00"+tag+"\x00"+name+"\x00"), sum...)
+}
+
+// verifyRelease checks the base64 signature sig of the binary name of the
+// release tag against key
+func verifyRelease(key, sig, tag, name string, sum []byte) error {
+	pub, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return errNoKey
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(sig))
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(pub),
+		releaseSigned(tag, name, sum), raw) {
+		return errBadRelease
+	}
+	return nil
+}
+
+// selfUpdate replaces the running binary with the one of the latest release.
+// Only static binary installs can be updated this way, packaged ones are
+// left to the package manager
+func selfUpdate() error {
+	if releaseKey == "" {
+		return errNoKey
+	}
+	rel, err := fetchLatestRelease()
+	if err != nil {
+		return err
+	}
+	if !newerVersion(rel.Tag, version) {
+		return errNoUpdate
+	}
+	bin, ok := rel.asset(binaryAssetName())
+	if !ok {
+		return fmt.Errorf("%s has no binary for %s", rel.Tag, binaryAssetName())
+	}
+	sig, ok := rel.asset(binaryAssetName() + ".sig")
+	if !ok {
+		return fmt.Errorf("%s has no signature for %s", rel.Tag,
+			binaryAssetName())
+	}
+	var sigText strings.Builder
+	if err := download(sig.URL, &sigText); err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	// The new binary is written next to the old one, so that renaming it
+	// over the old one is atomic
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".wrtcion-update-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	hash := sha256.New()
+	err = download(bin.URL, io.MultiWriter(tmp, hash))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	err = verifyRelease(releaseKey, sigText.String(), rel.Tag,
+		binaryAssetName(), hash.Sum(nil))
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		return err
+	}
+	fmt.Println("updated", version, "to", rel.Tag)
+	if summary := changelogSummary(rel.Body); summary != "" {
+		fmt.Println(summary)
+	}
+	return nil
+}
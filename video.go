@@ -0,0 +1,76 @@
+package main
+
+import (
+	"time"
+
+	"github.com/Yaroslav-95/wrtcion/gst"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+type videoSender struct {
+	track    *sampleTrack
+	rtp      *webrtc.RTPSender
+	pipeline *gst.SendPipeline
+}
+
+// getVideo prepares the connection to receive video, the track itself is
+// handled by the same OnTrack callback as audio
+func (conn *Connection) getVideo() error {
+	_, err := conn.peer.AddTransceiverFromKind(
+		webrtc.RTPCodecTypeVideo,
+		webrtc.RTPTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionRecvonly,
+		},
+	)
+	return err
+}
+
+func (conn *Connection) captureVideo() error {
+	var err error
+	conn.videoSndr = new(videoSender)
+	conn.videoSndr.track, err = newSampleTrack(
+		videoCodec,
+		"video",
+		conn.String(),
+		conn.packetSize(),
+	)
+	if err != nil {
+		return err
+	}
+	conn.videoSndr.rtp, err = conn.peer.AddTrack(conn.videoSndr.track)
+	if err != nil {
+		return err
+	}
+
+	conn.videoSndr.pipeline, err = gst.CreateVideoSendPipeline(
+		conn.local.Camera,
+		conn.settings.Video,
+		func(data []byte, duration time.Duration) {
+			if conn.state != InCall && !conn.previewSent() ||
+				conn.held || conn.asleep ||
+				conn.local.DataSaver ||
+				conn.priority.Policy() == VideoPaused {
+				return
+			}
+			err := conn.videoSndr.track.WriteSample(media.Sample{
+				Data:     data,
+				Duration: duration,
+			})
+			if err != nil {
+				logError("error writing video samples:", err)
+			}
+		},
+	)
+	if err != nil {
+		conn.videoSndr = nil
+	}
+
+	return err
+}
+
+func (conn *Connection) sendVideo() {
+	logDebug("sending video")
+	conn.videoSndr.pipeline.Start()
+	go conn.handleVideoRTCP()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io"
+	"log"
+	"time"
+
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+const (
+	defaultVoicemailDir = outputPath + "voicemail/"
+	// Longest message a caller can leave, the call is hung up after it
+	voicemailLength = 2 * time.Minute
+)
+
+// takesVoicemail tells whether calls like conn go to voicemail when nobody
+// answers them, instead of being refused
+func (peer *RTCPeer) takesVoicemail(conn *Connection) bool {
+	return peer.VoicemailAfter > 0 && conn.mode != TextConnection
+}
+
+// takeVoicemail answers the incoming call nobody picked up in record-only
+// mode: the greeting is sent instead of our audio, and what the caller says
+// is recorded to VoicemailDir instead of being played
+func (conn *Connection) takeVoicemail(signal SignalSDP) {
+	conn.offer = nil
+	conn.voicemail = true
+	if callPrompts != nil {
+		callPrompts.dismiss(conn.remoteAddr)
+	}
+	conn.stopTone()
+	log.Println("nobody answered, taking a message from",
+		conn.local.displayName(conn.remoteAddr))
+	// The media of a previewed call is already set up, without a track the
+	// greeting could be sent on
+	if conn.previewing {
+		conn.startRecording()
+		conn.acceptPreview()
+		return
+	}
+	if greeting := conn.local.VoicemailGreeting; greeting != "" {
+		if err := conn.loadAudio(greeting); err != nil {
+			logError("couldn't load the voicemail greeting:", err)
+		}
+	}
+	conn.completeSignal(signal)
+}
+
+// recordMessage plays the greeting to the caller, if there is one, and
+// hangs up once the message is as long as it can be
+func (conn *Connection) recordMessage() {
+	notify(eventCall, "Voicemail", conn.local.displayName(conn.remoteAddr))
+	if conn.audioSndr != nil && conn.audioSndr.ogg != nil {
+		go conn.sendGreeting()
+	}
+	time.AfterFunc(voicemailLength, func() {
+		if conn.state != InCall {
+			return
+		}
+		log.Println("the message from", conn, "is too long, hanging up")
+		conn.Close()
+	})
+}
+
+// sendGreeting streams the greeting once, then keeps quiet while the caller
+// leaves the message
+func (conn *Connection) sendGreeting() {
+	var lastGranule uint64
+	ticker := time.NewTicker(oggPageDuration)
+	defer ticker.Stop()
+	for ; conn.state == InCall; <-ticker.C {
+		pageData, pageHeader, err := conn.audioSndr.ogg.ParseNextPage()
+		if err == io.EOF {
+			logDebug("end of the greeting to", conn)
+			return
+		} else if err != nil {
+			logError("error reading the voicemail greeting:", err)
+			return
+		}
+
+		duration := granuleDuration(pageHeader.GranulePosition, lastGranule)
+		lastGranule = pageHeader.GranulePosition
+		err = conn.audioSndr.track.WriteSample(media.Sample{
+			Data:     pageData,
+			Duration: duration,
+		})
+		if err != nil {
+			logError("error writing the voicemail greeting:", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// activeCall returns a call we are in other than conn, nil if there is none
+func (peer *RTCPeer) activeCall(conn *Connection) *Connection {
+	for _, other := range peer.Connections {
+		if other != conn && other.state == InCall &&
+			other.mode != TextConnection {
+			return other
+		}
+	}
+	return nil
+}
+
+// offerFingerprint returns the certificate fingerprint in the SDP of an
+// offer, empty if it has none
+func offerFingerprint(signal SignalSDP) string {
+	for _, line := range strings.Split(signal.SDP.SDP, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "a=fingerprint:") {
+			return normalizeFingerprint(
+				strings.TrimPrefix(line, "a=fingerprint:"))
+		}
+	}
+	return ""
+}
+
+// callerIdentity describes who is calling with signal: the name it has in
+// our contacts, and whether its certificate is the one we trust
+func (peer *RTCPeer) callerIdentity(signal SignalSDP) string {
+	who := peer.displayName(signal.Origin)
+	fp := offerFingerprint(signal)
+	pinned, ok := peer.trusted[signal.Origin]
+	switch {
+	case fp == "":
+		return who + ", unencrypted"
+	case !ok:
+		return who + ", untrusted certificate " + fp
+	case pinned != fp:
+		return who + ", certificate changed to " + fp
+	}
+	return who + ", trusted certificate"
+}
+
+// callWaiting holds an offer that came in while we are in the current call,
+// letting the user put that one on hold and answer, instead of ringing over
+// it or answering it on its own
+func (conn *Connection) callWaiting(signal SignalSDP, current *Connection) {
+	log.Printf("call waiting: %s from %s\n",
+		modeNames[conn.mode], conn.local.callerIdentity(signal))
+	log.Println("/hold", current, "before answering to switch calls")
+	conn.askToAnswer(signal)
+}
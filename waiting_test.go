@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestOfferFingerprint(t *testing.T) {
+	tests := []struct {
+		name string
+		sdp  string
+		want string
+	}{
+		{
+			name: "none",
+			sdp:  "v=0\r\no=- 1 2 IN IP4 127.0.0.1\r\ns=-\r\n",
+		},
+		{
+			name: "empty",
+		},
+		{
+			name: "session level",
+			sdp:  "v=0\r\na=fingerprint:sha-256 ab:cd:ef\r\ns=-\r\n",
+			want: "sha-256 AB:CD:EF",
+		},
+		{
+			name: "media level",
+			sdp: "v=0\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+				"a=fingerprint:sha-256 01:02\r\n",
+			want: "sha-256 01:02",
+		},
+		{
+			name: "first of several",
+			sdp:  "a=fingerprint:sha-256 01:02\na=fingerprint:sha-256 03:04\n",
+			want: "sha-256 01:02",
+		},
+		{
+			name: "only the prefix",
+			sdp:  "a=fingerprint:\r\n",
+			want: "sha-256 ",
+		},
+		{
+			name: "inside another attribute",
+			sdp:  "a=x-note:a=fingerprint:sha-256 01:02\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signal := SignalSDP{SDP: webrtc.SessionDescription{
+				Type: webrtc.SDPTypeOffer,
+				SDP:  tt.sdp,
+			}}
+			if got := offerFingerprint(signal); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const watchInterval = time.Second * 2
+
+// folderWatch polls a directory and sends every new file that shows up in it
+// to a peer
+type folderWatch struct {
+	dir    string
+	remote string
+	// Last known size and modification time of each file, files are only
+	// sent once they stop changing
+	seen map[string]os.FileInfo
+	stop chan struct{}
+	// mu guards sent and offered, which the answers of the peer update
+	mu   sync.Mutex
+	sent map[string]time.Time
+	// Files waiting for an answer, or rejected, on a connection. They are
+	// offered again on the next one, or once they change
+	offered map[string]watchOffer
+}
+
+// watchOffer is the version of a file offered on a connection
+type watchOffer struct {
+	modTime time.Time
+	conn    *Connection
+}
+
+// Watch sends every file dropped into dir to remote whenever we are
+// connected to it. The directory of a contact is kept in the address book,
+// and watched again on the next start
+func (peer *RTCPeer) Watch(remote, dir string) {
+	if !peer.requireCap(remote, CapFiles) {
+		return
+	}
+	if !peer.startWatch(remote, dir) {
+		return
+	}
+	log.Println("files dropped into", dir, "will be sent to", remote)
+	peer.saveWatch(remote, dir)
+}
+
+// watchContacts watches the directories of the contacts that have one
+func (peer *RTCPeer) watchContacts() {
+	for _, c := range peer.contacts {
+		if c.Watch != "" && peer.startWatch(c.Address, c.Watch) {
+			log.Println("files dropped into", c.Watch, "will be sent to",
+				peer.displayName(c.Address))
+		}
+	}
+}
+
+func (peer *RTCPeer) startWatch(remote, dir string) bool {
+	if w, ok := peer.watches[remote]; ok {
+		close(w.stop)
+		delete(peer.watches, remote)
+	}
+	w := &folderWatch{
+		dir:     dir,
+		remote:  remote,
+		seen:    make(map[string]os.FileInfo),
+		sent:    make(map[string]time.Time),
+		offered: make(map[string]watchOffer),
+		stop:    make(chan struct{}),
+	}
+	// Only files added from now on are sent
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logError("can't watch", dir, ":", err)
+		return false
+	}
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil {
+			w.sent[e.Name()] = info.ModTime()
+		}
+	}
+	peer.watches[remote] = w
+	go peer.runWatch(w)
+	return true
+}
+
+// saveWatch keeps dir as the watched directory of the contact at address,
+// if it is one. An empty dir forgets it
+func (peer *RTCPeer) saveWatch(address, dir string) {
+	for i, c := range peer.contacts {
+		if c.Address != address || c.Watch == dir {
+			continue
+		}
+		peer.contacts[i].Watch = dir
+		if err := saveContacts(peer.contacts); err != nil {
+			logError("couldn't save contacts:", err)
+		}
+		return
+	}
+}
+
+// Unwatch stops sending files to remote
+func (peer *RTCPeer) Unwatch(remote string) {
+	w, ok := peer.watches[remote]
+	if !ok {
+		log.Println("not watching any folder for", remote)
+		return
+	}
+	close(w.stop)
+	delete(peer.watches, remote)
+	peer.saveWatch(remote, "")
+	log.Println("stopped watching", w.dir)
+}
+
+func (peer *RTCPeer) runWatch(w *folderWatch) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			peer.scanWatch(w)
+		}
+	}
+}
+
+func (peer *RTCPeer) scanWatch(w *folderWatch) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		logError("error reading", w.dir, ":", err)
+		return
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		name := e.Name()
+		w.mu.Lock()
+		sent, ok := w.sent[name]
+		w.mu.Unlock()
+		if ok && !info.ModTime().After(sent) {
+			continue
+		}
+		last, ok := w.seen[name]
+		w.seen[name] = info
+		if !ok || last.Size() != info.Size() ||
+			!last.ModTime().Equal(info.ModTime()) {
+			// Still being written, check again later
+			continue
+		}
+		if peer.MaxFileSize > 0 && info.Size() > peer.MaxFileSize {
+			logWarn("not sending", name, "to", w.remote, ": too big")
+			w.mu.Lock()
+			w.sent[name] = info.ModTime()
+			w.mu.Unlock()
+			continue
+		}
+
+		conn, ok := peer.Connections[w.remote]
+		if !ok || conn.state != InCall {
+			continue
+		}
+		offer := watchOffer{modTime: info.ModTime(), conn: conn}
+		w.mu.Lock()
+		pending := w.offered[name].same(offer)
+		w.mu.Unlock()
+		if pending {
+			continue
+		}
+		err = conn.offerFile(filepath.Join(w.dir, name), func() {
+			w.accepted(name, offer)
+		})
+		if err != nil {
+			logError("couldn't send", name, "to", w.remote, ":", err)
+			continue
+		}
+		w.mu.Lock()
+		w.offered[name] = offer
+		w.mu.Unlock()
+		delete(w.seen, name)
+	}
+}
+
+func (o watchOffer) same(other watchOffer) bool {
+	return o.conn == other.conn && o.modTime.Equal(other.modTime)
+}
+
+// accepted marks the file offered as sent, once the peer takes it
+func (w *folderWatch) accepted(name string, offer watchOffer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.offered[name].same(offer) {
+		delete(w.offered, name)
+	}
+	if sent, ok := w.sent[name]; !ok || offer.modTime.After(sent) {
+		w.sent[name] = offer.modTime
+	}
+}
@@ -7,22 +7,32 @@ import (
 	"log"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 	"github.com/Yaroslav-95/wrtcion/gst"
 )
 
-func parseCommand(cmd string, rtcpeer *RTCPeer, tapp *tview.Application) {
+func parseCommand(cmd string, rtcpeer *RTCPeer, quit func()) {
+	cmd, ok := rtcpeer.expandShorthands(cmd)
+	if !ok {
+		return
+	}
 	args := strings.SplitN(cmd, " ", 3)
-	if args[0] == "/help" {
-		log.Println("enter a command or send a message to all connected peers:")
-		log.Println("commands available:")
-		log.Println("/chat <address>")
-		log.Println("/call <address>")
-		log.Println("/end <address>")
-		log.Println("/msg <address> <message>")
+	// Contacts can be given by name instead of address
+	if len(args) > 1 && strings.HasPrefix(args[0], "/") && args[0] != "/add" {
+		args[1] = rtcpeer.contactAddress(args[1])
+	}
+	if len(args) > 2 && (args[0] == "/record" || args[0] == "/transfer") {
+		args[2] = rtcpeer.contactAddress(args[2])
+	}
+	if query, ok := helpQuery(cmd); ok {
+		for _, line := range strings.Split(strings.TrimSuffix(helpText(query), "\n"), "\n") {
+			log.Println(line)
+		}
 	} else if args[0] == "/chat" {
 		if len(args) < 2 {
 			log.Println("remote address missing")
@@ -34,7 +44,33 @@ func parseCommand(cmd string, rtcpeer *RTCPeer, tapp *tview.Application) {
 			log.Println("remote address missing")
 			return
 		}
-		rtcpeer.Ring(args[1], VoiceConnectionSimplex)
+		settings, err := parseCallSettings(args, rtcpeer.DefaultSettings())
+		if err != nil {
+			log.Println("bad call settings:", err)
+			return
+		}
+		rtcpeer.RingWith(args[1], VoiceConnectionSimplex, settings)
+	} else if args[0] == "/video" {
+		if len(args) < 2 {
+			log.Println("remote address missing")
+			return
+		}
+		settings, err := parseCallSettings(args, rtcpeer.DefaultSettings())
+		if err != nil {
+			log.Println("bad call settings:", err)
+			return
+		}
+		rtcpeer.RingWith(args[1], VideoConnectionSimplex, settings)
+	} else if args[0] == "/accept" || args[0] == "/reject" {
+		if len(args) < 2 {
+			log.Println("specify whom")
+			return
+		}
+		if args[0] == "/accept" {
+			rtcpeer.Accept(args[1])
+		} else {
+			rtcpeer.Reject(args[1])
+		}
 	} else if args[0] == "/end" {
 		if len(args) < 2 {
 			log.Println("specify whom")
@@ -46,42 +82,506 @@ func parseCommand(cmd string, rtcpeer *RTCPeer, tapp *tview.Application) {
 			log.Println("specify whom")
 			return
 		}
+		if len(args) < 3 {
+			log.Println("usage: /msg <address> <message>")
+			return
+		}
 		conn, ok := rtcpeer.Connections[args[1]]
 		if !ok {
 			log.Println("no such destination")
+			return
+		}
+		conn.SendMsg(ChatText, args[2])
+	} else if args[0] == "/me" {
+		action := strings.TrimSpace(strings.TrimPrefix(cmd, "/me"))
+		if action == "" {
+			log.Println("usage: /me <action>")
+			return
+		}
+		rtcpeer.SendMsgToAll(ChatAction, action)
+	} else if args[0] == "/mute" || args[0] == "/unmute" {
+		if len(args) < 2 {
+			log.Println("specify whom")
+			return
+		}
+		rtcpeer.SetMuted(args[1], args[0] == "/mute")
+	} else if args[0] == "/volume" {
+		if len(args) < 3 {
+			log.Println("usage: /volume <address> <0-150>")
+			return
+		}
+		percent, err := strconv.Atoi(args[2])
+		if err != nil {
+			log.Println("volume must be a number")
+			return
+		}
+		rtcpeer.SetVolume(args[1], percent)
+	} else if args[0] == "/debuglog" {
+		if chatPanes == nil {
+			log.Println("the debug pane is only in the full screen interface")
+			return
+		}
+		chatPanes.toggleDebug()
+	} else if args[0] == "/loglevel" {
+		setLogLevel(strings.TrimSpace(strings.TrimPrefix(cmd, "/loglevel")))
+	} else if args[0] == "/devices" {
+		log.Println("audio capture devices:")
+		for _, name := range gst.AudioSources() {
+			log.Println(" ", name)
+		}
+	} else if args[0] == "/mic" {
+		rtcpeer.MicDevice = strings.TrimSpace(strings.TrimPrefix(cmd, "/mic"))
+		if rtcpeer.MicDevice == "" {
+			log.Println("using the default microphone")
+		} else {
+			log.Println("using microphone", rtcpeer.MicDevice)
+		}
+	} else if args[0] == "/cameras" {
+		log.Println("video capture devices:")
+		for _, name := range gst.VideoSources() {
+			log.Println(" ", name)
+		}
+	} else if args[0] == "/camera" {
+		rtcpeer.Camera = strings.TrimSpace(strings.TrimPrefix(cmd, "/camera"))
+		if rtcpeer.Camera == "" {
+			log.Println("using the default camera")
+		} else {
+			log.Println("using camera", rtcpeer.Camera)
+		}
+	} else if args[0] == "/hold" || args[0] == "/resume" {
+		if len(args) < 2 {
+			log.Println("specify whom")
+			return
+		}
+		rtcpeer.SetHeld(args[1], args[0] == "/hold")
+	} else if args[0] == "/send" {
+		if len(args) < 3 {
+			log.Println("usage: /send <address> <file>")
+			return
+		}
+		rtcpeer.SendFile(args[1], args[2])
+	} else if args[0] == "/acceptfile" || args[0] == "/rejectfile" {
+		if len(args) < 2 {
+			log.Println("specify whom")
+			return
+		}
+		id := ""
+		if len(args) > 2 {
+			id = args[2]
+		}
+		rtcpeer.AnswerFile(args[1], id, args[0] == "/acceptfile")
+	} else if args[0] == "/files" {
+		if len(args) < 2 {
+			log.Println("specify whom")
+			return
+		}
+		rtcpeer.Files(args[1])
+	} else if args[0] == "/watch" {
+		if len(args) < 3 {
+			log.Println("usage: /watch <address> <directory>")
+			return
+		}
+		rtcpeer.Watch(args[1], args[2])
+	} else if args[0] == "/unwatch" {
+		if len(args) < 2 {
+			log.Println("specify whom")
+			return
+		}
+		rtcpeer.Unwatch(args[1])
+	} else if args[0] == "/diag" {
+		if len(args) < 2 {
+			log.Println("specify whom")
+			return
+		}
+		rtcpeer.Diag(args[1])
+	} else if args[0] == "/caps" {
+		if len(args) < 2 {
+			log.Println("specify whom")
+			return
+		}
+		rtcpeer.Caps(args[1])
+	} else if args[0] == "/stats" {
+		if len(args) < 2 {
+			log.Println("specify whom")
+			return
+		}
+		rtcpeer.ShowStats(args[1])
+	} else if args[0] == "/identity" {
+		rtcpeer.Identity()
+	} else if args[0] == "/trust" {
+		if len(args) < 2 {
+			log.Println("specify whom")
+			return
+		}
+		fp := ""
+		if len(args) > 2 {
+			fp = args[2]
+		}
+		rtcpeer.Trust(args[1], fp)
+	} else if args[0] == "/relay" {
+		if len(args) < 3 || (args[2] != "on" && args[2] != "off") {
+			log.Println("usage: /relay <address> <on|off>")
+			return
+		}
+		rtcpeer.SetRelay(args[1], args[2] == "on")
+	} else if args[0] == "/record" {
+		if len(args) < 3 || (args[1] != "start" && args[1] != "stop") {
+			log.Println("usage: /record <start|stop> <address>")
+			return
+		}
+		rtcpeer.SetRecording(args[2], args[1] == "start")
+	} else if args[0] == "/focus" {
+		if len(args) < 2 {
+			log.Println("specify whom")
+			return
+		}
+		rtcpeer.Focus(args[1])
+	} else if args[0] == "/sink" {
+		if len(args) < 2 {
+			log.Println("specify whom")
+			return
+		}
+		sink := ""
+		if len(args) > 2 {
+			sink = args[2]
+		}
+		rtcpeer.SetSink(args[1], sink)
+	} else if args[0] == "/dtmf" {
+		if len(args) < 3 {
+			log.Println("usage: /dtmf <address> <digits>")
+			return
+		}
+		rtcpeer.SendDTMF(args[1], args[2])
+	} else if args[0] == "/channel" {
+		var fields []string
+		if len(args) == 3 {
+			fields = strings.Fields(args[2])
+		}
+		if len(fields) == 0 {
+			log.Println("usage: /channel <address> <label> [unordered] [retransmits=<n>]")
+			return
+		}
+		opts, err := parseChannelOptions(fields[1:])
+		if err != nil {
+			log.Println("bad channel options:", err)
+			return
+		}
+		rtcpeer.OpenChannel(args[1], fields[0], opts)
+	} else if args[0] == "/chsend" {
+		var parts []string
+		if len(args) == 3 {
+			parts = strings.SplitN(args[2], " ", 2)
+		}
+		if len(parts) < 2 {
+			log.Println("usage: /chsend <address> <label> <text>")
+			return
+		}
+		rtcpeer.SendOnChannel(args[1], parts[0], parts[1])
+	} else if args[0] == "/chclose" {
+		if len(args) < 3 {
+			log.Println("usage: /chclose <address> <label>")
+			return
+		}
+		rtcpeer.CloseChannel(args[1], args[2])
+	} else if args[0] == "/continue" {
+		rtcpeer.ResolveConsent(RouteDirect, false)
+	} else if args[0] == "/userelay" {
+		rtcpeer.ResolveConsent(RouteRelay, false)
+	} else if args[0] == "/cancel" {
+		rtcpeer.ResolveConsent(RouteAsk, true)
+	} else if args[0] == "/rate" {
+		if len(args) < 2 {
+			log.Println("rating missing")
+			return
+		}
+		rating, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Println("rating must be a number")
+			return
+		}
+		note := ""
+		if len(args) > 2 {
+			note = args[2]
+		}
+		rtcpeer.RateLastCall(rating, note)
+	} else if args[0] == "/skip" {
+		rtcpeer.SkipSurvey()
+	} else if args[0] == "/version" {
+		rtcpeer.Version()
+	} else if args[0] == "/history" {
+		filter := ""
+		if len(args) > 1 {
+			filter = args[1]
 		}
-		conn.SendMsg(cmd)
+		rtcpeer.History(filter)
+	} else if args[0] == "/dnd" {
+		if len(args) < 2 || (args[1] != "on" && args[1] != "off") {
+			log.Println("usage: /dnd <on|off>")
+			return
+		}
+		rtcpeer.SetDND(args[1] == "on")
+	} else if args[0] == "/datasaver" {
+		if len(args) < 2 || (args[1] != "on" && args[1] != "off") {
+			log.Println("usage: /datasaver <on|off>")
+			return
+		}
+		rtcpeer.SetDataSaver(args[1] == "on")
+	} else if args[0] == "/paste" {
+		if len(args) < 2 {
+			log.Println("specify whom")
+			return
+		}
+		rtcpeer.Paste(args[1])
+	} else if args[0] == "/copy" {
+		rtcpeer.Copy()
+	} else if args[0] == "/conference" {
+		if len(args) < 2 {
+			rtcpeer.ShowConference()
+		} else if args[1] == "start" {
+			rtcpeer.StartConference()
+		} else if args[1] == "stop" {
+			rtcpeer.StopConference()
+		} else {
+			log.Println("usage: /conference [start|stop]")
+		}
+	} else if args[0] == "/add" {
+		if len(args) < 3 {
+			log.Println("usage: /add <name> <address>")
+			return
+		}
+		rtcpeer.AddContact(args[1], args[2])
+	} else if args[0] == "/autoanswer" {
+		if len(args) < 3 || (args[2] != "on" && args[2] != "off") {
+			log.Println("usage: /autoanswer <contact> <on|off>")
+			return
+		}
+		rtcpeer.SetAutoAnswer(args[1], args[2] == "on")
+	} else if args[0] == "/contacts" {
+		rtcpeer.Contacts()
+	} else if args[0] == "/redial" {
+		rtcpeer.Redial()
+	} else if args[0] == "/callback" {
+		rtcpeer.Callback()
+	} else if args[0] == "/transfer" {
+		if len(args) < 3 {
+			log.Println("usage: /transfer <address> <new address>")
+			return
+		}
+		rtcpeer.Transfer(args[1], args[2])
+	} else if args[0] == "/sfu" {
+		if len(args) < 2 || (args[1] != "on" && args[1] != "off") {
+			log.Println("usage: /sfu <on|off>")
+			return
+		}
+		rtcpeer.SetSFU(args[1] == "on")
+	} else if args[0] == "/invite" {
+		if len(args) < 2 {
+			log.Println("specify whom")
+			return
+		}
+		rtcpeer.Invite(args[1])
+	} else if args[0] == "/forget" {
+		if len(args) < 2 {
+			log.Println("specify whom")
+			return
+		}
+		rtcpeer.Forget(args[1])
+	} else if args[0] == "/search" {
+		term := strings.TrimSpace(strings.TrimPrefix(cmd, "/search"))
+		here := strings.HasPrefix(term, "-here ")
+		if here {
+			term = strings.TrimSpace(strings.TrimPrefix(term, "-here"))
+		}
+		if term == "" {
+			log.Println("usage: /search [-here] <text>")
+			return
+		}
+		rtcpeer.SearchChats(term, here)
 	} else if args[0] == "/exit" {
 		rtcpeer.CloseAll()
-		tapp.Stop()
+		quit()
+	} else if strings.HasPrefix(args[0], "/") {
+		log.Println("unknown command", args[0], "- see /help")
 	} else {
-		rtcpeer.SendMsgToAll(cmd)
+		rtcpeer.SendMsgToAll(ChatText, cmd)
 	}
 }
 
+// parseCallSettings overrides settings with the ones given after the address
+// of a /call or /video command, as a space separated list of key=value pairs
+func parseCallSettings(args []string, settings CallSettings) (CallSettings, error) {
+	if len(args) < 3 {
+		return settings, nil
+	}
+	for _, setting := range strings.Fields(args[2]) {
+		kv := strings.SplitN(setting, "=", 2)
+		if len(kv) != 2 {
+			return settings, fmt.Errorf("expected key=value, got %s", setting)
+		}
+		var err error
+		switch kv[0] {
+		case "bitrate":
+			settings.Audio.Bitrate, err = strconv.Atoi(kv[1])
+		case "stereo":
+			settings.Audio.Stereo, err = strconv.ParseBool(kv[1])
+		case "frame":
+			settings.Audio.FrameSize, err = strconv.Atoi(kv[1])
+		case "size":
+			_, err = fmt.Sscanf(kv[1], "%dx%d",
+				&settings.Video.Width, &settings.Video.Height)
+		case "fps":
+			settings.Video.Framerate, err = strconv.Atoi(kv[1])
+		case "vbitrate":
+			settings.Video.Bitrate, err = strconv.Atoi(kv[1])
+		case "music":
+			settings.Audio.Music, err = strconv.ParseBool(kv[1])
+		default:
+			return settings, fmt.Errorf("unknown setting %s", kv[0])
+		}
+		if err != nil {
+			return settings, fmt.Errorf("bad value for %s: %v", kv[0], err)
+		}
+	}
+	if settings.Audio.Music {
+		settings.Audio = musicAudio(settings.Audio)
+	}
+	return settings, nil
+}
+
 func onInput(
 	in *tview.InputField,
-	rtcpeer *RTCPeer,
+	exec func(cmd string, quit func()),
 	tapp *tview.Application,
 	key tcell.Key,
 ) {
 	if key == tcell.KeyEnter {
 		txt := in.GetText()
 		log.Println("you:", txt)
-		parseCommand(txt, rtcpeer, tapp)
+		exec(txt, tapp.Stop)
 		in.SetText("")
 	} else if key == tcell.KeyEscape {
 		in.SetText("")
 	}
 }
 
-var listen = flag.String("l", "localhost:8001", "listen address")
+var (
+	listen = flag.String("l", "localhost:8001", "listen address")
+	survey = flag.Bool("survey", false, "ask for a quality rating after each call")
+	mic    = flag.Bool("mic", false, "send audio from the microphone instead of the sample file")
+	micdev = flag.String("mic-device", "", "name of the microphone to use, as listed by /devices")
+	camera = flag.String("camera", "", "name of the camera to use, as listed by /cameras")
+	camres = flag.String("camera-size", "", "resolution to capture video at, e.g. 1280x720")
+	camfmt = flag.String("camera-format", "", "raw pixel format to capture video in, e.g. YUY2")
+	fps    = flag.Int("fps", 0, "video framerate, 0 for the camera's default")
+	vrate  = flag.Int("video-bitrate", 0, "target video bitrate in bits per second, 0 for automatic")
+	fec    = flag.Bool("fec", true, "enable Opus in-band forward error correction")
+	dtx    = flag.Bool("dtx", false, "enable Opus discontinuous transmission")
+	loss   = flag.Int("loss", 0, "expected packet loss percentage hint for the Opus encoder")
+	rate   = flag.Int("bitrate", 0, "target Opus bitrate in bits per second, 0 for automatic")
+	stereo = flag.Bool("stereo", false, "send stereo instead of mono audio")
+	frame  = flag.Int("frame", 20, "Opus frame size in milliseconds")
+	mtu    = flag.Uint("mtu", 0, "size of the packet receive buffers, 0 for the default")
+	hold   = flag.String("hold-music", "", "ogg file played to calls put on hold")
+	ring   = flag.String("ringtone", "", "ogg file played while a call comes in")
+	ringbk = flag.String("ringback", "", "ogg file played while calling")
+	files  = flag.Bool("accept-files", true, "accept files sent by peers")
+	maxf   = flag.Int64("max-file-size", defaultMaxFileSize, "largest file in bytes to send or accept, 0 for no limit")
+	a11y   = flag.Bool("accessible", false, "plain line based interface for screen readers")
+	hicon  = flag.Bool("high-contrast", false, "use a high contrast color theme")
+	calm   = flag.Bool("reduced-motion", false, "redraw the screen less often")
+	slow   = flag.String("slow-tty", "auto", "batch screen updates for slow terminals: on, off or auto (on over SSH)")
+	ctlsrv = flag.String("control", "", "run headless, driven through a control api served at this address")
+	remote = flag.String("remote", "", "drive the headless peer whose control api is at this address")
+	ctltok = flag.String("control-token", "", "secret shared by the control api and its clients")
+	grpcad = flag.String("grpc", "", "also serve the control api as a gRPC service at this address, with -control")
+	record = flag.Bool("record", false, "start recording every call, /record toggles it during a call")
+	recdir = flag.String("recordings-dir", defaultRecordingsDir, "directory where calls are recorded to")
+	srtp   = flag.String("srtp-profiles", "", "comma separated SRTP protection profiles to allow, e.g. SRTP_AEAD_AES_128_GCM")
+	known  = flag.Bool("known-certs", false, "only accept peers whose certificate was pinned with /trust")
+	turn   = flag.String("turn", "", "TURN server URL, e.g. turn:example.com:3478")
+	tuser  = flag.String("turn-user", "", "TURN server username")
+	tpass  = flag.String("turn-pass", "", "TURN server password")
+	relay  = flag.Bool("relay-only", false, "only send media through the TURN server, hiding our local address")
+	direct = flag.Bool("confirm-direct", true, "ask before revealing our IP address to untrusted peers")
+	mix    = flag.Bool("mix", false, "play all calls through a single audio mixer")
+	bcast  = flag.Bool("broadcast-mic", false, "send the microphone to every call instead of the focused one")
+	sinks  = flag.String("sinks", "", "audio outputs for calls with some peers, e.g. host:8002=obs_sink,host:8003=dsp")
+	audio  = flag.String("audio", "auto", "audio backend: auto, pipewire or jack")
+	pwsrc  = flag.String("pw-source", "", "PipeWire node to capture from, for the pipewire backend")
+	pwsink = flag.String("pw-sink", "", "PipeWire node to play to, for the pipewire backend")
+	echo   = flag.Bool("echo-cancel", false, "capture and play through PipeWire's echo cancel module")
+	music  = flag.Bool("music", false, "low latency, high fidelity audio for playing music, also per call with music=true")
+	vers   = flag.Bool("version", false, "print how this binary was built and exit")
+	nick   = flag.String("name", os.Getenv("USER"), "name shown to peers instead of our address")
+	chlog  = flag.Bool("chat-history", true, "keep the chat with every peer and show it again when they reconnect")
+	saver  = flag.Bool("data-saver", false, "use as little data as possible, for metered connections")
+	ringto = flag.Duration("ring-timeout", 45*time.Second, "how long to call before giving up, 0 for ever")
+	autoan = flag.Bool("auto-answer", false, "answer incoming calls without asking")
+	vmail  = flag.Duration("voicemail", 0, "let callers leave a message when a call isn't answered after this long, 0 to refuse it")
+	vmgrt  = flag.String("voicemail-greeting", "", "ogg file played to callers before they leave a message")
+	vmdir  = flag.String("voicemail-dir", defaultVoicemailDir, "directory where messages left on voicemail are recorded to")
+	prevw  = flag.Bool("video-preview", false, "show callees our video while our video calls ring, and see the video of such callers before answering")
+	autofr = flag.String("auto-answer-from", "", "comma separated addresses or contact names whose calls are answered without asking, for intercoms")
+	rcpts  = flag.Bool("read-receipts", true, "tell peers when their messages have been seen")
+	presnc = flag.Bool("presence", false, "check which contacts are online every 30 seconds, revealing our address to them")
+	soak   = flag.String("soak", "", "keep calling this address for a soak test, or answer")
+	update = flag.Bool("check-updates", false, "look for a newer release at startup and tell in the status bar")
+	sfusrv = flag.Bool("sfu", false, "forward the media of every call to the others, to host group calls")
+	jackcn = flag.Bool("jack-connect", false, "connect our JACK ports to the system ones instead of leaving them unrouted")
+	logfil = flag.String("log-file", "", "file to write the log to, /tmp/wrtcion-<listen address>.log by default")
+	sockpt = flag.String("socket", defaultSocketPath(), "unix socket taking commands from scripts, empty for none")
+)
 
 func wrtcionMain() {
 	flag.Parse()
+	if err := applyEnv(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	var run *script
+	switch flag.Arg(0) {
+	case "call", "msg", "listen":
+		var status int
+		if run, status = parseScript(flag.Args()); status != 0 {
+			os.Exit(status)
+		}
+	case "contacts":
+		os.Exit(contactsMain(flag.Args()[1:]))
+	case "self-update":
+		err := selfUpdate()
+		if err == errNoUpdate {
+			fmt.Println(err)
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, "couldn't update:", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "version":
+		fmt.Println(localBuildInfo())
+		os.Exit(0)
+	case "demo":
+		*listen = demoListenAddr(flag.Arg(1))
+	}
+	if *vers {
+		fmt.Println(localBuildInfo())
+		os.Exit(0)
+	}
+	if *ctlsrv != "" && *ctltok == "" {
+		// Anyone reaching the control api could run any command otherwise
+		fmt.Fprintln(os.Stderr, "-control needs a -control-token")
+		os.Exit(2)
+	}
+	if *grpcad != "" && *ctlsrv == "" {
+		fmt.Fprintln(os.Stderr, "-grpc is only served with -control")
+		os.Exit(2)
+	}
 
+	logPath := *logfil
+	if logPath == "" {
+		logPath = fmt.Sprintf("/tmp/wrtcion-%s.log", *listen)
+	}
 	flog, err := os.OpenFile(
-		fmt.Sprintf("/tmp/wrtcion-%s.log", *listen),
+		logPath,
 		os.O_CREATE|os.O_TRUNC|os.O_WRONLY,
 		0755,
 	)
@@ -90,30 +590,378 @@ func wrtcionMain() {
 	}
 	defer flog.Close()
 
-	tapp := tview.NewApplication()
-	msglog := tview.NewTextView()
-	msglog.SetChangedFunc(func() {
-		tapp.Draw()
-	})
-	wlog := io.MultiWriter(flog, msglog)
-	log.SetOutput(wlog)
+	if *remote != "" {
+		// There is no local peer, everything happens on the remote one
+		cli := &controlClient{addr: *remote, token: *ctltok}
+		tuiMain(flog, cli.streamEvents, cli.command, nil, nil, nil, nil, nil)
+		os.Exit(0)
+	}
+
 	rtcpeer := NewRTCPeer(*listen)
+	rtcpeer.AskSurvey = *survey
+	rtcpeer.Capture = *mic
+	rtcpeer.MicDevice = *micdev
+	rtcpeer.Camera = *camera
+	rtcpeer.Video.Format = *camfmt
+	rtcpeer.Video.Framerate = *fps
+	rtcpeer.Video.Bitrate = *vrate
+	if *camres != "" {
+		_, err := fmt.Sscanf(
+			*camres,
+			"%dx%d",
+			&rtcpeer.Video.Width,
+			&rtcpeer.Video.Height,
+		)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "bad camera size:", *camres)
+			os.Exit(1)
+		}
+	}
+	rtcpeer.MTU = *mtu
+	rtcpeer.HoldMusic = *hold
+	rtcpeer.Ringtone = *ring
+	rtcpeer.Ringback = *ringbk
+	rtcpeer.AcceptFiles = *files
+	rtcpeer.MaxFileSize = *maxf
+	rtcpeer.Record = *record
+	rtcpeer.RecordingsDir = *recdir
+	rtcpeer.KnownCertsOnly = *known
+	rtcpeer.TURNServer = *turn
+	rtcpeer.TURNUser = *tuser
+	rtcpeer.TURNPass = *tpass
+	rtcpeer.RelayOnly = *relay
+	rtcpeer.ConfirmDirect = *direct
+	rtcpeer.Mix = *mix
+	rtcpeer.BroadcastMic = *bcast
+	rtcpeer.Name = *nick
+	rtcpeer.AutoAnswer = *autoan
+	rtcpeer.parseAutoAnswerFrom(*autofr)
+	rtcpeer.RingTimeout = *ringto
+	rtcpeer.VoicemailAfter = *vmail
+	rtcpeer.VoicemailGreeting = *vmgrt
+	rtcpeer.VoicemailDir = *vmdir
+	rtcpeer.VideoPreview = *prevw
+	rtcpeer.DataSaver = *saver
+	rtcpeer.ChatHistory = *chlog
+	rtcpeer.ReadReceipts = *rcpts
+	rtcpeer.Presence = *presnc
+	if *sfusrv {
+		rtcpeer.sfu = &sfu{}
+	}
+	if *sinks != "" {
+		for _, route := range strings.Split(*sinks, ",") {
+			kv := strings.SplitN(route, "=", 2)
+			if len(kv) != 2 {
+				fmt.Fprintln(os.Stderr, "bad sink route:", route)
+				os.Exit(1)
+			}
+			rtcpeer.Sinks[kv[0]] = kv[1]
+		}
+	}
+	if *relay && *turn == "" {
+		fmt.Fprintln(os.Stderr, "-relay-only needs a TURN server, set -turn")
+		os.Exit(1)
+	}
+	if *srtp != "" {
+		rtcpeer.SRTPProfiles, err = parseSRTPProfiles(*srtp)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	backend, err := gst.ParseAudioBackend(*audio)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *echo && backend != gst.BackendPipeWire {
+		fmt.Fprintln(os.Stderr, "-echo-cancel needs -audio pipewire")
+		os.Exit(1)
+	}
+	gst.SetAudioOptions(gst.AudioOptions{
+		Backend:     backend,
+		Source:      *pwsrc,
+		Sink:        *pwsink,
+		EchoCancel:  *echo,
+		JACKConnect: *jackcn,
+	})
+	if backend == gst.BackendJACK {
+		if err := gst.StartJACKMonitor(); err != nil {
+			fmt.Fprintln(os.Stderr, "xruns won't be reported:", err)
+		}
+	}
+	rtcpeer.Opus = gst.OpusOptions{
+		InbandFEC:  *fec,
+		DTX:        *dtx,
+		PacketLoss: *loss,
+		Bitrate:    *rate,
+		Stereo:     *stereo,
+		FrameSize:  *frame,
+	}
+	if *music {
+		rtcpeer.Opus = musicAudio(rtcpeer.Opus)
+	}
+	defer rtcpeer.CloseAll()
+	if *update {
+		go checkForUpdates()
+	}
+
+	if flag.Arg(0) == "demo" {
+		rtcpeer.Echo = true
+		rtcpeer.AutoAnswer = true
+		rtcpeer.Ringtone = ""
+		demoMain(rtcpeer, flog)
+	} else if run != nil {
+		os.Exit(scriptMain(rtcpeer, flog, run))
+	} else if *soak != "" {
+		rtcpeer.Soak = true
+		// Nobody is there to answer
+		rtcpeer.AutoAnswer = true
+		soakMain(rtcpeer, flog, *soak)
+	} else if *ctlsrv != "" {
+		headlessMain(rtcpeer, flog, *ctlsrv, *grpcad, *ctltok)
+	} else if *a11y {
+		plainMain(rtcpeer, flog)
+	} else {
+		tuiMain(flog, rtcpeer.Listen, func(cmd string, quit func()) {
+			parseCommand(cmd, rtcpeer, quit)
+		}, rtcpeer.Typing, rtcpeer.remoteTyping, rtcpeer.statusLine,
+			rtcpeer.sidebarEntries, rtcpeer.completionPeers)
+	}
+	os.Exit(0)
+}
+
+// tuiMain runs the full screen interface. start is run in the background
+// once the log is ready, and exec is given every line the user enters.
+// typing, if there is one, is given the input every time it changes, along
+// with the peer whose pane it is written in, and the pane of each peer shows
+// whether typingPeer says it is writing a message. The status bar shows the
+// output of status, and the sidebar the connections listed by connections,
+// if there are those. Tab completes commands, and the peers listed by peers
+// if there is that
+func tuiMain(
+	flog io.Writer,
+	start func(),
+	exec func(cmd string, quit func()),
+	typing func(remote, text string),
+	typingPeer func(remote string) bool,
+	status func() string,
+	connections func() []sidebarEntry,
+	peers func() []string,
+) {
+	cfg := loadConfig()
+	th := pickTheme(cfg.Theme)
+	tview.Styles = th.styles
+	tapp := tview.NewApplication().EnableMouse(true)
 	msginput := tview.NewInputField().SetLabel("Message: ")
+	// Clicks choose what to show, typing always goes to the input
+	keepFocus := func() {
+		tapp.SetFocus(msginput)
+	}
+	msglog := tview.NewTextView().SetDynamicColors(true)
+	// Lines are stamped here instead of by log, as the config file says
+	timeFmt, dayFmt := stampFormats(cfg)
+	flog = newStampedWriter(flog, logFileFormat, "")
+	log.SetFlags(0)
+	convs := newPanes(tapp, flog, msglog, th, msginput)
+	log.SetOutput(io.MultiWriter(
+		flog,
+		levelFilter{
+			w: styledWriter{
+				w: newStampedWriter(viewWriter(tapp, msglog),
+					timeFmt, dayFmt),
+				color: th.systemColor,
+			},
+			keep: shownLevels,
+		},
+		// The debug pane has the technical log whether it is shown or not
+		levelFilter{
+			w: newStampedWriter(viewWriter(tapp, convs.debug.view),
+				logFileFormat, ""),
+			keep: debugLevel,
+		},
+	))
+	convs.timeFmt, convs.dayFmt = timeFmt, dayFmt
+	chatPanes = convs
+	var bar *sidebar
+	if connections != nil {
+		bar = newSidebar()
+		bar.unread = convs.unreadCount
+		bar.known = peers
+		bar.list.SetFocusFunc(keepFocus)
+		go updateSidebar(tapp, bar, connections, statusInterval)
+	}
+	pages := tview.NewPages()
+	history := loadInputHistory()
+	keys := newKeymap(cfg.Keys)
 	msginput.SetDoneFunc(func(key tcell.Key) {
-		onInput(msginput, rtcpeer, tapp, key)
+		txt := msginput.GetText()
+		if key == tcell.KeyEnter {
+			history.add(txt)
+		}
+		if query, ok := helpQuery(txt); ok && key == tcell.KeyEnter {
+			msginput.SetText("")
+			showHelp(tapp, pages, msginput, query)
+			return
+		}
+		switch key {
+		case tcell.KeyTab:
+			// Tab completes commands, and switches panes otherwise
+			if strings.HasPrefix(txt, "/") {
+				var known []string
+				if peers != nil {
+					known = peers()
+				}
+				completed, matches := complete(txt, known)
+				msginput.SetText(completed)
+				if len(matches) > 0 {
+					log.Println(strings.Join(matches, "  "))
+				}
+				return
+			}
+			convs.cycle(1)
+			return
+		case tcell.KeyBacktab:
+			convs.cycle(-1)
+			return
+		}
+		// Messages entered in the pane of a peer go to that peer only
+		remote := convs.currentPeer()
+		if key == tcell.KeyEnter && remote != "" &&
+			!strings.HasPrefix(txt, "/") {
+			msginput.SetText("")
+			exec("/msg "+remote+" "+txt, tapp.Stop)
+			return
+		}
+		if bar != nil && key == tcell.KeyEnter {
+			msginput.SetText(bar.target(txt))
+		}
+		onInput(msginput, exec, tapp, key)
 	})
+	msginput.SetInputCapture(func(ev *tcell.EventKey) *tcell.EventKey {
+		if cmd, ok := keys.lookup(ev); ok {
+			if bar != nil {
+				cmd = bar.target(cmd)
+			}
+			log.Println("you:", cmd)
+			exec(cmd, tapp.Stop)
+			return nil
+		}
+		// Home and End move the cursor while there is text to move it in
+		if (ev.Key() == tcell.KeyHome || ev.Key() == tcell.KeyEnd) &&
+			msginput.GetText() != "" {
+			return ev
+		}
+		if convs.scroll(ev.Key()) {
+			return nil
+		}
+		// Alt with the arrows moves through the sidebar, the arrows alone
+		// through the history
+		if bar != nil && ev.Modifiers()&tcell.ModAlt != 0 {
+			switch ev.Key() {
+			case tcell.KeyUp:
+				bar.move(-1)
+				return nil
+			case tcell.KeyDown:
+				bar.move(1)
+				return nil
+			}
+		}
+		switch ev.Key() {
+		case tcell.KeyUp:
+			if line, ok := history.prev(msginput.GetText()); ok {
+				msginput.SetText(line)
+			}
+			return nil
+		case tcell.KeyDown:
+			if line, ok := history.next(); ok {
+				msginput.SetText(line)
+			}
+			return nil
+		}
+		return ev
+	})
+	if typing != nil {
+		msginput.SetChangedFunc(func(text string) {
+			// A /msg without an address goes to the highlighted
+			// connection, as when entered
+			remote := convs.currentPeer()
+			if remote == "" && bar != nil {
+				text = bar.target(text)
+			}
+			typing(remote, text)
+		})
+	}
+	if typingPeer != nil {
+		go updateTyping(tapp, convs, typingPeer, statusInterval)
+	}
 	grid := tview.NewGrid().
-		SetRows(0, 1).
 		SetColumns(0).
 		SetBorders(true)
-	grid.AddItem(msglog, 0, 0, 1, 1, 0, 0, false)
-	grid.AddItem(msginput, 1, 0, 1, 1, 0, 0, true)
-	go rtcpeer.Listen()
-	defer rtcpeer.CloseAll()
-	if err := tapp.SetRoot(grid, true).Run(); err != nil {
+	// The sidebar, if there is one, goes left of the panes
+	col, cols := 0, 1
+	if bar != nil {
+		grid.SetColumns(sidebarWidth, 0)
+		grid.AddItem(bar.list, 0, 0, 2, 1, 0, 0, false)
+		col, cols = 1, 2
+	}
+	grid.AddItem(convs.tabbar, 0, col, 1, 1, 0, 0, false)
+	grid.AddItem(convs.pages, 1, col, 1, 1, 0, 0, false)
+	if status != nil {
+		statusbar := tview.NewTextView()
+		statusbar.SetFocusFunc(keepFocus)
+		go updateStatus(tapp, statusbar, status, statusInterval)
+		grid.SetRows(1, 0, 1, 1)
+		grid.AddItem(statusbar, 2, 0, 1, cols, 0, 0, false)
+		grid.AddItem(msginput, 3, 0, 1, cols, 0, 0, true)
+	} else {
+		grid.SetRows(1, 0, 1)
+		grid.AddItem(msginput, 2, 0, 1, cols, 0, 0, true)
+	}
+	pages.AddPage("main", grid, true, true)
+	callPrompts = newPrompts(tapp, pages, msginput, func(cmd string) {
+		exec(cmd, tapp.Stop)
+	})
+	notifier = newAttention(tapp, msginput, cfg.Notify)
+	tapp.SetInputCapture(func(ev *tcell.EventKey) *tcell.EventKey {
+		notifier.touch()
+		return ev
+	})
+	go start()
+	go serveCommands(func(cmd string) {
+		tapp.QueueUpdate(func() {
+			exec(cmd, tapp.Stop)
+		})
+	})
+	if err := tapp.SetRoot(pages, true).Run(); err != nil {
 		panic(err)
 	}
-	os.Exit(0)
+}
+
+// showHelp shows the answer to /help query in a scrollable modal over the
+// main screen, until closed with Escape or Enter
+func showHelp(
+	tapp *tview.Application,
+	pages *tview.Pages,
+	back tview.Primitive,
+	query string,
+) {
+	text := tview.NewTextView().
+		SetText(helpText(query)).
+		SetScrollable(true).
+		SetWordWrap(true)
+	text.SetBorder(true).
+		SetTitle(" help (Esc to close) ")
+	text.SetDoneFunc(func(key tcell.Key) {
+		pages.RemovePage("help")
+		tapp.SetFocus(back)
+	})
+	modal := tview.NewGrid().
+		SetColumns(0, 80, 0).
+		SetRows(0, 20, 0).
+		AddItem(text, 1, 1, 1, 1, 0, 0, true)
+	pages.AddPage("help", modal, true, true)
+	tapp.SetFocus(text)
 }
 
 func init() {